@@ -0,0 +1,830 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrdaemon"
+	"github.com/sammooredev/WebRecon/wrdist"
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrmetrics"
+	"github.com/sammooredev/WebRecon/wrnotify"
+	"github.com/sammooredev/WebRecon/wrreport"
+	"github.com/sammooredev/WebRecon/wrserve"
+	"github.com/sammooredev/WebRecon/wrtools"
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// SUBCOMMAND FUNCTIONS
+// subcommands are the first argument (./WebRecon <subcommand> ...). anything else is treated as a program name to run.
+var subcommands = map[string]func(args []string){
+	"init":      InitProgram,
+	"programs":  ManagePrograms,
+	"asndb":     ManageASNDatabase,
+	"export":    ExportRun,
+	"inventory": ManageInventory,
+	"query":     QueryInventory,
+	"notify":    TestNotifications,
+	"daemon":    RunDaemon,
+	"serve":     ServeAPI,
+	"worker":    RunWorker,
+}
+
+// subcommands which can write their results to stdout, so nothing else should be printed there
+var stdoutSubcommands = map[string]bool{
+	"export": true,
+	"query":  true,
+}
+
+// function to run a subcommand if the first argument names one. returns whether a subcommand was run.
+func RunSubcommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	command, ok := subcommands[args[0]]
+	if !ok {
+		return false
+	}
+	command(args[1:])
+	return true
+}
+
+// parses flags which may appear before or after positional arguments (./WebRecon init <name> --scope-file foo.csv). returns the positional arguments.
+func parseInterspersedFlags(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// function to create the directory structure for a new program, optionally importing its scope from a bug bounty platform export
+func InitProgram(args []string) {
+	out := wrlog.NewConsoleOutput()
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	scope_file := flags.String("scope-file", "", "Scope export (CSV/JSON) downloaded from HackerOne or Bugcrowd")
+	force := flags.Bool("force", false, "Overwrite an existing domains.txt and scope.txt")
+	positional := parseInterspersedFlags(flags, args)
+	if len(positional) != 1 {
+		out.Writeln("\n<error>ERROR! - Usage: ./WebRecon init \\<name> [--scope-file \\<file>] [--force]</error>")
+		os.Exit(1)
+	}
+	program_name := positional[0]
+	if !wrutils.ValidProgramName(program_name) {
+		out.Writeln("\n<error>ERROR! - Invalid program name " + program_name + ". Use letters, numbers, '.', '_' and '-'.</error>")
+		os.Exit(1)
+	}
+	recon_data := wrutils.ReconDataDirectory(program_name)
+
+	if err := wrutils.CreateProgram(program_name); err != nil {
+		out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+	out.Writeln("<info>INFO - Created program directory " + recon_data + "</info>")
+
+	var domains, rules []string
+	if *scope_file != "" {
+		assets, err := wrutils.ReadScopeExport(*scope_file)
+		if err != nil {
+			out.Writeln("\n<error>ERROR! - Could not read scope export " + *scope_file + " - " + err.Error() + "</error>")
+			os.Exit(1)
+		}
+		var skipped []string
+		domains, rules, skipped = wrutils.ScopeAssetsToRules(assets)
+		for _, s := range skipped {
+			out.Writeln("\t<comment>SKIPPED - " + s + " is not a domain or wildcard asset</comment>")
+		}
+	}
+
+	// only write the files if they don't exist yet, or if they were imported and -force was given
+	write := func(name string, lines []string, header string) {
+		path := recon_data + name
+		if _, err := os.Stat(path); err == nil && (len(lines) == 0 || !*force) {
+			out.Writeln("\t<comment>NOTE - " + path + " already exists, leaving it untouched (use --force to overwrite)</comment>")
+			return
+		}
+		content := header
+		if len(lines) > 0 {
+			content += strings.Join(lines, "\n") + "\n"
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+			os.Exit(1)
+		}
+		out.Writeln("<info>INFO - Wrote " + path + "</info>")
+	}
+	write("domains.txt", domains, "")
+	if len(rules) > 0 {
+		write("scope.txt", rules, "# imported from "+*scope_file+"\n")
+	}
+
+	for _, domain := range domains {
+		out.Writeln("\t<comment>" + domain + "</comment>")
+	}
+	out.Writeln("\n<info>Run enumeration with: ./WebRecon " + program_name + "</info>")
+}
+
+// function to print the usage of the programs subcommand
+func printProgramsHelp() {
+	out := wrlog.NewConsoleOutput()
+	out.Writeln("<b>usage: ./WebRecon programs \\<command></b>\n" +
+		"\t<info>list                    List programs with their domain and run counts</info>\n" +
+		"\t<info>create \\<name>           Create a new program (same as ./WebRecon init)</info>\n" +
+		"\t<info>show \\<name>             Summarise a program's runs (dates, subdomain counts, durations)</info>\n" +
+		"\t<info>archive \\<name>          Move a program to ./Programs/.archive</info>\n" +
+		"\t<info>delete [-y] \\<name>      Permanently delete a program and all of its runs</info>")
+	os.Exit(1)
+}
+
+// function to list, create, show, archive and delete programs
+func ManagePrograms(args []string) {
+	if len(args) == 0 {
+		printProgramsHelp()
+	}
+	if args[0] == "create" {
+		InitProgram(args[1:])
+		return
+	}
+
+	out := wrlog.NewConsoleOutput()
+	flags := flag.NewFlagSet("programs "+args[0], flag.ExitOnError)
+	yes := flags.Bool("y", false, "Don't ask for confirmation before deleting")
+	positional := parseInterspersedFlags(flags, args[1:])
+
+	if args[0] == "list" {
+		listPrograms()
+		return
+	}
+	if len(positional) != 1 {
+		printProgramsHelp()
+	}
+	program_name := positional[0]
+	if !wrutils.ProgramExists(program_name) {
+		out.Writeln("\n<error>ERROR! - Program " + program_name + " does not exist in ./Programs</error>")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "show":
+		showProgram(program_name)
+	case "archive":
+		if err := wrutils.ArchiveProgram(program_name); err != nil {
+			out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+			os.Exit(1)
+		}
+		out.Writeln("<info>INFO - Archived " + program_name + " to " + wrutils.ArchiveDirectory + "</info>")
+	case "delete":
+		if !*yes {
+			out.Writeln("<comment>This permanently deletes " + wrutils.ProgramsDirectory + program_name + " and all of its runs. Type the program name to confirm:</comment>")
+			reader := bufio.NewReader(os.Stdin)
+			answer, _ := reader.ReadString('\n')
+			if strings.TrimSpace(answer) != program_name {
+				out.Writeln("<comment>Aborted.</comment>")
+				os.Exit(1)
+			}
+		}
+		if err := wrutils.DeleteProgram(program_name); err != nil {
+			out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+			os.Exit(1)
+		}
+		out.Writeln("<info>INFO - Deleted " + program_name + "</info>")
+	default:
+		printProgramsHelp()
+	}
+}
+
+// prints a table of every program, its number of domains and runs, and its last run
+func listPrograms() {
+	out := wrlog.NewConsoleOutput()
+	programs := wrutils.ListPrograms()
+	if len(programs) == 0 {
+		out.Writeln("<comment>No programs found. Create one with ./WebRecon init \\<name></comment>")
+		return
+	}
+	var table strings.Builder
+	writer := tabwriter.NewWriter(&table, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(writer, "PROGRAM\tDOMAINS\tRUNS\tLAST RUN\tSTATUS\tSUBDOMAINS")
+	for _, program_name := range programs {
+		domains := wrutils.CountLines(wrutils.ProgramsDirectory + program_name + "/recon-data/domains.txt")
+		runs := wrutils.ListRuns(program_name)
+		last_run, status, subdomains := "-", "-", "-"
+		if len(runs) > 0 {
+			last := runs[len(runs)-1]
+			last_run, status, subdomains = last.Date, last.Status, fmt.Sprint(last.Subdomains)
+		}
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%s\t%s\t%s\n", program_name, domains, len(runs), last_run, status, subdomains)
+	}
+	writer.Flush()
+	out.Writeln("<info>" + table.String() + "</info>")
+}
+
+// prints a program's domains, scope and a table of its runs
+func showProgram(program_name string) {
+	out := wrlog.NewConsoleOutput()
+	recon_data := wrutils.ProgramsDirectory + program_name + "/recon-data/"
+	domains := wrutils.WordlistToArray(recon_data + "domains.txt")
+	scope := wrutils.LoadScope(program_name, domains)
+
+	out.Writeln("<b>" + program_name + "</b>")
+	out.Writeln("\n<info><b>Domains:</b></info>")
+	for _, domain := range domains {
+		out.Writeln("\t<comment>" + domain + "</comment>")
+	}
+	out.Writeln("\n<info><b>Scope:</b></info>")
+	for _, rule := range scope.Rules() {
+		out.Writeln("\t<comment>" + rule + "</comment>")
+	}
+
+	runs := wrutils.ListRuns(program_name)
+	out.Writeln(fmt.Sprintf("\n<info><b>Runs (%d):</b></info>", len(runs)))
+	if len(runs) == 0 {
+		return
+	}
+	var table strings.Builder
+	writer := tabwriter.NewWriter(&table, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(writer, "DATE\tSTATUS\tSTARTED\tDURATION\tSUBDOMAINS\tNEW")
+	previous := map[string]bool{}
+	for index, run := range runs {
+		// hosts which weren't found by the previous run
+		current := map[string]bool{}
+		for _, host := range wrutils.WordlistToArray(wrutils.RunDirectory(program_name, run.Date) + "final_list_unique.out") {
+			current[host] = true
+		}
+		new_hosts := "-"
+		if index > 0 && run.Status == wrutils.RunStatusComplete {
+			count := 0
+			for host := range current {
+				if !previous[host] {
+					count += 1
+				}
+			}
+			new_hosts = fmt.Sprint(count)
+		}
+		if run.Status == wrutils.RunStatusComplete {
+			previous = current
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%s\n", run.Date, run.Status, run.Started.Format("2006-01-02 15:04"), run.Duration(), run.Subdomains, new_hosts)
+	}
+	writer.Flush()
+	out.Writeln("<info>" + table.String() + "</info>")
+}
+
+// function to import an IP-to-ASN dataset into ./wordlists/ip2asn.tsv, which is used to tag resolved addresses with their ASN and provider
+func ManageASNDatabase(args []string) {
+	out := wrlog.NewConsoleOutput()
+	if len(args) != 2 || args[0] != "import" {
+		out.Writeln("<b>usage: ./WebRecon asndb import \\<file></b>\n" +
+			"\t<info>Supported formats (optionally .gz compressed):</info>\n" +
+			"\t<info>  iptoasn.com TSV (ip2asn-v4.tsv, ip2asn-combined.tsv, ip2asn-v4-u32.tsv)</info>\n" +
+			"\t<info>  MaxMind GeoLite2 ASN CSV (GeoLite2-ASN-Blocks-IPv4.csv, GeoLite2-ASN-Blocks-IPv6.csv)</info>\n" +
+			"\t<info>  CSV of cidr,asn,org or start,end,asn,org</info>")
+		os.Exit(1)
+	}
+	count, err := wrutils.ImportASNDatabase(args[1], wrutils.DefaultASNDatabase)
+	if err != nil {
+		out.Writeln("\n<error>ERROR! - Could not import " + args[1] + " - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+	out.Writeln(fmt.Sprintf("<info>INFO - Imported %d ranges into %s</info>", count, wrutils.DefaultASNDatabase))
+}
+
+// function to export a run's stored results as CSV, Markdown or JSON, without re-running anything. exports the latest run if none is given.
+func ExportRun(args []string) {
+	out := wrlog.NewConsoleOutput()
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "csv", "Export format: "+strings.Join(wrreport.ExportFormats, ", "))
+	output_path := flags.String("o", "", "File to write the export to (default stdout)")
+	positional := parseInterspersedFlags(flags, args)
+	if len(positional) < 1 || len(positional) > 2 || !wrutils.SliceContainsString(wrreport.ExportFormats, *format) {
+		out.Writeln("<b>usage: ./WebRecon export \\<program> [run] [--format csv|md|json] [-o \\<file>]</b>\n" +
+			"\t<info>csv     One row per subdomain with its sources, addresses, CNAMEs, ASN, provider and HTTP status</info>\n" +
+			"\t<info>md      Markdown summary of the run for pasting into tickets</info>\n" +
+			"\t<info>json    Subdomains, takeover candidates and wildcard zones as JSON</info>")
+		os.Exit(1)
+	}
+	program_name := positional[0]
+	if !wrutils.ProgramExists(program_name) {
+		out.Writeln("\n<error>ERROR! - Program " + program_name + " does not exist in ./Programs</error>")
+		os.Exit(1)
+	}
+	var date string
+	if len(positional) == 2 {
+		date = positional[1]
+	} else {
+		runs := wrutils.ListRuns(program_name)
+		if len(runs) == 0 {
+			out.Writeln("\n<error>ERROR! - Program " + program_name + " has no runs to export</error>")
+			os.Exit(1)
+		}
+		date = runs[len(runs)-1].Date
+	}
+
+	report, err := wrreport.BuildRunReport(program_name, date)
+	if err != nil {
+		out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+	writer := os.Stdout
+	if *output_path != "" {
+		writer, err = os.Create(*output_path)
+		if err != nil {
+			out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+			os.Exit(1)
+		}
+	}
+	err = wrreport.WriteExport(report, *format, writer)
+	if *output_path != "" {
+		if close_err := writer.Close(); err == nil {
+			err = close_err
+		}
+	}
+	if err != nil {
+		out.Writeln("\n<error>ERROR! - Could not export run " + date + " of " + program_name + " - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+	if *output_path != "" {
+		out.Writeln("<info>INFO - Exported run " + date + " of " + program_name + " to " + *output_path + "</info>")
+	}
+}
+
+// function to print the usage of the inventory subcommand
+func printInventoryHelp() {
+	out := wrlog.NewConsoleOutput()
+	out.Writeln("<b>usage: ./WebRecon inventory \\<command></b>\n" +
+		"\t<info>import \\<name>...|--all     Add a program's past runs to its inventory (runs already in it are skipped)</info>\n" +
+		"\t<info>show \\<name>               Summarise a program's inventory (hosts, runs, newest and disappeared hosts)</info>")
+	os.Exit(1)
+}
+
+// function to import historical runs into program inventories and summarise them
+func ManageInventory(args []string) {
+	if len(args) == 0 {
+		printInventoryHelp()
+	}
+	out := wrlog.NewConsoleOutput()
+	flags := flag.NewFlagSet("inventory "+args[0], flag.ExitOnError)
+	all := flags.Bool("all", false, "Import the runs of every program")
+	programs := parseInterspersedFlags(flags, args[1:])
+	if *all {
+		programs = wrutils.ListPrograms()
+	}
+	if len(programs) == 0 || (args[0] == "show" && len(programs) != 1) {
+		printInventoryHelp()
+	}
+	for _, program_name := range programs {
+		if !wrutils.ProgramExists(program_name) {
+			out.Writeln("\n<error>ERROR! - Program " + program_name + " does not exist in ./Programs</error>")
+			os.Exit(1)
+		}
+	}
+
+	switch args[0] {
+	case "import":
+		for _, program_name := range programs {
+			imported, err := wrutils.ImportRuns(program_name)
+			if err != nil {
+				out.Writeln("\n<error>ERROR! - Could not update the inventory of " + program_name + " - " + err.Error() + "</error>")
+				os.Exit(1)
+			}
+			out.Writeln(fmt.Sprintf("<info>INFO - Imported %d runs of %s into %s</info>", len(imported), program_name, wrutils.InventoryPath()))
+		}
+	case "show":
+		showInventory(programs[0])
+	default:
+		printInventoryHelp()
+	}
+}
+
+// prints the size of a program's inventory, its most recently discovered hosts and the hosts its latest run no longer found
+func showInventory(program_name string) {
+	out := wrlog.NewConsoleOutput()
+	inventory, err := wrutils.LoadInventory(program_name)
+	if err != nil {
+		out.Writeln("\n<error>ERROR! - Could not read the inventory of " + program_name + " - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+	if len(inventory.Runs) == 0 {
+		out.Writeln("<comment>The inventory of " + program_name + " is empty. Import its runs with ./WebRecon inventory import " + program_name + "</comment>")
+		return
+	}
+	disappeared := inventory.Disappeared()
+	out.Writeln("<b>" + program_name + "</b>")
+	out.Writeln(fmt.Sprintf("\t<info>%d hosts from %d runs (%s to %s), %d of them not found by the latest run</info>",
+		len(inventory.Assets), len(inventory.Runs), inventory.Runs[0].Date, inventory.LatestRun(), len(disappeared)))
+
+	assets := inventory.SortedAssets()
+	sort.SliceStable(assets, func(a, b int) bool { return assets[a].FirstSeen.After(assets[b].FirstSeen) })
+	printAssets := func(title string, assets []*wrutils.Asset) {
+		if len(assets) > 20 {
+			assets = assets[:20]
+		}
+		out.Writeln("\n<info><b>" + title + ":</b></info>")
+		var table strings.Builder
+		writer := tabwriter.NewWriter(&table, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(writer, "HOST\tFIRST SEEN\tLAST SEEN\tRUNS\tSOURCES")
+		for _, asset := range assets {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%s\n", asset.Host, asset.FirstRun, asset.LastRun, asset.Runs, strings.Join(asset.Sources, ","))
+		}
+		writer.Flush()
+		out.Writeln("<comment>" + table.String() + "</comment>")
+	}
+	printAssets("Newest hosts", assets)
+	if len(disappeared) > 0 {
+		printAssets("Hosts no longer found", disappeared)
+	}
+}
+
+// splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// function to query the asset inventories of programs, printing the matching hosts as a list, JSON or CSV
+func QueryInventory(args []string) {
+	out := wrlog.NewConsoleOutput()
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	programs := flags.String("program", "", "Comma-separated programs to query (default all)")
+	domains := flags.String("domain", "", "Comma-separated root domains the hosts belong to")
+	sources := flags.String("source", "", "Comma-separated sources which found the hosts (subfinder, amass, sub-generator, ptr-sweep, dnsgen)")
+	first_after := flags.String("first-seen-after", "", "Hosts first seen after a date (YYYY-MM-DD, MM-DD-YYYY) or age (7d, 12h)")
+	first_before := flags.String("first-seen-before", "", "Hosts first seen before a date (YYYY-MM-DD, MM-DD-YYYY) or age (7d, 12h)")
+	addresses := flags.String("ip", "", "Comma-separated IPs or CIDR ranges the hosts resolve to")
+	cnames := flags.String("cname", "", "Comma-separated CNAME patterns (substring, or glob with *)")
+	providers := flags.String("provider", "", "Comma-separated hosting providers or ASN organisations (substring, or glob with *)")
+	statuses := flags.String("status", "", "Comma-separated HTTP statuses (200, 3xx)")
+	alive := flags.Bool("alive", false, "Only hosts which answered HTTP in their latest run")
+	dead := flags.Bool("dead", false, "Only hosts which didn't answer HTTP in their latest run")
+	format := flags.String("format", "list", "Output format: list, json or csv")
+	output_path := flags.String("o", "", "File to write the results to (default stdout)")
+	if positional := parseInterspersedFlags(flags, args); len(positional) > 0 || *alive && *dead || !wrutils.SliceContainsString([]string{"list", "json", "csv"}, *format) {
+		out.Writeln("<b>usage: ./WebRecon query [filters] [--format list|json|csv] [-o \\<file>]</b>\n" +
+			"\t<info>e.g. hosts first seen this week on AWS that return 200:</info>\n" +
+			"\t<info>$ ./WebRecon query --first-seen-after 7d --provider aws --status 200</info>")
+		flags.SetOutput(os.Stdout)
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	query := wrutils.AssetQuery{
+		Domains:   splitList(*domains),
+		Sources:   splitList(*sources),
+		CNAMEs:    splitList(*cnames),
+		Providers: splitList(*providers),
+		Statuses:  splitList(*statuses),
+		Alive:     *alive,
+		Dead:      *dead,
+	}
+	var err error
+	fail := func(err error) {
+		out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+	if *first_after != "" {
+		if query.FirstAfter, err = wrutils.ParseQueryTime(*first_after); err != nil {
+			fail(err)
+		}
+	}
+	if *first_before != "" {
+		if query.FirstBefore, err = wrutils.ParseQueryTime(*first_before); err != nil {
+			fail(err)
+		}
+	}
+	for _, address := range splitList(*addresses) {
+		prefix, err := wrutils.ParseQueryAddress(address)
+		if err != nil {
+			fail(fmt.Errorf("invalid IP or CIDR %s", address))
+		}
+		query.Addresses = append(query.Addresses, prefix)
+	}
+	program_list := splitList(*programs)
+	if len(program_list) == 0 {
+		program_list = wrutils.ListPrograms()
+	}
+	for _, program_name := range program_list {
+		if !wrutils.ProgramExists(program_name) {
+			fail(fmt.Errorf("program %s does not exist in ./Programs", program_name))
+		}
+	}
+
+	results, err := wrutils.QueryInventories(program_list, query)
+	if err != nil {
+		fail(err)
+	}
+	writer := os.Stdout
+	if *output_path != "" {
+		if writer, err = os.Create(*output_path); err != nil {
+			fail(err)
+		}
+		defer writer.Close()
+	}
+	switch *format {
+	case "list":
+		for _, result := range results {
+			fmt.Fprintln(writer, result.Host)
+		}
+	case "json":
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(results)
+	case "csv":
+		err = writeAssetsCSV(results, writer)
+	}
+	if err != nil {
+		fail(err)
+	}
+	if *output_path != "" {
+		out.Writeln(fmt.Sprintf("<info>INFO - Wrote %d hosts to %s</info>", len(results), *output_path))
+	}
+}
+
+// writes one CSV row per asset. multiple values in a column are separated by spaces.
+func writeAssetsCSV(results []wrutils.ProgramAsset, w *os.File) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"program", "host", "domain", "first_seen", "last_seen", "runs", "sources", "ips", "cname", "provider", "http_status", "title"})
+	for _, result := range results {
+		var providers []string
+		for _, info := range result.Record.IPs {
+			if info.Provider != "" && !wrutils.SliceContainsString(providers, info.Provider) {
+				providers = append(providers, info.Provider)
+			}
+		}
+		status, title := "", ""
+		if result.IsAlive() {
+			status, title = fmt.Sprint(result.Record.HTTP.Status), result.Record.HTTP.Title
+		}
+		writer.Write([]string{
+			result.Program,
+			result.Host,
+			result.Domain,
+			result.FirstSeen.Format(time.RFC3339),
+			result.LastSeen.Format(time.RFC3339),
+			fmt.Sprint(result.Runs),
+			strings.Join(result.Sources, " "),
+			strings.Join(result.Record.Addresses(), " "),
+			strings.Join(result.Record.CNAME, " "),
+			strings.Join(providers, " "),
+			status,
+			title,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// function to send a run event to every notifier in ./notify.json, to check they are set up. uses the latest run of a program if one is given.
+func TestNotifications(args []string) {
+	out := wrlog.NewConsoleOutput()
+	if len(args) == 0 || args[0] != "test" || len(args) > 2 {
+		out.Writeln("<b>usage: ./WebRecon notify test [\\<name>]</b>\n" +
+			"\t<info>Sends the latest run of a program (or an example run) to every notifier in " + wrnotify.DefaultConfigFile + "</info>")
+		os.Exit(1)
+	}
+	notifiers, err := wrnotify.LoadNotifiers(wrnotify.DefaultConfigFile)
+	if err != nil {
+		out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+	if len(notifiers) == 0 {
+		out.Writeln("<comment>No notifiers are configured in " + wrnotify.DefaultConfigFile + "</comment>")
+		os.Exit(1)
+	}
+
+	event := wrnotify.ExampleEvent()
+	if len(args) == 2 {
+		program_name := args[1]
+		runs := wrutils.ListRuns(program_name)
+		if !wrutils.ProgramExists(program_name) || len(runs) == 0 {
+			out.Writeln("\n<error>ERROR! - Program " + program_name + " has no runs in ./Programs</error>")
+			os.Exit(1)
+		}
+		report, err := wrreport.BuildRunReport(program_name, runs[len(runs)-1].Date)
+		if err != nil {
+			out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+			os.Exit(1)
+		}
+		event = wrnotify.RunEvent(report)
+	}
+
+	failed := false
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(event); err != nil {
+			out.Writeln("<error>ERROR! - " + notifier.Name() + ": " + err.Error() + "</error>")
+			failed = true
+			continue
+		}
+		out.Writeln("<info>INFO - Notified " + notifier.Name() + "</info>")
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// function to run programs on the cron schedules in ./schedule.txt until interrupted, or to print the daemon's history
+func RunDaemon(args []string) {
+	out := wrlog.NewConsoleOutput()
+	if len(args) > 0 && args[0] == "history" {
+		showDaemonHistory(args[1:])
+		return
+	}
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	schedule_file := flags.String("schedule", wrdaemon.DefaultScheduleFile, "Schedule of the programs to run")
+	concurrency := flags.Int("concurrency", 1, "Maximum number of runs at once")
+	metrics_address := flags.String("metrics", "", "Address to serve Prometheus metrics on, e.g. 127.0.0.1:9090")
+	log_flags := wrlog.AddFlags(flags)
+	if positional := parseInterspersedFlags(flags, args); len(positional) > 0 {
+		out.Writeln("<b>usage: ./WebRecon daemon [-schedule \\<file>] [-concurrency \\<n>] [-metrics \\<address>] [-v|-q] [-log-json]</b>\n" +
+			"\t<info>Runs programs on the cron schedules in " + wrdaemon.DefaultScheduleFile + ", one per line: \\<minute> \\<hour> \\<day> \\<month> \\<weekday> [flags] \\<name></info>\n" +
+			"\t<info>With -metrics, serves Prometheus metrics on http://\\<address>/metrics</info>\n" +
+			"<b>usage: ./WebRecon daemon history [\\<name>] [-n \\<runs>]</b>\n" +
+			"\t<info>Lists the runs the daemon started or skipped</info>")
+		os.Exit(1)
+	}
+
+	log_flags.Configure()
+	wrutils.VerifyDependencies()
+	notifications := LoadNotifications()
+	daemon, err := wrdaemon.NewDaemon(*schedule_file, *concurrency, notifications)
+	if err != nil {
+		out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+	// the daemon stops on Ctrl-C or SIGTERM, cancelling the runs in progress
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *metrics_address != "" {
+		out.Writeln("<info>INFO - Serving metrics on http://" + *metrics_address + "/metrics</info>")
+		go func() {
+			if err := wrmetrics.Serve(ctx, *metrics_address); err != nil {
+				out.Writeln("\n<error>ERROR! - Could not serve metrics - " + err.Error() + "</error>")
+				os.Exit(1)
+			}
+		}()
+	}
+	daemon.Run(ctx)
+}
+
+// prints the latest runs the daemon started or skipped, optionally of a single program
+func showDaemonHistory(args []string) {
+	out := wrlog.NewConsoleOutput()
+	flags := flag.NewFlagSet("daemon history", flag.ExitOnError)
+	limit := flags.Int("n", 20, "Number of runs to list")
+	positional := parseInterspersedFlags(flags, args)
+	program_name := ""
+	if len(positional) > 1 {
+		out.Writeln("\n<error>ERROR! - Usage: ./WebRecon daemon history [\\<name>] [-n \\<runs>]</error>")
+		os.Exit(1)
+	} else if len(positional) == 1 {
+		program_name = positional[0]
+	}
+	history, err := wrdaemon.ReadHistory(program_name)
+	if err != nil {
+		out.Writeln("\n<error>ERROR! - Could not read " + wrdaemon.HistoryFile + " - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+	if len(history) == 0 {
+		out.Writeln("<comment>The daemon hasn't run anything yet</comment>")
+		return
+	}
+	if *limit > 0 && len(history) > *limit {
+		history = history[len(history)-*limit:]
+	}
+	var table strings.Builder
+	writer := tabwriter.NewWriter(&table, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(writer, "SCHEDULED\tPROGRAM\tSTATUS\tDURATION\tSUBDOMAINS\tERROR")
+	for _, entry := range history {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%s\n", entry.Scheduled.Format("2006-01-02 15:04"), entry.Program, entry.Status, entry.Duration(), entry.Subdomains, entry.Error)
+	}
+	writer.Flush()
+	out.Writeln("<info>" + table.String() + "</info>")
+}
+
+// function to serve the HTTP API until interrupted
+func ServeAPI(args []string) {
+	out := wrlog.NewConsoleOutput()
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := flags.String("listen", "127.0.0.1:8080", "Address to listen on")
+	token_env := flags.String("token-env", "WEBRECON_API_TOKEN", "Environment variable holding the API token")
+	log_flags := wrlog.AddFlags(flags)
+	if positional := parseInterspersedFlags(flags, args); len(positional) > 0 {
+		out.Writeln("<b>usage: ./WebRecon serve [-listen \\<address>] [-token-env \\<variable>] [-v|-q] [-log-json]</b>\n" +
+			"\t<info>Serves the HTTP API and the web UI. API requests need the token in $WEBRECON_API_TOKEN (Authorization: Bearer \\<token>); one is generated if it isn't set.</info>")
+		os.Exit(1)
+	}
+	log_flags.Configure()
+	token := os.Getenv(*token_env)
+	if token == "" {
+		// never serve without authentication; a generated token only lasts until the server stops
+		random := make([]byte, 24)
+		rand.Read(random)
+		token = hex.EncodeToString(random)
+		out.Writeln("<comment>NOTE - $" + *token_env + " is not set, generated the API token " + token + "</comment>")
+	}
+
+	wrutils.VerifyDependencies()
+	notifiers, err := wrnotify.LoadNotifiers(wrnotify.DefaultConfigFile)
+	if err != nil {
+		out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+	// the server stops on Ctrl-C or SIGTERM, cancelling the runs in progress
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := wrserve.NewServer(token, wrserve.NewRunManager(ctx, notifiers))
+	out.Writeln("<info>INFO - Serving the API on http://" + *listen + "/api/, metrics on http://" + *listen + "/metrics and the web UI on http://" + *listen + "/</info>")
+	if err := wrserve.Serve(ctx, *listen, server); err != nil {
+		out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+}
+
+// function to resolve chunks for a run started with -distribute until interrupted
+func RunWorker(args []string) {
+	out := wrlog.NewConsoleOutput()
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	hostname, _ := os.Hostname()
+	name := flags.String("name", hostname, "Name of the worker in the coordinator's logs")
+	token_env := flags.String("token-env", wrdist.TokenEnv, "Environment variable holding the worker token")
+	concurrency := flags.Int("concurrency", 1, "Number of chunks to resolve at once")
+	qps := flags.Int("qps", 0, "Maximum DNS queries per second across all resolvers (0 for no limit)")
+	resolver_qps := flags.Int("resolver-qps", 0, "Maximum DNS queries per second to each resolver (0 for no limit). puredns is limited to this times the number of resolvers in total, not for each resolver")
+	ca_file := flags.String("ca", "", "PEM certificate to verify an https:// coordinator with, e.g. its self-signed certificate")
+	log_flags := wrlog.AddFlags(flags)
+	positional := parseInterspersedFlags(flags, args)
+	if len(positional) != 1 || *concurrency < 1 || *qps < 0 || *resolver_qps < 0 {
+		out.Writeln("<b>usage: ./WebRecon worker [-name \\<name>] [-concurrency \\<n>] [-qps \\<n>] [-resolver-qps \\<n>] [-ca \\<certificate>] [-token-env \\<variable>] [-v|-q] [-log-json] \\<coordinator url></b>\n" +
+			"\t<info>Resolves chunks of candidates for a run started with -distribute, e.g. ./WebRecon worker https://10.0.0.5:8090. The token in $" + wrdist.TokenEnv + " must match the run's.</info>")
+		os.Exit(1)
+	}
+	var roots *x509.CertPool
+	if *ca_file != "" {
+		var err error
+		if roots, err = wrdist.LoadRoots(*ca_file); err != nil {
+			out.Writeln("\n<error>ERROR! - Could not read the -ca certificate - " + err.Error() + "</error>")
+			os.Exit(1)
+		}
+	}
+	log_flags.Configure()
+	token := os.Getenv(*token_env)
+	if token == "" {
+		out.Writeln("\n<error>ERROR! - $" + *token_env + " is not set. Set it to the token the run was started with</error>")
+		os.Exit(1)
+	}
+	// the chunks are resolved with puredns and this machine's resolvers
+	if _, err := exec.LookPath("puredns"); err != nil {
+		out.Writeln("\n<error>ERROR! - puredns could not be found in your PATH</error>")
+		os.Exit(1)
+	}
+	if len(wrtools.ReadNameservers()) == 0 {
+		out.Writeln("\n<error>ERROR! - No resolvers found in " + wrtools.ResolversFile + "</error>")
+		os.Exit(1)
+	}
+
+	if strings.HasPrefix(positional[0], "http://") {
+		out.Writeln("<comment>WARNING - the token and the candidates are sent to " + positional[0] + " in clear text. Only use http:// on a trusted network</comment>")
+	}
+	// the worker stops on Ctrl-C or SIGTERM; the chunks it was resolving are handed out again once their leases run out
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	limits := wrtools.NewLimits(*qps, *resolver_qps, 0, false)
+	out.Writeln(fmt.Sprintf("<info>INFO - Resolving chunks for %s as %s, %d at a time</info>", positional[0], *name, *concurrency))
+	var wg sync.WaitGroup
+	for index := 0; index < *concurrency; index++ {
+		worker_name := *name
+		if *concurrency > 1 {
+			worker_name = fmt.Sprintf("%s-%d", *name, index+1)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wrdist.NewWorker(positional[0], token, worker_name, roots, limits).Run(ctx)
+		}()
+	}
+	wg.Wait()
+}
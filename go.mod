@@ -7,4 +7,7 @@ require (
 	github.com/jpillora/go-tld v1.2.1
 )
 
-require golang.org/x/net v0.17.0 // indirect
+require (
+	golang.org/x/net v0.17.0
+	golang.org/x/text v0.13.0 // indirect
+)
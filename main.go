@@ -1,222 +1,155 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
-	"strings"
-	"sync"
-	"time"
+	"os/signal"
+	"syscall"
 
-	"github.com/sammooredev/WebRecon/wrtools"
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrnotify"
+	"github.com/sammooredev/WebRecon/wrprogress"
+	"github.com/sammooredev/WebRecon/wrrun"
 	"github.com/sammooredev/WebRecon/wrutils"
 
 	"github.com/DrSmithFr/go-console/pkg/input"
-	"github.com/DrSmithFr/go-console/pkg/output"
 	"github.com/DrSmithFr/go-console/pkg/style"
 )
 
 // SHELL SYNTAX FUNCTIONS
 // function to print help
 func PrintHelp() {
-	out := output.NewConsoleOutput(true, nil)
+	out := wrlog.NewConsoleOutput()
 	out.Writeln("<b>to run WebRecon, run the following commands. replace \\<name> with program name of your choice.\n\n" +
 		"<comment>\t1. Create a directory for the test</comment>\n" +
-		"\t\t<info>$ mkdir -p ./Programs/\\<name>/recon-data</info>\n" +
+		"\t\t<info>$ ./WebRecon init \\<name></info>\n" +
+		"\t\t<info>OR import the scope table downloaded from HackerOne/Bugcrowd (CSV or JSON) into domains.txt and scope.txt:</info>\n" +
+		"\t\t<info>$ ./WebRecon init \\<name> --scope-file \\<file> [--force]</info>\n" +
+		"\t\t<info>List, show, archive and delete programs with: ./WebRecon programs list|create|show|archive|delete</info>\n" +
+		"\t\t<info>Export a run as CSV, Markdown or JSON with: ./WebRecon export \\<name> [run] --format csv|md|json</info>\n" +
+		"\t\t<info>Import past runs into a program's inventory with: ./WebRecon inventory import \\<name>|--all</info>\n" +
+		"\t\t<info>Search every program's inventory with: ./WebRecon query [filters] (./WebRecon query -h for the filters)</info>\n" +
+		"\t\t<info>Run programs on cron schedules from ./schedule.txt with: ./WebRecon daemon [-concurrency \\<n>] [-metrics \\<address>]</info>\n" +
+		"\t\t<info>Create programs, start runs and browse results over HTTP and in a web UI with: ./WebRecon serve [-listen \\<address>]</info>\n" +
+		"\t\t<info>Resolve chunks for runs started with -distribute on other hosts with: ./WebRecon worker \\<coordinator url></info>\n" +
 		"\n\t<comment>2. Create a domains.txt file containing the domains to test</comment>\n" +
 		"\t\t<info>$ vim ./Programs/\\<name>/recon-data/domains.txt</info>\n\n" +
 		"\t\t<info>NOTE - Each domain should be on a newline:\n" +
 		"\t\t\tfoo.com\n" +
-		"\t\t\tbar.com</info>\n\n" +
+		"\t\t\tbar.com\n" +
+		"\t\tURLs, ports, *. prefixes and uppercase are normalized, IDNs are converted to punycode and lines starting with # are ignored</info>\n\n" +
+		"\t\t<info>OPTIONAL - Add scope rules to ./Programs/\\<name>/recon-data/scope.txt, one per line:\n" +
+		"\t\t\tfoo.com              only the host foo.com\n" +
+		"\t\t\t*.foo.com            any subdomain of foo.com\n" +
+		"\t\t\t/^api[0-9]+[.]bar[.]com$/ any host matching a regex\n" +
+		"\t\t\t!legacy.foo.com      exclude a host (works with all of the above)</info>\n\n" +
 		"\t<comment>3. Start enumeration on the program you set up</comment>\n" +
 		"\t\t<info>$ ./WebRecon [flags] \\<name></info>    * Note: \\<name> is the name of the directory in ./Programs/\\<name>\n" +
 		"\t\t\t<info>-atimeout    Maximum timeout for Amass (in minutes). Default 45 minutes</info>\n" +
 		"\t\t\t<info>-tools       Comma-separated list of enum tools. Default all (subfinder,amass,sub-generator)</info>\n" +
 		"\t\t\t<info>-wildcard    When enabled, runs PureDNS with wildcard filtering on (large time sink). Default false</info>\n" +
+		"\t\t\t<info>-ptr-max     Maximum number of addresses from ips.txt to sweep with PTR lookups. Default 65536</info>\n" +
+		"\t\t\t<info>-qps         Maximum DNS queries per second across all resolvers. Default 0 (no limit)</info>\n" +
+		"\t\t\t<info>-resolver-qps Maximum DNS queries per second to each resolver, enforced by the PTR sweep. puredns gets this times the number of resolvers as its total rate. Default 0 (no limit)</info>\n" +
+		"\t\t\t<info>-query-budget Maximum DNS queries for the whole run. Default 0 (no limit)</info>\n" +
+		"\t\t\t<info>-budget-exhausted What to do when the budget runs out: truncate (resolve the candidates that fit) or abort. Default truncate</info>\n" +
+		"\t\t\t<info>-stage-timeout Maximum run time of stages, e.g. subfinder=30m,puredns-stage-1=2h. Default none</info>\n" +
+		"\t\t\t<info>-stall-timeout Stop any stage which finds or processes nothing for this long, e.g. 20m. Default 0 (no limit)</info>\n" +
+		"\t\t\t<info>-distribute  Serve the resolution rounds in chunks to workers at \\<host>:\\<port> (needs $WEBRECON_WORKER_TOKEN). Default off</info>\n" +
+		"\t\t\t<info>-chunk-size  Number of candidates in each chunk handed to a worker. Default 50000</info>\n" +
+		"\t\t\t<info>-distribute-cert / -distribute-key  PEM certificate and key to serve the workers HTTPS with. Default off (HTTP)</info>\n" +
+		"\t\t\t<info>-sort-memory Maximum memory in MiB for combining and deduplicating intermediate files, larger ones are sorted on disk. Default 512</info>\n" +
+		"\t\t\t<info>-probe       Probe the resolved subdomains over HTTP(S) for the report. Default false (off)</info>\n" +
+		"\t\t\t<info>-dry-run     Print the stages, tool command lines, candidate counts, DNS queries and disk usage of the run, without running it</info>\n" +
+		"\t\t\t<info>-v / -q      Log debug messages (tool command lines and output) / only warnings and errors</info>\n" +
+		"\t\t\t<info>-log-json    Log JSON lines to stderr. Every run also logs everything to run.log in its directory</info>\n" +
 		"")
 	os.Exit(1)
 }
 
-// function to check whether a domains list exists. if it does, it prints out the domains to be in that file. Return a string array of the domains
-func CheckDomainsList(arg1 string) []string {
-	out := output.NewConsoleOutput(true, nil)
-	var domains []string
-	domains_list, err := os.Open("./Programs/" + arg1 + "/recon-data/domains.txt")
+// function to load the notifiers configured in ./notify.json. exits if the file is invalid, so a typo isn't found out after a long run.
+func LoadNotifications() *wrnotify.Dispatcher {
+	out := wrlog.NewConsoleOutput()
+	notifiers, err := wrnotify.LoadNotifiers(wrnotify.DefaultConfigFile)
 	if err != nil {
-		out.Writeln("\n<error>ERROR! - Did you add a domains.txt file to ./Programs/" + arg1 + "/recon-data/domains.txt </error>")
+		out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
 		os.Exit(1)
-	} else {
-		defer domains_list.Close()
-		scanner := bufio.NewScanner(domains_list)
-		scanner.Split(bufio.ScanLines)
-		for scanner.Scan() {
-			domains = append(domains, scanner.Text())
-		}
-		out.Writeln("\n<info><b>Domains to be tested: </info></b>")
-		for _, a := range domains {
-			out.Writeln("\t<comment>" + a + "</comment>")
-		}
-		out.Writeln("\n")
 	}
-	return domains
+	if len(notifiers) > 0 {
+		out.Writeln(fmt.Sprintf("<info>INFO - Sending notifications to %d notifiers from %s</info>", len(notifiers), wrnotify.DefaultConfigFile))
+	}
+	return wrnotify.NewDispatcher(notifiers)
 }
 
-func ParseFlags() (uint, []string, bool, string) {
-	// setup flags
-	out := output.NewConsoleOutput(true, nil)
-	atimeout := flag.Uint("atimeout", 45, "Max timeout to use for Amass")
-	tools := flag.String("tools", "subfinder,amass,sub-generator", "Comma-separated list of enum tools (default subfinder,amass,sub-generator)")
-	wildcard := flag.Bool("wildcard", false, "Whether or not to run PureDNS with wildcard filtering on")
-
+// function to parse the run flags and program name. prints help and exits if no program was given.
+func ParseFlags() wrrun.Options {
+	out := wrlog.NewConsoleOutput()
 	// check user inputted an argument (./WebRecon argument). if not, print help & exit, else continue
-	flag.Parse()
-	if len(flag.Args()) != 1 {
+	opts, err := wrrun.ParseOptions(flag.CommandLine, os.Args[1:])
+	if errors.Is(err, wrrun.ErrUsage) {
 		PrintHelp()
-	}
-
-	// check supplied list is OK
-	toolsList := strings.Split(*tools, ",")
-	if len(toolsList) > 0 && len(toolsList) < 4 {
-		validEntries := []string{"subfinder", "amass", "sub-generator"}
-		for _, v := range toolsList {
-			if !wrutils.SliceContainsString(validEntries, v) {
-				out.Writeln("\n<error>ERROR! - Invalid tool " + v + " supplied.</error>")
-				os.Exit(1)
-			}
-		}
-	} else {
-		out.Writeln("\n<error>ERROR! - Too many or no tools in list supplied to -tools flags. </error>")
+	} else if err != nil {
+		out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
 		os.Exit(1)
 	}
-
-	return *atimeout, toolsList, *wildcard, flag.Args()[0]
+	return opts
 }
 
 // MAIN
 func main() {
 	// cmd output styling stuff
 	in := input.NewArgvInput(nil)
-	out := output.NewConsoleOutput(true, nil)
+	out := wrlog.NewConsoleOutput()
 	io := style.NewGoStyler(in, out)
 
-	// declare variables
-	var wg sync.WaitGroup // for running cmd commands simultaneously
-	var arg1 string       // to store the <Program> arguement when WebRecon is ran (./WebRecon <arguement>)
-	var mute sync.Mutex   // to establish queue for writing using multiple threads
-
-	// print title
-	io.Title("WebRecon v2 - Subdomain enumeration made easy")
-
-	// verify dependencies
-	wrutils.VerifyDependencies()
-
-	// get user input, including amass timeout and name of program
-	atimeout, tools, wildcard, arg1 := ParseFlags()
-
-	// get full tool run time
-	start_time := time.Now()
-
-	// get date
-	date := time.Now().Format("01-02-2006")
-
-	// check domains list exists, has content, and output the domains to be tested
-	// the function returns a string array of the domains to be tested. the "domains" variable is set to this string array.
-	domains := CheckDomainsList(arg1)
-	//CheckDomainsList(arg1)
-	// build directory structure for new program
-	wrutils.BuildNewProgramDirectory(arg1, date, domains)
-
-	////                    ////
-	//  start of enumeration  //
-	////    				////
-	io.Section("Starting Subdomain Enumeration & Generating Potential Subdomains for " + arg1)
-	///
-	// Phase 1: subdomain generation. - generate subdomains, run amass, run subfinder, run X simultaneously.
-	///
+	// print title, unless a subcommand writes its results to stdout (./WebRecon export ...)
+	if len(os.Args) < 2 || !stdoutSubcommands[os.Args[1]] {
+		io.Title("WebRecon v2 - Subdomain enumeration made easy")
+	}
 
-	if wrutils.SliceContainsString(tools, "sub-generator") {
-		go wrtools.PotentialSubdomainGeneratorMain(domains, arg1, date, &wg, &mute)
-		wg.Add(1)
+	// run a subcommand (./WebRecon init ...) instead of enumeration if one was given
+	if RunSubcommand(os.Args[1:]) {
+		return
 	}
-	if wrutils.SliceContainsString(tools, "amass") {
-		go wrtools.RunAmass(arg1, date, int(atimeout), &wg)
-		wg.Add(1)
+
+	// get user input, including amass timeout, name of program and how much to log
+	log_flags := wrlog.AddFlags(flag.CommandLine)
+	dry_run := flag.Bool("dry-run", false, "Print the stages, command lines and estimated volume of the run without running anything")
+	opts := ParseFlags()
+	log_flags.Configure()
+	if *dry_run {
+		plan, err := wrrun.BuildPlan(opts)
+		if err != nil {
+			out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+			os.Exit(1)
+		}
+		wrrun.PrintPlan(plan)
+		return
 	}
-	if wrutils.SliceContainsString(tools, "subfinder") {
-		go wrtools.RunSubfinder(arg1, date, &wg)
-		wg.Add(1)
+	// verify dependencies. a dry run doesn't run the tools, so it doesn't need them
+	wrutils.VerifyDependencies()
+	// notify the webhooks and email recipients in notify.json when each phase and the run end
+	notifications := LoadNotifications()
+
+	// Ctrl-C stops the tools and records the run as cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// show each stage's progress below the output in a terminal, or log it every 30 seconds otherwise
+	progress := wrprogress.New(opts.Program)
+	stop_progress := progress.Show()
+	run_info, err := wrrun.Run(ctx, opts, notifications, progress)
+	stop_progress()
+	if err != nil {
+		// runs which stop before they start (a bad domains.txt, a locked program, ...) never finish, and their error is printed as is
+		if !run_info.Finished.IsZero() {
+			err = fmt.Errorf("run of %s %s - %v", opts.Program, run_info.Status, err)
+		}
+		out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+		os.Exit(1)
 	}
-	wg.Wait()
-
-	// this function combines all the files within the date directory for the scan (./Programs/Google/01-25-23/*) into one file, and removes duplicate entries. outputs the files: "all_enumerated_subdomains_combined.txt" & "all_enumerated_subdomains_combined_unique.txt"
-	wrutils.CombineFiles(tools, arg1, date)
-	// this function separates "all_enumerated_subdomains_combined_unique.txt" into separate files by top-level-domain and places them into ./Programs/<program>/<date>/top-level-domain/<top-level-domain>/<top-level-domain>-subdomains.txt
-	/* start1 := time.Now()
-	sortedDomains := wrutils.SeparateAllSubdomainsIntoSeparateFolders(arg1, date, domains)
-	time_elapsed1 := time.Now().Sub(start1)
-	str := fmt.Sprintf("Separating subdomains Done! Finished in %v.", time_elapsed1)
-	io.Success(str) */
-
-	///
-	// Phase 2: validate subdomains exist via bruteforcing reverse dns lookups
-	///
-
-	io.Section("Starting Reverse DNS Bruteforcing for " + arg1)
-	// start clock to get runtime
-	start2 := time.Now()
-	// for each domain in sortedDomain (a list of domains which has redudancies removed)
-
-	// run puredns for the domain - an instance of puredns is ran for each domain as its required for wildcard filtering.
-	wrtools.RunPuredns(arg1, date, 0, wildcard)
-
-	// get time elapsed
-	time_elapsed2 := time.Since(start2)
-	// print out the commands completed and the runtime
-	str2 := fmt.Sprintf("Reverse DNS Bruteforcing Done! Finished in %v.", time_elapsed2)
-	io.Success(str2)
-
-	///
-	// Phase 3: Run dnsgen on each puredns output, generating permutations of the valid domains
-	///
-	io.Section("Starting generating permutations via dnsgen for " + arg1)
-	// start clock to get runtime
-	start3 := time.Now()
-
-	//run dnsgen for each puredns output
-	wrtools.RunDnsgen(arg1, date)
-
-	time_elapsed3 := time.Since(start3)
-	// print out the commands completed and the runtime
-	str3 := fmt.Sprintf("Permutation generation Done! Finished in %v.", time_elapsed3)
-	io.Success(str3)
-
-	///
-	// Phase 4: Validate dnsgen output subdomains exist via bruteforcing reverse dns lookups
-	///
-
-	io.Section("Starting second round of reverse DNS bruteforcing against the dnsgen output for " + arg1)
-
-	start4 := time.Now()
-	// for each domain in sortedDomain (a list of domains which has redudancies removed)
-
-	// run puredns for the domain - an instance of puredns is ran for each domain as its required for wildcard filtering.
-	wrtools.RunPuredns(arg1, date, 1, wildcard)
-
-	// get time elapsed
-	time_elapsed4 := time.Since(start4)
-	// print out the commands completed and the runtime
-	str4 := fmt.Sprintf("Reverse DNS Bruteforcing against dnsgen ouput done! Finished in %v.", time_elapsed4)
-	io.Success(str4)
-
-	///
-	// Phase 5: Completion and clean up. Combine dnsgen outputs, place into <date> directory for test. print a goodbye message
-	///
-	io.Section("All enumeration and reverse DNS bruteforcing complete. Creating output files for " + arg1 + "...")
-	wrutils.CreateFileOfAllValidSubdomainsCombined(arg1, date)
-
-	fullruntime_elapsed := time.Since(start_time)
 	// print out the commands completed and the runtime
-	str5 := fmt.Sprintf("WebRecon2 Complete! Finished in %v.", fullruntime_elapsed)
-	io.Success(str5)
+	io.Success(fmt.Sprintf("WebRecon2 Complete! Finished in %v.", run_info.Duration()))
 }
@@ -0,0 +1,189 @@
+package wrdaemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CRON FUNCTIONS
+// schedules use the five fields of crontab(5): minute, hour, day of month, month and day of week. fields are *, values,
+// ranges (1-5), steps (*/15, 0-30/10) and comma separated lists of those. months and days of the week can be named (jan, mon).
+// as in cron, when both the day of month and day of week are restricted, a day matching either runs.
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	expression string
+	minutes    uint64
+	hours      uint64
+	days       uint64
+	months     uint64
+	weekdays   uint64
+	// whether the day of month and day of week fields start with *, for cron's either-day rule
+	any_day     bool
+	any_weekday bool
+}
+
+// the shorthands cron accepts in place of the five fields
+var cronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// cronField is the range of values a field accepts, and the names of its values starting from min
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: monthNames},
+	// 7 is also sunday
+	{name: "day of week", min: 0, max: 7, names: weekdayNames},
+}
+
+// function to parse a cron expression of five fields, or a shorthand such as @daily
+func ParseSchedule(expression string) (*Schedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) == 1 {
+		if expanded, ok := cronShorthands[strings.ToLower(fields[0])]; ok {
+			fields = strings.Fields(expanded)
+		}
+	}
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid schedule %q, expected 5 fields or a shorthand such as @daily", expression)
+	}
+	var sets [5]uint64
+	for index, field := range cronFields {
+		set, err := field.parse(fields[index])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q in schedule %q - %v", field.name, fields[index], expression, err)
+		}
+		sets[index] = set
+	}
+	// sunday can be 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &Schedule{
+		expression:  strings.Join(fields, " "),
+		minutes:     sets[0],
+		hours:       sets[1],
+		days:        sets[2],
+		months:      sets[3],
+		weekdays:    sets[4],
+		any_day:     strings.HasPrefix(fields[2], "*"),
+		any_weekday: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parses a value of a field, either a number or a name
+func (f cronField) value(value string) (int, error) {
+	for index, name := range f.names {
+		if strings.EqualFold(value, name) {
+			return f.min + index, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+	if number < f.min || number > f.max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", number, f.min, f.max)
+	}
+	return number, nil
+}
+
+// parses a field into the set of values it matches, as a bitmask
+func (f cronField) parse(expression string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(expression, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash != -1 {
+			var err error
+			step, err = strconv.Atoi(part[slash+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", part[slash+1:])
+			}
+			part = part[:slash]
+		}
+		low, high := f.min, f.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// a step from a single value (5/15) runs to the end of the range, as in cron
+				high = f.max
+			}
+			if high < low {
+				return 0, fmt.Errorf("range %s runs backwards", part)
+			}
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+// returns the schedule as it was written, with shorthands expanded
+func (s *Schedule) String() string {
+	return s.expression
+}
+
+// returns whether the schedule runs in the minute of t
+func (s *Schedule) Matches(t time.Time) bool {
+	return s.minutes&(1<<t.Minute()) != 0 && s.hours&(1<<t.Hour()) != 0 && s.months&(1<<int(t.Month())) != 0 && s.dayMatches(t)
+}
+
+// returns whether the schedule runs on the day of t, whatever the time
+func (s *Schedule) dayMatches(t time.Time) bool {
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekdays&(1<<int(t.Weekday())) != 0
+	if s.any_day || s.any_weekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// returns the first minute after t the schedule runs in, or the zero time if it never runs in the next five years (e.g. 0 0 31 2 *)
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.months&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		} else if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		} else if s.hours&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		} else if s.minutes&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,243 @@
+package wrdaemon
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrnotify"
+	"github.com/sammooredev/WebRecon/wrprogress"
+	"github.com/sammooredev/WebRecon/wrrun"
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// DAEMON FUNCTIONS
+// the daemon runs programs on the cron schedules in ./schedule.txt, one per line:
+//
+//	# minute hour day-of-month month day-of-week [flags] program
+//	0 3 * * *     -tools subfinder,amass Starbucks
+//	@weekly       -wildcard Shopify
+//
+// the schedule is re-read when it changes. at most -concurrency runs happen at once; a program that comes due while it is
+// already queued, running, or locked by a run started elsewhere is skipped. every run, and every skip, is recorded in the history.
+
+// DefaultScheduleFile is the daemon's schedule
+const DefaultScheduleFile = "./schedule.txt"
+
+// the status recorded for a scheduled run which didn't happen, since its program was already being run
+const RunStatusSkipped = "skipped"
+
+// ScheduleEntry is a line of the schedule.
+type ScheduleEntry struct {
+	Line     int
+	Schedule *Schedule
+	Options  wrrun.Options
+}
+
+// function to parse a schedule line: a cron expression followed by run flags and a program name
+func ParseScheduleLine(line string) (ScheduleEntry, error) {
+	fields := strings.Fields(line)
+	cron_fields := len(cronFields)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		cron_fields = 1
+	}
+	if len(fields) <= cron_fields {
+		return ScheduleEntry{}, fmt.Errorf("expected a schedule followed by a program name")
+	}
+	schedule, err := ParseSchedule(strings.Join(fields[:cron_fields], " "))
+	if err != nil {
+		return ScheduleEntry{}, err
+	}
+	flags := flag.NewFlagSet("schedule", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	opts, err := wrrun.ParseOptions(flags, fields[cron_fields:])
+	if err != nil {
+		return ScheduleEntry{}, err
+	}
+	return ScheduleEntry{Schedule: schedule, Options: opts}, nil
+}
+
+// function to read a schedule file. blank lines and lines starting with # are ignored.
+func ReadScheduleFile(path string) ([]ScheduleEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []ScheduleEntry
+	scanner := bufio.NewScanner(file)
+	line_number := 0
+	for scanner.Scan() {
+		line_number += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := ParseScheduleLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d of %s: %v", line_number, path, err)
+		}
+		entry.Line = line_number
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Daemon runs the programs in a schedule file.
+type Daemon struct {
+	schedule_path string
+	concurrency   int
+	notifications *wrnotify.Dispatcher
+	entries       []ScheduleEntry
+	modified      time.Time
+	// one slot per run that may happen at once
+	slots chan struct{}
+	// programs which are queued or running
+	active map[string]bool
+	mutex  sync.Mutex
+	wg     sync.WaitGroup
+}
+
+// function to create a daemon for a schedule file, reading the schedule. concurrency is the most runs that happen at once.
+func NewDaemon(schedule_path string, concurrency int, notifications *wrnotify.Dispatcher) (*Daemon, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1")
+	}
+	d := &Daemon{
+		schedule_path: schedule_path,
+		concurrency:   concurrency,
+		notifications: notifications,
+		slots:         make(chan struct{}, concurrency),
+		active:        make(map[string]bool),
+	}
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// re-reads the schedule if it changed since it was last read
+func (d *Daemon) reload() error {
+	info, err := os.Stat(d.schedule_path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(d.modified) {
+		return nil
+	}
+	entries, err := ReadScheduleFile(d.schedule_path)
+	if err != nil {
+		return err
+	}
+	d.entries, d.modified = entries, info.ModTime()
+	d.printSchedule()
+	return nil
+}
+
+// prints every entry of the schedule and when it next runs
+func (d *Daemon) printSchedule() {
+	out := wrlog.NewConsoleOutput()
+	out.Writeln(fmt.Sprintf("<info>INFO - Loaded %d scheduled runs from %s (at most %d at once)</info>", len(d.entries), d.schedule_path, d.concurrency))
+	for _, entry := range d.entries {
+		next := entry.Schedule.Next(time.Now())
+		when := "never"
+		if !next.IsZero() {
+			when = next.Format("2006-01-02 15:04")
+		}
+		out.Writeln(fmt.Sprintf("\t<comment>%-20s %-24s next run %s</comment>", entry.Options.Program, entry.Schedule, when))
+	}
+}
+
+// function to run the schedule until ctx is cancelled. runs in progress are cancelled with it, and recorded as such.
+func (d *Daemon) Run(ctx context.Context) {
+	last := time.Time{}
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			slog.Info("Stopping the daemon, waiting for runs to be cancelled")
+			d.wg.Wait()
+			return
+		case now = <-timer.C:
+		}
+		minute := now.Truncate(time.Minute)
+		if !minute.After(last) {
+			continue
+		}
+		last = minute
+		if err := d.reload(); err != nil {
+			slog.Error("Could not reload the schedule, keeping the previous one", "schedule", d.schedule_path, "error", err)
+		}
+		for _, entry := range d.entries {
+			if entry.Schedule.Matches(minute) {
+				d.start(ctx, entry, minute)
+			}
+		}
+	}
+}
+
+// queues a run of a scheduled entry, skipping it if its program is already queued or running
+func (d *Daemon) start(ctx context.Context, entry ScheduleEntry, scheduled time.Time) {
+	program_name := entry.Options.Program
+	logger := slog.With("program", program_name, "schedule", entry.Schedule.String())
+	d.mutex.Lock()
+	if d.active[program_name] {
+		d.mutex.Unlock()
+		logger.Warn("Skipped scheduled run, the program is still queued or running")
+		AppendHistory(HistoryEntry{Program: program_name, Schedule: entry.Schedule.String(), Status: RunStatusSkipped, Error: "still queued or running", Scheduled: scheduled})
+		return
+	}
+	d.active[program_name] = true
+	d.mutex.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer func() {
+			d.mutex.Lock()
+			delete(d.active, program_name)
+			d.mutex.Unlock()
+		}()
+		select {
+		case d.slots <- struct{}{}:
+		case <-ctx.Done():
+			AppendHistory(HistoryEntry{Program: program_name, Schedule: entry.Schedule.String(), Status: wrutils.RunStatusCancelled, Error: "the daemon stopped before the run started", Scheduled: scheduled})
+			return
+		}
+		defer func() { <-d.slots }()
+
+		logger.Info("Starting scheduled run")
+		history := HistoryEntry{Program: program_name, Schedule: entry.Schedule.String(), Scheduled: scheduled, Started: time.Now()}
+		// runs may overlap, so their progress is logged rather than drawn
+		progress := wrprogress.New(program_name)
+		stop_progress := progress.ShowLog()
+		run_info, err := wrrun.Run(ctx, entry.Options, d.notifications, progress)
+		stop_progress()
+		history.Date, history.Status, history.Subdomains = run_info.Date, run_info.Status, run_info.Subdomains
+		history.Finished = time.Now()
+		history.DurationSeconds = history.Finished.Sub(history.Started).Seconds()
+		if err != nil {
+			history.Error = err.Error()
+			if errors.Is(err, wrutils.ErrProgramLocked) {
+				history.Status = RunStatusSkipped
+			}
+			logger.Error("Scheduled run "+history.Status, "error", err)
+		} else {
+			logger.Info("Scheduled run complete", "duration", run_info.Duration(), "subdomains", run_info.Subdomains)
+		}
+		if err := AppendHistory(history); err != nil {
+			logger.Error("Could not record the run in the history", "error", err)
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+package wrdaemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// HISTORY FUNCTIONS
+// every scheduled run the daemon starts or skips is appended to ./Programs/.daemon/history.jsonl, one JSON object per line.
+
+// HistoryFile is where the daemon's runs are recorded
+const HistoryFile = wrutils.ProgramsDirectory + ".daemon/history.jsonl"
+
+// HistoryEntry is a scheduled run.
+type HistoryEntry struct {
+	Program         string    `json:"program"`
+	Date            string    `json:"date,omitempty"`
+	Schedule        string    `json:"schedule"`
+	Status          string    `json:"status"`
+	Error           string    `json:"error,omitempty"`
+	Scheduled       time.Time `json:"scheduled"`
+	Started         time.Time `json:"started"`
+	Finished        time.Time `json:"finished"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Subdomains      int       `json:"subdomains"`
+}
+
+// guards appends to the history, as concurrent runs finish at once
+var historyMutex sync.Mutex
+
+// returns the run duration, rounded to the second
+func (h HistoryEntry) Duration() time.Duration {
+	return (time.Duration(h.DurationSeconds) * time.Second).Round(time.Second)
+}
+
+// function to append a run to the history
+func AppendHistory(entry HistoryEntry) error {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if err := os.MkdirAll(filepath.Dir(HistoryFile), os.ModePerm); err != nil {
+		return err
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// function to read the history, oldest first. program filters it to a single program unless it is "". lines which can't be parsed are skipped.
+func ReadHistory(program_name string) ([]HistoryEntry, error) {
+	entries := []HistoryEntry{}
+	file, err := os.Open(HistoryFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if program_name == "" || entry.Program == program_name {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,552 @@
+package wrdist
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrprogress"
+	"github.com/sammooredev/WebRecon/wrtools"
+)
+
+// DISTRIBUTED RESOLUTION
+// a run started with -distribute <address> resolves its candidates on workers on other hosts as well as on its own, so the
+// queries leave from more IP addresses and each is rate limited less. the run is the coordinator: it splits each resolution
+// round's candidates into chunks of -chunk-size and serves them over HTTP (or HTTPS, with -distribute-cert and
+// -distribute-key) at <address>. workers (./WebRecon worker <url>, see worker.go) lease a chunk, resolve it with puredns
+// and their own resolvers and post the results back, and the coordinator appends them to the round's files as they come
+// in. the candidates file is only indexed, each chunk is read from it when it is handed out, so a round holds one chunk
+// per request in memory however many candidates it has. a chunk whose worker reports an error or doesn't post it back
+// within its lease is handed out again, up to maxAttempts times. chunk IDs carry a random nonce of their round, so a
+// worker still resolving a chunk of an earlier run can't post it into the next one. every request needs the token in
+// $WEBRECON_WORKER_TOKEN.
+//
+//	POST /dist/lease          lease the next chunk: 200 with the chunk, or 204 when none is waiting
+//	POST /dist/chunks/<id>    post a chunk's result {"resolved": [...], "records": [...], "wildcards": [...]}, or {"error": "..."}
+//	GET  /dist/status         the round being resolved and the workers seen
+
+// the environment variable holding the token the coordinator and its workers share
+const TokenEnv = "WEBRECON_WORKER_TOKEN"
+
+// the header a worker names itself in, for the coordinator's logs and status
+const WorkerHeader = "X-WebRecon-Worker"
+
+// the name the coordinator resolves chunks under itself
+const localWorker = "local"
+
+// how many times a chunk is handed out before the round fails
+const maxAttempts = 3
+
+// the largest result a worker may post. a chunk's DNS records are a few times the size of its candidates.
+const maxReportSize = 512 << 20
+
+// how long a worker has to post a chunk back before it is handed out again
+var leaseTimeout = 30 * time.Minute
+
+// Chunk is a part of a resolution round's candidates, as it is handed to a worker.
+type Chunk struct {
+	ID         string   `json:"id"`
+	Stage      string   `json:"stage"`
+	Wildcard   bool     `json:"wildcard"`
+	Candidates []string `json:"candidates"`
+}
+
+// Report is what a worker posts back for a chunk: its result, or why it couldn't be resolved.
+type Report struct {
+	wrtools.ChunkResult
+	Error string `json:"error,omitempty"`
+}
+
+// WorkerStatus is a worker the coordinator has heard from.
+type WorkerStatus struct {
+	Name     string    `json:"name"`
+	Address  string    `json:"address"`
+	LastSeen time.Time `json:"last_seen"`
+	Leased   string    `json:"leased,omitempty"`
+	Chunks   int       `json:"chunks"`
+	Failures int       `json:"failures"`
+}
+
+// Status is what the coordinator is doing.
+type Status struct {
+	Stage   string          `json:"stage,omitempty"`
+	Chunks  int             `json:"chunks"`
+	Done    int             `json:"done"`
+	Leased  int             `json:"leased"`
+	Workers []*WorkerStatus `json:"workers"`
+}
+
+// chunk is a chunk's state at the coordinator
+type chunk struct {
+	id string
+	// where its candidates start in the candidates file, and how many lines it has
+	offset int64
+	lines  int
+	// how many times it was handed out, and when it was last handed out and to whom. leased is zero while the chunk waits
+	// to be handed out.
+	attempts int
+	leased   time.Time
+	worker   string
+	done     bool
+}
+
+// round is a resolution round being resolved
+type round struct {
+	// cancelled when the round ends, which stops the coordinator resolving its chunks
+	ctx        context.Context
+	stage      string
+	candidates string
+	wildcard   bool
+	chunks     []*chunk
+	done       int
+	processed  int
+	output     *wrtools.RoundOutput
+	// signalled whenever a chunk is done or the round fails
+	changed chan struct{}
+	err     error
+}
+
+// Coordinator hands the chunks of a run's resolution rounds to workers over HTTP. it implements wrtools.Distributor.
+type Coordinator struct {
+	token      string
+	chunk_size int
+	tls        bool
+	limits     *wrtools.Limits
+	logger     *slog.Logger
+	server     *http.Server
+	mutex      sync.Mutex
+	round      *round
+	workers    map[string]*WorkerStatus
+	// signalled when a round is queued, for the local worker
+	queued chan struct{}
+	closed chan struct{}
+}
+
+// function to start a coordinator serving chunks of chunk_size candidates at address, to workers carrying token. with
+// cert_file and key_file it serves HTTPS. the coordinator resolves chunks itself too, within limits. it serves until it
+// is closed.
+func NewCoordinator(ctx context.Context, address string, token string, cert_file string, key_file string, chunk_size int, limits *wrtools.Limits) (*Coordinator, error) {
+	if token == "" {
+		return nil, fmt.Errorf("$%s must be set to distribute the resolution to workers", TokenEnv)
+	}
+	var certificate tls.Certificate
+	if cert_file != "" {
+		var err error
+		if certificate, err = tls.LoadX509KeyPair(cert_file, key_file); err != nil {
+			return nil, fmt.Errorf("could not load the certificate - %w", err)
+		}
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	c := &Coordinator{
+		token:      token,
+		chunk_size: chunk_size,
+		tls:        cert_file != "",
+		limits:     limits,
+		logger:     wrlog.FromContext(ctx),
+		workers:    map[string]*WorkerStatus{localWorker: {Name: localWorker}},
+		queued:     make(chan struct{}, 1),
+		closed:     make(chan struct{}),
+	}
+	c.server = &http.Server{Handler: c, ReadHeaderTimeout: 30 * time.Second}
+	scheme := "http"
+	if c.tls {
+		scheme = "https"
+		c.server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+		listener = tls.NewListener(listener, c.server.TLSConfig)
+	}
+	go c.server.Serve(listener)
+	go c.resolveLocally()
+	c.logger.Info("Serving resolution chunks to workers", "address", scheme+"://"+listener.Addr().String()+"/dist/", "chunk_size", chunk_size)
+	if !c.tls {
+		c.logger.Warn("The worker token and the candidates are sent in clear text, only distribute over a trusted network or use -distribute-cert and -distribute-key")
+	}
+	return c, nil
+}
+
+// function to stop serving chunks
+func (c *Coordinator) Close() error {
+	close(c.closed)
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.server.Shutdown(shutdown)
+}
+
+// function to resolve the candidates in a file in chunks, on the workers and the coordinator itself, writing the result of
+// each chunk to output as it comes in
+func (c *Coordinator) Resolve(ctx context.Context, stage_name string, candidates string, wildcard bool, output *wrtools.RoundOutput, stage *wrprogress.Stage) error {
+	round_ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r := &round{ctx: round_ctx, stage: stage_name, candidates: candidates, wildcard: wildcard, output: output, changed: make(chan struct{}, 1)}
+	if err := c.index(r); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.round = r
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		c.round = nil
+		c.mutex.Unlock()
+	}()
+	signal(c.queued)
+	c.logger.Info("Distributing puredns to the workers", "stage", stage_name, "chunks", len(r.chunks))
+
+	// leases which ran out are only noticed when a chunk is leased, so they are also checked while waiting
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		c.mutex.Lock()
+		done, processed, failed := r.done, r.processed, r.err
+		c.mutex.Unlock()
+		stage.SetProcessed(processed)
+		if failed != nil {
+			return failed
+		}
+		if done == len(r.chunks) {
+			return nil
+		}
+		select {
+		case <-r.changed:
+		case <-ticker.C:
+			c.mutex.Lock()
+			c.expireLeases(r)
+			c.mutex.Unlock()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// function to split a round's candidates file into chunks of chunk_size lines, recording where each starts. the chunks
+// are named after the stage and a random nonce of the round.
+func (c *Coordinator) index(r *round) error {
+	nonce := make([]byte, 4)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	file, err := os.Open(r.candidates)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		// a line longer than the reader's buffer is read in several parts, only the first of which starts a line
+		line, err := reader.ReadSlice('\n')
+		starts_line := true
+		for {
+			if len(line) > 0 && starts_line {
+				if len(r.chunks) == 0 || r.chunks[len(r.chunks)-1].lines == c.chunk_size {
+					r.chunks = append(r.chunks, &chunk{id: fmt.Sprintf("%s-%x-%d", r.stage, nonce, len(r.chunks)), offset: offset})
+				}
+				r.chunks[len(r.chunks)-1].lines += 1
+				starts_line = false
+			}
+			offset += int64(len(line))
+			if err != bufio.ErrBufferFull {
+				break
+			}
+			line, err = reader.ReadSlice('\n')
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// function to read the candidates of a chunk of the round from its candidates file
+func (r *round) read(leased *chunk) (Chunk, error) {
+	read := Chunk{ID: leased.id, Stage: r.stage, Wildcard: r.wildcard, Candidates: make([]string, 0, leased.lines)}
+	file, err := os.Open(r.candidates)
+	if err != nil {
+		return read, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(leased.offset, io.SeekStart); err != nil {
+		return read, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lines := 0; lines < leased.lines && scanner.Scan(); lines++ {
+		if candidate := strings.TrimSpace(scanner.Text()); candidate != "" {
+			read.Candidates = append(read.Candidates, candidate)
+		}
+	}
+	return read, scanner.Err()
+}
+
+// function to hand the next waiting chunk of the round to worker, reading its candidates. returns nil when no chunk is
+// waiting, and the context of the round the chunk belongs to. c must not be locked, as the candidates are read without
+// the lock.
+func (c *Coordinator) lease(worker string) (*Chunk, context.Context, error) {
+	c.mutex.Lock()
+	r := c.round
+	if r == nil || r.err != nil {
+		c.mutex.Unlock()
+		return nil, nil, nil
+	}
+	c.expireLeases(r)
+	var leased *chunk
+	for _, chunk := range r.chunks {
+		if !chunk.done && chunk.leased.IsZero() {
+			leased = chunk
+			break
+		}
+	}
+	if leased == nil {
+		c.mutex.Unlock()
+		return nil, nil, nil
+	}
+	leased.attempts += 1
+	leased.leased, leased.worker = time.Now(), worker
+	if status := c.workers[worker]; status != nil {
+		status.Leased, status.LastSeen = leased.id, time.Now()
+	}
+	c.mutex.Unlock()
+
+	read, err := r.read(leased)
+	if err != nil {
+		c.mutex.Lock()
+		c.failChunk(r, leased, err)
+		c.mutex.Unlock()
+		return nil, nil, fmt.Errorf("could not read chunk %s - %w", leased.id, err)
+	}
+	return &read, r.ctx, nil
+}
+
+// function to hand out again the chunks whose workers didn't post them back within their lease, failing the round when
+// a chunk has been handed out too many times. c must be locked.
+func (c *Coordinator) expireLeases(r *round) {
+	for _, chunk := range r.chunks {
+		if chunk.done || chunk.leased.IsZero() || time.Since(chunk.leased) < leaseTimeout {
+			continue
+		}
+		c.logger.Warn("A worker didn't post its chunk back in time, handing it out again", "chunk", chunk.id, "worker", chunk.worker, "lease", leaseTimeout)
+		c.failChunk(r, chunk, fmt.Errorf("%s didn't post it back within %s", chunk.worker, leaseTimeout))
+	}
+}
+
+// function to record that a chunk couldn't be resolved, so it is handed out again, or the round fails if it has been
+// tried maxAttempts times. c must be locked.
+func (c *Coordinator) failChunk(r *round, chunk *chunk, err error) {
+	chunk.leased, chunk.worker = time.Time{}, ""
+	if chunk.attempts >= maxAttempts && r.err == nil {
+		r.err = fmt.Errorf("chunk %s failed %d times, the last time because %w", chunk.id, chunk.attempts, err)
+		signal(r.changed)
+		return
+	}
+	signal(c.queued)
+}
+
+// function to record a worker's report on a chunk, writing its result to the round's output. returns false if the chunk
+// isn't part of the round being resolved.
+func (c *Coordinator) report(worker string, id string, report Report) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	r := c.round
+	if r == nil {
+		return false
+	}
+	for _, chunk := range r.chunks {
+		if chunk.id != id {
+			continue
+		}
+		// a chunk handed out again may be posted back twice; the first result is kept
+		if chunk.done {
+			return true
+		}
+		status := c.workers[worker]
+		if status != nil {
+			status.LastSeen = time.Now()
+			if status.Leased == id {
+				status.Leased = ""
+			}
+		}
+		if report.Error != "" {
+			if status != nil {
+				status.Failures += 1
+			}
+			// the chunk was handed out again after this worker's lease ran out, so its failure says nothing about the
+			// worker which holds the chunk now
+			if chunk.worker != worker {
+				c.logger.Warn("A worker could not resolve a chunk it no longer holds, ignoring it", "chunk", id, "worker", worker, "error", report.Error)
+				return true
+			}
+			c.logger.Warn("A worker could not resolve its chunk", "chunk", id, "worker", worker, "attempt", chunk.attempts, "error", report.Error)
+			c.failChunk(r, chunk, errors.New(report.Error))
+			return true
+		}
+		// a result is kept whoever posts it, even a worker whose lease ran out, as the ID shows it is a chunk of this round
+		if err := r.output.Write(report.ChunkResult); err != nil {
+			if r.err == nil {
+				r.err = fmt.Errorf("could not write the result of chunk %s - %w", id, err)
+				signal(r.changed)
+			}
+			return true
+		}
+		if status != nil {
+			status.Chunks += 1
+		}
+		chunk.done, chunk.leased, chunk.worker = true, time.Time{}, ""
+		r.done += 1
+		r.processed += chunk.lines
+		c.logger.Debug("Chunk resolved", "chunk", id, "worker", worker, "resolved", len(report.Resolved), "done", r.done, "of", len(r.chunks))
+		signal(r.changed)
+		return true
+	}
+	return false
+}
+
+// returns what the coordinator is doing
+func (c *Coordinator) Status() Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	status := Status{Workers: []*WorkerStatus{}}
+	if r := c.round; r != nil {
+		status.Stage, status.Chunks, status.Done = r.stage, len(r.chunks), r.done
+		for _, chunk := range r.chunks {
+			if !chunk.done && !chunk.leased.IsZero() {
+				status.Leased += 1
+			}
+		}
+	}
+	for _, worker := range c.workers {
+		copied := *worker
+		status.Workers = append(status.Workers, &copied)
+	}
+	return status
+}
+
+// function to resolve chunks on the coordinator's own machine, as one of the workers, until it is closed
+func (c *Coordinator) resolveLocally() {
+	for {
+		leased, ctx, err := c.lease(localWorker)
+		if err != nil {
+			c.logger.Error("Could not hand a chunk to the coordinator's own worker", "error", err)
+		}
+		if leased == nil {
+			select {
+			case <-c.queued:
+			case <-time.After(5 * time.Second):
+			case <-c.closed:
+				return
+			}
+			continue
+		}
+		c.report(localWorker, leased.ID, resolveChunk(ctx, *leased, c.limits))
+	}
+}
+
+// function to resolve a chunk in a temporary directory, returning the report to post for it
+func resolveChunk(ctx context.Context, leased Chunk, limits *wrtools.Limits) Report {
+	dir, err := os.MkdirTemp("", "webrecon-chunk-")
+	if err != nil {
+		return Report{Error: err.Error()}
+	}
+	defer os.RemoveAll(dir)
+	result, err := wrtools.ResolveChunk(ctx, dir, leased.Candidates, leased.Wildcard, limits)
+	if err != nil {
+		return Report{Error: err.Error()}
+	}
+	return Report{ChunkResult: result}
+}
+
+// sends on a channel of one without blocking, so signals collapse into one
+func signal(channel chan struct{}) {
+	select {
+	case channel <- struct{}{}:
+	default:
+	}
+}
+
+// returns whether a request carries the token
+func (c *Coordinator) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) == 1
+}
+
+// returns the worker a request comes from, adding it to the workers the first time it is seen
+func (c *Coordinator) worker(r *http.Request) string {
+	name := r.Header.Get(WorkerHeader)
+	if name == "" {
+		name = r.RemoteAddr
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	status, ok := c.workers[name]
+	if !ok {
+		status = &WorkerStatus{Name: name}
+		c.workers[name] = status
+		c.logger.Info("A worker connected", "worker", name, "address", r.RemoteAddr)
+	}
+	status.Address, status.LastSeen = r.RemoteAddr, time.Now()
+	return name
+}
+
+// writes value as JSON with status
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// ServeHTTP checks the request's token and routes it
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !c.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="WebRecon"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid worker token"})
+		return
+	}
+	id, is_chunk := strings.CutPrefix(r.URL.Path, "/dist/chunks/")
+	switch {
+	case r.URL.Path == "/dist/lease" && r.Method == http.MethodPost:
+		worker := c.worker(r)
+		leased, _, err := c.lease(worker)
+		if err != nil {
+			c.logger.Error("Could not hand a chunk to a worker", "worker", worker, "error", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if leased == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		c.logger.Debug("Chunk leased", "chunk", leased.ID, "worker", worker, "candidates", len(leased.Candidates))
+		writeJSON(w, http.StatusOK, leased)
+	case is_chunk && r.Method == http.MethodPost:
+		var report Report
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReportSize)).Decode(&report); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid report - " + err.Error()})
+			return
+		}
+		if !c.report(c.worker(r), id, report) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no chunk " + id + " is being resolved"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "received"})
+	case r.URL.Path == "/dist/status" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, c.Status())
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such endpoint " + r.Method + " " + r.URL.Path})
+	}
+}
@@ -0,0 +1,167 @@
+package wrdist
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrtools"
+)
+
+// WORKER FUNCTIONS
+// a worker leases chunks from a coordinator, resolves them with puredns and the resolvers in its own
+// ./wordlists/resolvers.txt, and posts the results back. the token and the chunks travel in clear text unless the
+// coordinator serves HTTPS. it keeps polling until it is stopped, so workers can be started
+// before a run and serve one run after the other.
+
+// how long a worker waits before asking again when no chunk is waiting, and at most after failing to reach the coordinator
+var pollInterval = 5 * time.Second
+var maxBackoff = time.Minute
+
+// Worker resolves chunks for a coordinator.
+type Worker struct {
+	url    string
+	token  string
+	name   string
+	limits *wrtools.Limits
+	client *http.Client
+}
+
+// returns a worker named name resolving chunks for the coordinator at url within limits. an https:// coordinator's
+// certificate is verified against roots, or the system's roots if it is nil.
+func NewWorker(url string, token string, name string, roots *x509.CertPool, limits *wrtools.Limits) *Worker {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if roots != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+	client := &http.Client{Timeout: 5 * time.Minute, Transport: transport}
+	return &Worker{url: strings.TrimSuffix(url, "/"), token: token, name: name, limits: limits, client: client}
+}
+
+// function to read the PEM certificates in a file, for workers to verify a coordinator with a self-signed certificate
+func LoadRoots(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return roots, nil
+}
+
+// function to lease, resolve and post back chunks until ctx is cancelled. failing to reach the coordinator is logged and
+// retried, as it only listens while a run is resolving.
+func (w *Worker) Run(ctx context.Context) {
+	logger := wrlog.FromContext(ctx).With("worker", w.name)
+	backoff := pollInterval
+	unreachable := false
+	for ctx.Err() == nil {
+		leased, err := w.lease(ctx)
+		if err != nil {
+			if !unreachable {
+				logger.Warn("Could not reach the coordinator, retrying", "coordinator", w.url, "error", err)
+				unreachable = true
+			}
+			sleep(ctx, backoff)
+			backoff = min(2*backoff, maxBackoff)
+			continue
+		}
+		if unreachable {
+			logger.Info("Reached the coordinator", "coordinator", w.url)
+			unreachable = false
+		}
+		backoff = pollInterval
+		if leased == nil {
+			sleep(ctx, pollInterval)
+			continue
+		}
+
+		logger.Info("Resolving a chunk", "chunk", leased.ID, "candidates", len(leased.Candidates))
+		start := time.Now()
+		report := resolveChunk(ctx, *leased, w.limits)
+		if ctx.Err() != nil {
+			// the coordinator hands the chunk out again once its lease runs out
+			return
+		}
+		if report.Error != "" {
+			logger.Error("Could not resolve a chunk", "chunk", leased.ID, "error", report.Error)
+		} else {
+			logger.Info("Resolved a chunk", "chunk", leased.ID, "resolved", len(report.Resolved), "duration", time.Since(start))
+		}
+		if err := w.post(ctx, leased.ID, report); err != nil {
+			logger.Error("Could not post a chunk back to the coordinator", "chunk", leased.ID, "error", err)
+		}
+	}
+}
+
+// function to send a request to the coordinator, decoding its JSON response into response. returns the response's status.
+func (w *Worker) request(ctx context.Context, method string, path string, body interface{}, response interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, w.url+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Authorization", "Bearer "+w.token)
+	request.Header.Set(WorkerHeader, w.name)
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return resp.StatusCode, fmt.Errorf("the coordinator answered %s - %s", resp.Status, failure.Error)
+	}
+	if response != nil && resp.StatusCode != http.StatusNoContent {
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(response)
+	}
+	return resp.StatusCode, nil
+}
+
+// function to lease the next chunk. returns nil when none is waiting.
+func (w *Worker) lease(ctx context.Context) (*Chunk, error) {
+	var leased Chunk
+	status, err := w.request(ctx, http.MethodPost, "/dist/lease", nil, &leased)
+	if err != nil || status == http.StatusNoContent {
+		return nil, err
+	}
+	return &leased, nil
+}
+
+// function to post a chunk's report back
+func (w *Worker) post(ctx context.Context, id string, report Report) error {
+	_, err := w.request(ctx, http.MethodPost, "/dist/chunks/"+id, report, nil)
+	return err
+}
+
+// waits for duration, or until ctx is cancelled
+func sleep(ctx context.Context, duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,61 @@
+package wrlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/DrSmithFr/go-console/pkg/output"
+)
+
+// CONSOLE OUTPUT
+// everything printed to the console, the console logger's lines included, goes to the console writer. it is stdout,
+// unless the progress display is shown, which takes it over to keep the output scrolling above it.
+
+var (
+	console_writer_mutex sync.Mutex
+	console_writer       io.Writer = os.Stdout
+)
+
+// function to send the console output to w until the returned function is called, which sends it back to where it went
+// before
+func SetConsoleWriter(w io.Writer) func() {
+	console_writer_mutex.Lock()
+	previous := console_writer
+	console_writer = w
+	console_writer_mutex.Unlock()
+	return func() {
+		console_writer_mutex.Lock()
+		console_writer = previous
+		console_writer_mutex.Unlock()
+	}
+}
+
+// returns the writer the console output currently goes to
+func ConsoleWriter() io.Writer {
+	console_writer_mutex.Lock()
+	defer console_writer_mutex.Unlock()
+	return console_writer
+}
+
+// ConsoleOutput is go-console's console output, written to the console writer instead of straight to stdout.
+type ConsoleOutput struct {
+	*output.NullOutput
+}
+
+// returns a decorated go-console output writing to the console writer
+func NewConsoleOutput() *ConsoleOutput {
+	return &ConsoleOutput{output.NewNullOutput(true, nil)}
+}
+
+// function to write a message to the console. as with go-console's console output, the formatted message is printed as a
+// format string, so a "%" in it must be written "%%".
+func (o *ConsoleOutput) Write(message string) {
+	fmt.Fprintf(ConsoleWriter(), o.GetFormatter().Format(message))
+}
+
+// function to write a message and a newline to the console
+func (o *ConsoleOutput) Writeln(message string) {
+	o.Write(message + "\n")
+}
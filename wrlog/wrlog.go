@@ -0,0 +1,263 @@
+package wrlog
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DrSmithFr/go-console/pkg/formatter"
+)
+
+// LOGGING FUNCTIONS
+// what WebRecon does is logged with log/slog. the console shows info and above in the usual coloured style (debug with -v,
+// warnings and errors only with -q), or JSON lines on stderr with -log-json. every run also logs everything, debug included,
+// to run.log in its run directory, so a run which went wrong overnight can be looked into the next morning.
+
+// RunLogFile is the name of the log in each run directory
+const RunLogFile = "run.log"
+
+var (
+	// the console's level, and whether it logs JSON
+	level     = new(slog.LevelVar)
+	json_logs bool
+	// the handler logging to the console, which every logger shares
+	console slog.Handler = &consoleHandler{level: level, mutex: &sync.Mutex{}}
+)
+
+func init() {
+	slog.SetDefault(slog.New(console))
+}
+
+// function to set the console's level, and whether it logs JSON lines to stderr instead of coloured text to stdout
+func Configure(console_level slog.Level, json bool) {
+	level.Set(console_level)
+	json_logs = json
+	if json {
+		console = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	} else {
+		console = &consoleHandler{level: level, mutex: &sync.Mutex{}}
+	}
+	slog.SetDefault(slog.New(console))
+}
+
+// Flags are the logging flags of the commands which run programs.
+type Flags struct {
+	verbose *bool
+	quiet   *bool
+	json    *bool
+}
+
+// function to define the logging flags on flags. call Configure on the result once they are parsed.
+func AddFlags(flags *flag.FlagSet) *Flags {
+	return &Flags{
+		verbose: flags.Bool("v", false, "Log debug messages, including the tools' command lines and output"),
+		quiet:   flags.Bool("q", false, "Only log warnings and errors"),
+		json:    flags.Bool("log-json", false, "Log JSON lines to stderr instead of text"),
+	}
+}
+
+// function to configure logging from the parsed flags
+func (f *Flags) Configure() {
+	console_level := slog.LevelInfo
+	if *f.verbose {
+		console_level = slog.LevelDebug
+	} else if *f.quiet {
+		console_level = slog.LevelWarn
+	}
+	Configure(console_level, *f.json)
+}
+
+// returns a logger which logs to the console
+func Console() *slog.Logger {
+	return slog.New(console)
+}
+
+type contextKey struct{}
+
+// returns a copy of ctx carrying logger, for FromContext
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// returns the logger ctx carries, or one logging to the console
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return Console()
+}
+
+// function to open the log of a program's run at path. returns a logger which logs to it and the console, and a function
+// to close the log. records are tagged with the program, and in the log with the run's date as well.
+func OpenRunLog(path string, program_name string, date string) (*slog.Logger, func(), error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	var run_log slog.Handler = slog.NewTextHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug})
+	if json_logs {
+		run_log = slog.NewJSONHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug})
+	}
+	run_log = run_log.WithAttrs([]slog.Attr{slog.String("date", date)})
+	logger := slog.New(fanoutHandler{console, run_log}).With("program", program_name)
+	return logger, func() { file.Close() }, nil
+}
+
+// fanoutHandler passes every record to each of its handlers which is enabled for it.
+type fanoutHandler []slog.Handler
+
+func (f fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range f {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, record slog.Record) error {
+	var first error
+	for _, handler := range f {
+		if handler.Enabled(ctx, record.Level) {
+			if err := handler.Handle(ctx, record.Clone()); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanoutHandler, len(f))
+	for index, handler := range f {
+		handlers[index] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(fanoutHandler, len(f))
+	for index, handler := range f {
+		handlers[index] = handler.WithGroup(name)
+	}
+	return handlers
+}
+
+// consoleHandler logs records as the coloured lines the rest of the console output uses, e.g.
+//
+//	INFO - Subfinder enumeration complete subdomains=120 duration=42s
+type consoleHandler struct {
+	level slog.Leveler
+	attrs []slog.Attr
+	group string
+	mutex *sync.Mutex
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, record slog.Record) error {
+	var line bytes.Buffer
+	tag, prefix := "info", "INFO - "
+	switch {
+	case record.Level >= slog.LevelError:
+		tag, prefix = "error", "ERROR! - "
+	case record.Level >= slog.LevelWarn:
+		tag, prefix = "comment", "WARNING - "
+	case record.Level < slog.LevelInfo:
+		tag, prefix = "comment", "DEBUG - "
+	}
+	line.WriteString("\t<" + tag + ">" + prefix + escape(record.Message))
+	write := func(attr slog.Attr) {
+		if attr.Equal(slog.Attr{}) {
+			return
+		}
+		value := attr.Value.Resolve()
+		text := value.String()
+		if value.Kind() == slog.KindDuration {
+			text = value.Duration().Round(time.Millisecond).String()
+		}
+		if strings.ContainsAny(text, " \t\"") || text == "" {
+			text = fmt.Sprintf("%q", text)
+		}
+		line.WriteString(" " + attr.Key + "=" + escape(text))
+	}
+	for _, attr := range h.attrs {
+		write(attr)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		if h.group != "" {
+			attr.Key = h.group + "." + attr.Key
+		}
+		write(attr)
+		return true
+	})
+	line.WriteString("</" + tag + ">")
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	// the console output prints with the message as the format string
+	NewConsoleOutput().Writeln(strings.ReplaceAll(line.String(), "%", "%%"))
+	return nil
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	with := *h
+	with.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	if h.group != "" {
+		for index := len(h.attrs); index < len(with.attrs); index++ {
+			with.attrs[index].Key = h.group + "." + with.attrs[index].Key
+		}
+	}
+	return &with
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	with := *h
+	with.group = strings.TrimPrefix(h.group+"."+name, ".")
+	return &with
+}
+
+// returns text with the console's style tags escaped, so a "<" in a tool's output isn't taken for one
+func escape(text string) string {
+	return formatter.Escape(text)
+}
+
+// lineWriter logs each line written to it.
+type lineWriter struct {
+	logger  *slog.Logger
+	level   slog.Level
+	message string
+	mutex   sync.Mutex
+	partial []byte
+}
+
+// returns a writer which logs each line written to it at level, as message with the line in "line". for logging the
+// output of a command.
+func LineWriter(logger *slog.Logger, level slog.Level, message string) io.Writer {
+	return &lineWriter{logger: logger, level: level, message: message}
+}
+
+func (w *lineWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.partial = append(w.partial, data...)
+	for {
+		index := bytes.IndexAny(w.partial, "\r\n")
+		if index < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(w.partial[:index])); line != "" {
+			w.logger.Log(context.Background(), w.level, w.message, "line", line)
+		}
+		w.partial = w.partial[index+1:]
+	}
+	return len(data), nil
+}
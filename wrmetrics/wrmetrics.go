@@ -0,0 +1,302 @@
+package wrmetrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// METRICS FUNCTIONS
+// the runs WebRecon does are counted in metrics, which the API server and the daemon expose at /metrics in the Prometheus
+// text format. the metrics only live as long as the process, so they are counters Prometheus takes rates of, like any other:
+//
+//	webrecon_runs_started_total{program}                       runs started
+//	webrecon_runs_finished_total{program,status}               runs which ended, by status (complete, failed, cancelled)
+//	webrecon_runs_active{program}                              runs in progress
+//	webrecon_stage_duration_seconds{program,stage}             how long each stage took (a histogram)
+//	webrecon_stage_failures_total{program,stage}               stages which failed
+//	webrecon_candidates_generated_total{program,source}        candidate subdomains generated (sub-generator, dnsgen)
+//	webrecon_subdomains_found_total{program,source}            subdomains found by each source (amass, subfinder, ...)
+//	webrecon_dns_queries_total{program,stage}                  DNS queries sent by the resolution stages and the PTR sweep, as they're sent
+//	webrecon_resolver_queries_total{resolver,result}           queries sent each resolver, by result (success, failure)
+//
+// the PTR sweep's lookups are counted against the resolver they went to. puredns's output doesn't say which resolver
+// answered a name, or which queries failed, so the answers of the resolution rounds are counted under resolver="puredns".
+
+// the upper bounds of the stage duration buckets, in seconds. stages take from seconds (a small program's subfinder run)
+// to hours (resolving millions of permutations).
+var stageDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200, 14400, 28800}
+
+var (
+	runsStarted     = newCounter("webrecon_runs_started_total", "Runs started.", "program")
+	runsFinished    = newCounter("webrecon_runs_finished_total", "Runs which ended, by status.", "program", "status")
+	runsActive      = newGauge("webrecon_runs_active", "Runs in progress.", "program")
+	stageDurations  = newHistogram("webrecon_stage_duration_seconds", "How long the stages of runs took.", stageDurationBuckets, "program", "stage")
+	stageFailures   = newCounter("webrecon_stage_failures_total", "Stages which failed.", "program", "stage")
+	candidates      = newCounter("webrecon_candidates_generated_total", "Candidate subdomains generated, by source.", "program", "source")
+	subdomains      = newCounter("webrecon_subdomains_found_total", "Subdomains found, by source.", "program", "source")
+	dnsQueries      = newCounter("webrecon_dns_queries_total", "DNS queries sent, by stage.", "program", "stage")
+	resolverQueries = newCounter("webrecon_resolver_queries_total", "Queries sent to each resolver, by result.", "resolver", "result")
+	// every metric, in the order they are written
+	registry = []metric{runsStarted, runsFinished, runsActive, stageDurations, stageFailures, candidates, subdomains, dnsQueries, resolverQueries}
+)
+
+// function to record that a run of a program started
+func RunStarted(program_name string) {
+	runsStarted.add(1, program_name)
+	runsActive.add(1, program_name)
+}
+
+// function to record that a run of a program ended with status
+func RunFinished(program_name string, status string) {
+	runsFinished.add(1, program_name, status)
+	runsActive.add(-1, program_name)
+}
+
+// function to record how long a stage of a run took, and whether it failed
+func ObserveStage(program_name string, stage string, duration time.Duration, err error) {
+	stageDurations.observe(duration.Seconds(), program_name, stage)
+	if err != nil {
+		stageFailures.add(1, program_name, stage)
+	}
+}
+
+// function to record that source generated n candidate subdomains
+func AddCandidates(program_name string, source string, n int) {
+	candidates.add(float64(n), program_name, source)
+}
+
+// function to record that source found n subdomains
+func AddSubdomains(program_name string, source string, n int) {
+	subdomains.add(float64(n), program_name, source)
+}
+
+// function to record that a stage sent n DNS queries
+func AddDNSQueries(program_name string, stage string, n int) {
+	dnsQueries.add(float64(n), program_name, stage)
+}
+
+// function to record a query sent to a resolver, and whether it was answered. an answer saying the name doesn't exist
+// is a success; timeouts and server failures aren't.
+func ObserveResolver(resolver string, answered bool) {
+	AddResolverResults(resolver, answered, 1)
+}
+
+// function to record n queries sent to a resolver which were all answered, or all weren't
+func AddResolverResults(resolver string, answered bool, n int) {
+	result := "success"
+	if !answered {
+		result = "failure"
+	}
+	resolverQueries.add(float64(n), resolver, result)
+}
+
+// returns a handler serving every metric in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// function to serve the metrics at http://<address>/metrics until ctx is cancelled
+func Serve(ctx context.Context, address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 30 * time.Second}
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdown)
+}
+
+// function to write every metric to w in the Prometheus text format
+func Write(w io.Writer) error {
+	for _, m := range registry {
+		if _, err := io.WriteString(w, m.text()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metric is a family of series, one for each combination of its label values.
+type metric interface {
+	text() string
+}
+
+// vector holds the values of a metric's series, keyed by their label values.
+type vector struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	// histograms only: the observations in each bucket (not cumulative), and their sum
+	buckets []uint64
+	sum     float64
+}
+
+func newVector(name string, help string, kind string, labels []string) *vector {
+	return &vector{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+}
+
+// returns the series with the given label values, creating it if needed. the vector must be locked.
+func (v *vector) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{values: append([]string{}, values...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// returns the series in the order of their label values, so scrapes are stable
+func (v *vector) sorted() []*series {
+	all := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].values, "\xff") < strings.Join(all[j].values, "\xff")
+	})
+	return all
+}
+
+// returns the metric's HELP and TYPE lines
+func (v *vector) header() string {
+	return "# HELP " + v.name + " " + v.help + "\n# TYPE " + v.name + " " + v.kind + "\n"
+}
+
+// returns the label set of a series, with extra name/value pairs appended, e.g. {program="Starbucks",le="60"}
+func (v *vector) labelSet(values []string, extra ...string) string {
+	var pairs []string
+	for index, label := range v.labels {
+		pairs = append(pairs, label+`="`+escapeLabel(values[index])+`"`)
+	}
+	for index := 0; index+1 < len(extra); index += 2 {
+		pairs = append(pairs, extra[index]+`="`+escapeLabel(extra[index+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// counter is a metric which only goes up; gauge one which goes up and down.
+type counter struct{ *vector }
+
+func newCounter(name string, help string, labels ...string) counter {
+	return counter{newVector(name, help, "counter", labels)}
+}
+
+type gauge struct{ *vector }
+
+func newGauge(name string, help string, labels ...string) gauge {
+	return gauge{newVector(name, help, "gauge", labels)}
+}
+
+// function to add delta to the series with the given label values
+func (v *vector) add(delta float64, values ...string) {
+	v.mutex.Lock()
+	v.get(values).value += delta
+	v.mutex.Unlock()
+}
+
+func (v *vector) text() string {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	var text strings.Builder
+	text.WriteString(v.header())
+	for _, s := range v.sorted() {
+		text.WriteString(v.name + v.labelSet(s.values) + " " + formatValue(s.value) + "\n")
+	}
+	return text.String()
+}
+
+// histogram is a metric counting observations into buckets.
+type histogram struct {
+	*vector
+	bounds []float64
+}
+
+func newHistogram(name string, help string, bounds []float64, labels ...string) histogram {
+	return histogram{newVector(name, help, "histogram", labels), bounds}
+}
+
+// function to record an observation in the series with the given label values
+func (h histogram) observe(value float64, values ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := h.get(values)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.bounds)+1)
+	}
+	// the last bucket is +Inf
+	s.buckets[sort.SearchFloat64s(h.bounds, value)] += 1
+	s.sum += value
+}
+
+func (h histogram) text() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var text strings.Builder
+	text.WriteString(h.header())
+	for _, s := range h.sorted() {
+		cumulative := uint64(0)
+		for index, count := range s.buckets {
+			cumulative += count
+			bound := "+Inf"
+			if index < len(h.bounds) {
+				bound = formatValue(h.bounds[index])
+			}
+			text.WriteString(h.name + "_bucket" + h.labelSet(s.values, "le", bound) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		text.WriteString(h.name + "_sum" + h.labelSet(s.values) + " " + formatValue(s.sum) + "\n")
+		text.WriteString(h.name + "_count" + h.labelSet(s.values) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+	}
+	return text.String()
+}
+
+// returns a label value escaped for the text format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// returns a sample value in the text format
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case value == math.Trunc(value) && math.Abs(value) < 1e15:
+		return fmt.Sprintf("%d", int64(value))
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
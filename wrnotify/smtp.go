@@ -0,0 +1,273 @@
+package wrnotify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// SMTP FUNCTIONS
+// run summaries are emailed as plain text, optionally with the run's report.html attached. the connection is upgraded with
+// STARTTLS by default, and credentials are never sent over a connection that isn't encrypted (net/smtp refuses to, except to localhost).
+
+// SMTP connection security modes
+var SMTPSecurityModes = []string{"starttls", "tls", "none"}
+
+// the certificates SMTP servers are verified against; nil is the system's
+var smtpRootCAs *x509.CertPool
+
+// SMTPConfig is an email notifier in notify.json. The password can be read from an environment variable instead of the file.
+type SMTPConfig struct {
+	Host         string   `json:"host"`
+	Port         int      `json:"port"`
+	Security     string   `json:"security"`
+	Username     string   `json:"username"`
+	Password     string   `json:"password"`
+	PasswordEnv  string   `json:"password_env"`
+	From         string   `json:"from"`
+	To           []string `json:"to"`
+	AttachReport bool     `json:"attach_report"`
+	Phases       bool     `json:"phases"`
+	Programs     []string `json:"programs"`
+}
+
+// SMTPNotifier emails events.
+type SMTPNotifier struct {
+	config SMTPConfig
+}
+
+// function to create an SMTP notifier. the port defaults to 587, or 465 for implicit TLS.
+func NewSMTPNotifier(config SMTPConfig) (*SMTPNotifier, error) {
+	if config.Security == "" {
+		config.Security = "starttls"
+	}
+	if !wrutils.SliceContainsString(SMTPSecurityModes, config.Security) {
+		return nil, fmt.Errorf("unknown security %s, expected one of %s", config.Security, strings.Join(SMTPSecurityModes, ", "))
+	}
+	if config.Port == 0 {
+		config.Port = 587
+		if config.Security == "tls" {
+			config.Port = 465
+		}
+	}
+	if config.Host == "" || config.From == "" || len(config.To) == 0 {
+		return nil, fmt.Errorf("host, from and to are required")
+	}
+	for _, address := range append([]string{config.From}, config.To...) {
+		if _, err := bareAddress(address); err != nil {
+			return nil, err
+		}
+	}
+	if config.PasswordEnv != "" {
+		config.Password = os.Getenv(config.PasswordEnv)
+		if config.Password == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", config.PasswordEnv)
+		}
+	}
+	return &SMTPNotifier{config: config}, nil
+}
+
+func (s *SMTPNotifier) Name() string {
+	return "email to " + strings.Join(s.config.To, ", ") + " via " + s.config.Host
+}
+
+func (s *SMTPNotifier) Wants(event Event) bool {
+	return wants(s.config.Programs, s.config.Phases, event)
+}
+
+func (s *SMTPNotifier) Notify(event Event) error {
+	message, err := s.message(event)
+	if err != nil {
+		return err
+	}
+	return s.send(message)
+}
+
+// function to write an event as a plain text summary
+func TextSummary(event Event) string {
+	var text strings.Builder
+	fmt.Fprintln(&text, event.Title())
+	fmt.Fprintln(&text)
+	for _, line := range summaryLines(event, "") {
+		fmt.Fprintln(&text, line)
+	}
+	if len(event.NewHosts) > 0 {
+		hosts, more := listedHosts(event.NewHosts)
+		fmt.Fprintln(&text, "\nNew subdomains:")
+		for _, host := range hosts {
+			fmt.Fprintln(&text, "  "+host)
+		}
+		if more > 0 {
+			fmt.Fprintf(&text, "  ...and %d more\n", more)
+		}
+	}
+	if len(event.Takeovers) > 0 {
+		fmt.Fprintln(&text, "\nTakeover candidates:")
+		for _, candidate := range event.Takeovers {
+			fmt.Fprintf(&text, "  [%s] %s -> %s (%s)\n", candidate.Confidence, candidate.Host, candidate.CNAME, candidate.Reason)
+		}
+	}
+	if len(event.Stages) > 0 {
+		fmt.Fprintln(&text, "\nStages:")
+		for _, stage := range event.Stages {
+			fmt.Fprintf(&text, "  %-16s %8d  %s\n", stage.Name, stage.Count, stage.Duration())
+		}
+	}
+	if event.ReportPath != "" {
+		fmt.Fprintln(&text, "\nFull report: "+event.ReportPath)
+	}
+	return text.String()
+}
+
+// builds the email for an event, with the report attached if configured and it exists
+func (s *SMTPNotifier) message(event Event) ([]byte, error) {
+	var message bytes.Buffer
+	headers := []string{
+		"From: " + s.config.From,
+		"To: " + strings.Join(s.config.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", event.Title()),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + messageID(s.config.From),
+		"MIME-Version: 1.0",
+	}
+
+	var report []byte
+	if s.config.AttachReport && event.ReportPath != "" {
+		report, _ = os.ReadFile(event.ReportPath)
+	}
+	if report == nil {
+		headers = append(headers, "Content-Type: text/plain; charset=utf-8", "Content-Transfer-Encoding: 8bit")
+		message.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+		message.WriteString(strings.ReplaceAll(TextSummary(event), "\n", "\r\n"))
+		return message.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}, "Content-Transfer-Encoding": {"8bit"}})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(strings.ReplaceAll(TextSummary(event), "\n", "\r\n")))
+	name := fmt.Sprintf("webrecon-%s-%s.html", event.Program, event.Date)
+	part, err = writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType("text/html", map[string]string{"charset": "utf-8", "name": name})},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(report)
+	for len(encoded) > 76 {
+		part.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	part.Write([]byte(encoded + "\r\n"))
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	headers = append(headers, "Content-Type: "+mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": writer.Boundary()}))
+	message.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// returns the bare address of "Name <address>"
+func bareAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q", address)
+	}
+	return parsed.Address, nil
+}
+
+// returns a unique Message-ID in the domain of the sender
+func messageID(from string) string {
+	domain := "webrecon"
+	if address, err := bareAddress(from); err == nil && strings.Contains(address, "@") {
+		domain = address[strings.LastIndex(address, "@")+1:]
+	}
+	random := make([]byte, 12)
+	rand.Read(random)
+	return fmt.Sprintf("<%d.%x@%s>", time.Now().UnixNano(), random, domain)
+}
+
+// sends an email, upgrading the connection and authenticating as configured
+func (s *SMTPNotifier) send(message []byte) error {
+	address := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
+	tls_config := &tls.Config{ServerName: s.config.Host, RootCAs: smtpRootCAs}
+	var connection net.Conn
+	var err error
+	if s.config.Security == "tls" {
+		connection, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", address, tls_config)
+	} else {
+		connection, err = net.DialTimeout("tcp", address, 30*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	connection.SetDeadline(time.Now().Add(2 * time.Minute))
+	client, err := smtp.NewClient(connection, s.config.Host)
+	if err != nil {
+		connection.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.config.Security == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s doesn't support STARTTLS (set \"security\": \"none\" to send unencrypted)", s.config.Host)
+		}
+		if err := client.StartTLS(tls_config); err != nil {
+			return err
+		}
+	}
+	if s.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)); err != nil {
+			return err
+		}
+	}
+	from, err := bareAddress(s.config.From)
+	if err != nil {
+		return err
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range s.config.To {
+		to, err := bareAddress(recipient)
+		if err != nil {
+			return err
+		}
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,268 @@
+package wrnotify
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSession is what a client sent the test SMTP server
+type smtpSession struct {
+	tls  bool
+	auth string
+	from string
+	to   []string
+	data []byte
+}
+
+// function to start an SMTP server on localhost which accepts one session, offering STARTTLS with certificate if it isn't
+// nil. returns its port, and a channel receiving the session once the client quits.
+func startSMTPServer(t *testing.T, certificate *tls.Certificate) (int, <-chan smtpSession) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+		connection.SetDeadline(time.Now().Add(10 * time.Second))
+		var session smtpSession
+		text := textproto.NewConn(connection)
+		text.PrintfLine("220 127.0.0.1 ESMTP test")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, argument, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				extensions := []string{"127.0.0.1", "8BITMIME"}
+				if certificate != nil && !session.tls {
+					extensions = append(extensions, "STARTTLS")
+				}
+				if session.tls {
+					extensions = append(extensions, "AUTH PLAIN")
+				}
+				for index, extension := range extensions {
+					separator := "-"
+					if index == len(extensions)-1 {
+						separator = " "
+					}
+					text.PrintfLine("250%s%s", separator, extension)
+				}
+			case "STARTTLS":
+				text.PrintfLine("220 ready to start TLS")
+				secure := tls.Server(connection, &tls.Config{Certificates: []tls.Certificate{*certificate}})
+				if err := secure.Handshake(); err != nil {
+					return
+				}
+				session.tls = true
+				text = textproto.NewConn(secure)
+			case "AUTH":
+				session.auth = argument
+				text.PrintfLine("235 authenticated")
+			case "MAIL":
+				session.from = argument
+				text.PrintfLine("250 ok")
+			case "RCPT":
+				session.to = append(session.to, argument)
+				text.PrintfLine("250 ok")
+			case "DATA":
+				text.PrintfLine("354 send the message")
+				if session.data, err = text.ReadDotBytes(); err != nil {
+					return
+				}
+				text.PrintfLine("250 queued")
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				sessions <- session
+				return
+			default:
+				text.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, sessions
+}
+
+// returns a self-signed certificate for 127.0.0.1, and a pool trusting it
+func testCertificate(t *testing.T) (*tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(parsed)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots
+}
+
+// returns the session the server received, failing the test if it didn't finish
+func receiveSession(t *testing.T, sessions <-chan smtpSession) smtpSession {
+	t.Helper()
+	select {
+	case session := <-sessions:
+		return session
+	case <-time.After(10 * time.Second):
+		t.Fatal("the server didn't receive a message")
+	}
+	return smtpSession{}
+}
+
+func TestSMTPWithoutEncryption(t *testing.T) {
+	port, sessions := startSMTPServer(t, nil)
+	notifier, err := NewSMTPNotifier(SMTPConfig{Host: "127.0.0.1", Port: port, Security: "none", From: "WebRecon <webrecon@example.com>", To: []string{"team@example.com", "Alice <alice@example.com>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(ExampleEvent()); err != nil {
+		t.Fatalf("could not send - %v", err)
+	}
+	session := receiveSession(t, sessions)
+	if session.tls || session.auth != "" {
+		t.Errorf("expected a plain session without credentials, got tls=%v auth=%q", session.tls, session.auth)
+	}
+	if !strings.HasPrefix(session.from, "FROM:<webrecon@example.com>") {
+		t.Errorf("unexpected sender %q", session.from)
+	}
+	if strings.Join(session.to, ",") != "TO:<team@example.com>,TO:<alice@example.com>" {
+		t.Errorf("unexpected recipients %q", session.to)
+	}
+	message, err := mail.ReadMessage(strings.NewReader(string(session.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject")); !strings.HasPrefix(subject, "WebRecon: example run") {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	if !strings.HasPrefix(message.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("expected a plain text message, got %q", message.Header.Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(message.Body)
+	if !strings.Contains(string(body), "120 subdomains") || !strings.Contains(string(body), "api.example.com") {
+		t.Errorf("expected the summary in the body, got %q", body)
+	}
+}
+
+func TestSMTPStartTLS(t *testing.T) {
+	certificate, roots := testCertificate(t)
+	smtpRootCAs = roots
+	t.Cleanup(func() { smtpRootCAs = nil })
+	port, sessions := startSMTPServer(t, certificate)
+	notifier, err := NewSMTPNotifier(SMTPConfig{Host: "127.0.0.1", Port: port, Username: "webrecon", Password: "hunter2", From: "webrecon@example.com", To: []string{"team@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(ExampleEvent()); err != nil {
+		t.Fatalf("could not send - %v", err)
+	}
+	session := receiveSession(t, sessions)
+	if !session.tls {
+		t.Error("expected the session to be upgraded with STARTTLS")
+	}
+	if session.auth != "PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00webrecon\x00hunter2")) {
+		t.Errorf("unexpected credentials %q", session.auth)
+	}
+}
+
+func TestSMTPStartTLSRequired(t *testing.T) {
+	port, _ := startSMTPServer(t, nil)
+	notifier, err := NewSMTPNotifier(SMTPConfig{Host: "127.0.0.1", Port: port, From: "webrecon@example.com", To: []string{"team@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(ExampleEvent()); err == nil || !strings.Contains(err.Error(), "doesn't support STARTTLS") {
+		t.Fatalf("expected a server without STARTTLS to be refused, got %v", err)
+	}
+}
+
+func TestSMTPAttachesReport(t *testing.T) {
+	report := []byte("<html><body>" + strings.Repeat("report ", 50) + "</body></html>")
+	event := ExampleEvent()
+	event.ReportPath = filepath.Join(t.TempDir(), "report.html")
+	if err := os.WriteFile(event.ReportPath, report, 0644); err != nil {
+		t.Fatal(err)
+	}
+	port, sessions := startSMTPServer(t, nil)
+	notifier, err := NewSMTPNotifier(SMTPConfig{Host: "127.0.0.1", Port: port, Security: "none", From: "webrecon@example.com", To: []string{"team@example.com"}, AttachReport: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(event); err != nil {
+		t.Fatalf("could not send - %v", err)
+	}
+	message, err := mail.ReadMessage(strings.NewReader(string(receiveSession(t, sessions).data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	media_type, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || media_type != "multipart/mixed" {
+		t.Fatalf("expected a multipart message, got %q", message.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(message.Body, params["boundary"])
+	summary, err := parts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, _ := io.ReadAll(summary); !strings.Contains(string(text), "Full report: "+event.ReportPath) {
+		t.Errorf("expected the summary first, got %q", text)
+	}
+	attachment, err := parts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "webrecon-example-" + event.Date + ".html"; attachment.FileName() != want {
+		t.Errorf("expected the attachment to be named %s, got %q", want, attachment.FileName())
+	}
+	decoded, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bufio.NewReader(attachment)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != string(report) {
+		t.Errorf("the attachment doesn't match the report")
+	}
+	if _, err := parts.NextPart(); err != io.EOF {
+		t.Errorf("expected 2 parts, got more (%v)", err)
+	}
+}
@@ -0,0 +1,207 @@
+package wrnotify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// WEBHOOK FUNCTIONS
+// events are posted as JSON to webhooks, formatted for Slack, Discord or as the generic Event. failed posts are retried with
+// exponential backoff; 4xx responses other than 429 aren't, since sending the same payload again won't help.
+
+// webhook payload formats
+var WebhookFormats = []string{"slack", "discord", "json"}
+
+// number of attempts at posting an event, and the wait before the first retry (doubled for each retry after it)
+const webhookAttempts = 4
+
+var webhookBackoff = 2 * time.Second
+
+// WebhookConfig is a webhook in notify.json.
+type WebhookConfig struct {
+	URL      string            `json:"url"`
+	Format   string            `json:"format"`
+	Phases   bool              `json:"phases"`
+	Programs []string          `json:"programs"`
+	Headers  map[string]string `json:"headers"`
+}
+
+// WebhookNotifier posts events to a webhook.
+type WebhookNotifier struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+// function to create a webhook notifier. the format defaults to generic JSON.
+func NewWebhookNotifier(config WebhookConfig) (*WebhookNotifier, error) {
+	if config.Format == "" {
+		config.Format = "json"
+	}
+	if !wrutils.SliceContainsString(WebhookFormats, config.Format) {
+		return nil, fmt.Errorf("unknown format %s, expected one of %s", config.Format, strings.Join(WebhookFormats, ", "))
+	}
+	parsed, err := url.Parse(config.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", config.URL)
+	}
+	return &WebhookNotifier{config: config, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (w *WebhookNotifier) Name() string {
+	parsed, _ := url.Parse(w.config.URL)
+	// the path of Slack and Discord webhooks is their secret, so only the host is printed
+	return w.config.Format + " webhook " + parsed.Host
+}
+
+func (w *WebhookNotifier) Wants(event Event) bool {
+	return wants(w.config.Programs, w.config.Phases, event)
+}
+
+func (w *WebhookNotifier) Notify(event Event) error {
+	var payload interface{}
+	switch w.config.Format {
+	case "slack":
+		payload = SlackPayload(event)
+	case "discord":
+		payload = DiscordPayload(event)
+	default:
+		payload = event
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return w.post(body)
+}
+
+// posts a payload, retrying with backoff
+func (w *WebhookNotifier) post(body []byte) error {
+	wait := webhookBackoff
+	var last_error error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		request, err := http.NewRequest("POST", w.config.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("User-Agent", "WebRecon")
+		for name, value := range w.config.Headers {
+			request.Header.Set(name, value)
+		}
+		response, err := w.client.Do(request)
+		if err != nil {
+			last_error = err
+			continue
+		}
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		response.Body.Close()
+		if response.StatusCode < 300 {
+			return nil
+		}
+		last_error = fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(message)))
+		if response.StatusCode == http.StatusTooManyRequests {
+			// rate limited; wait as long as we're told to if that's longer than the backoff
+			if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && time.Duration(seconds)*time.Second > wait {
+				wait = time.Duration(seconds) * time.Second
+			}
+		} else if response.StatusCode < 500 {
+			return last_error
+		}
+	}
+	return fmt.Errorf("gave up after %d attempts - %v", webhookAttempts, last_error)
+}
+
+// returns the summary lines of an event, with its counts wrapped in bold markup (* for Slack, ** for Discord)
+func summaryLines(event Event, bold string) []string {
+	lines := []string{}
+	if event.Type == EventPhase {
+		lines = append(lines, fmt.Sprintf("%s%d%s subdomains after %s (%s elapsed)", bold, event.Subdomains, bold, event.Phase, event.Duration()))
+		return lines
+	}
+	if event.Error != "" {
+		lines = append(lines, fmt.Sprintf("The run %s after %s: %s", event.Status, event.Duration(), event.Error))
+		return lines
+	}
+	// a run made without -probe has no HTTP answers to count
+	if event.Probed {
+		lines = append(lines, fmt.Sprintf("%s%d%s subdomains, %d with DNS records, %d answered HTTP. Finished in %s.", bold, event.Subdomains, bold, event.Resolved, event.Alive, event.Duration()))
+	} else {
+		lines = append(lines, fmt.Sprintf("%s%d%s subdomains, %d with DNS records. Finished in %s.", bold, event.Subdomains, bold, event.Resolved, event.Duration()))
+	}
+	if event.PreviousDate != "" {
+		lines = append(lines, fmt.Sprintf("%s%d%s new and %d gone since %s.", bold, len(event.NewHosts), bold, len(event.RemovedHosts), event.PreviousDate))
+	}
+	if len(event.Takeovers) > 0 {
+		lines = append(lines, fmt.Sprintf("%s%d takeover candidates%s.", bold, len(event.Takeovers), bold))
+	}
+	return lines
+}
+
+// returns the new hosts and takeover candidates of an event as code-formatted lists
+func detailLines(event Event) []string {
+	var lines []string
+	if len(event.NewHosts) > 0 {
+		hosts, more := listedHosts(event.NewHosts)
+		lines = append(lines, "New subdomains:", "```"+strings.Join(hosts, "\n")+"```")
+		if more > 0 {
+			lines = append(lines, fmt.Sprintf("...and %d more", more))
+		}
+	}
+	if len(event.Takeovers) > 0 {
+		var takeovers []string
+		for index, candidate := range event.Takeovers {
+			if index == maxListedHosts {
+				takeovers = append(takeovers, fmt.Sprintf("...and %d more", len(event.Takeovers)-maxListedHosts))
+				break
+			}
+			takeovers = append(takeovers, fmt.Sprintf("[%s] %s -> %s (%s)", candidate.Confidence, candidate.Host, candidate.CNAME, candidate.Reason))
+		}
+		lines = append(lines, "Takeover candidates:", "```"+strings.Join(takeovers, "\n")+"```")
+	}
+	return lines
+}
+
+// function to format an event as a Slack incoming webhook message
+func SlackPayload(event Event) map[string]interface{} {
+	text := append([]string{"*" + event.Title() + "*"}, summaryLines(event, "*")...)
+	text = append(text, detailLines(event)...)
+	return map[string]interface{}{"text": strings.Join(text, "\n")}
+}
+
+// function to format an event as a Discord webhook message with an embed. the embed is red when there are takeover candidates
+// or the run failed.
+func DiscordPayload(event Event) map[string]interface{} {
+	color := 0x2da44e
+	if len(event.Takeovers) > 0 || event.Error != "" {
+		color = 0xcf222e
+	} else if event.Type == EventPhase {
+		color = 0x0969da
+	}
+	description := strings.Join(append(summaryLines(event, "**"), detailLines(event)...), "\n")
+	// embed descriptions are limited to 4096 characters, so it is cut on a character, not in the middle of one
+	if runes := []rune(description); len(runes) > 4000 {
+		description = string(runes[:4000]) + "\n..."
+	}
+	return map[string]interface{}{
+		"username": "WebRecon",
+		"embeds": []map[string]interface{}{{
+			"title":       event.Title(),
+			"description": description,
+			"color":       color,
+			"timestamp":   event.Time.Format(time.RFC3339),
+		}},
+	}
+}
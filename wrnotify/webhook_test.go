@@ -0,0 +1,216 @@
+package wrnotify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// webhookServer records the requests posted to it, answering each with the next status in statuses (200 once they run out)
+type webhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	server := &webhookServer{statuses: statuses}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		server.mutex.Lock()
+		server.bodies = append(server.bodies, body)
+		server.headers = append(server.headers, r.Header.Clone())
+		status := http.StatusOK
+		if len(server.statuses) > 0 {
+			status, server.statuses = server.statuses[0], server.statuses[1:]
+		}
+		server.mutex.Unlock()
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// returns the number of requests the server received
+func (s *webhookServer) requests() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.bodies)
+}
+
+// function to make the retries of a test wait a millisecond instead of seconds
+func fastBackoff(t *testing.T) {
+	backoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	t.Cleanup(func() { webhookBackoff = backoff })
+}
+
+// function to post an event to the server in format, failing the test if it isn't delivered. returns the decoded body.
+func notifyWebhook(t *testing.T, server *webhookServer, format string, event Event) map[string]interface{} {
+	t.Helper()
+	notifier, err := NewWebhookNotifier(WebhookConfig{URL: server.URL + "/hook", Format: format, Headers: map[string]string{"X-Token": "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(event); err != nil {
+		t.Fatalf("could not notify - %v", err)
+	}
+	if server.requests() != 1 {
+		t.Fatalf("expected 1 request, got %d", server.requests())
+	}
+	if got := server.headers[0].Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected a JSON content type, got %q", got)
+	}
+	if got := server.headers[0].Get("X-Token"); got != "secret" {
+		t.Errorf("expected the configured header to be sent, got %q", got)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(server.bodies[0], &payload); err != nil {
+		t.Fatalf("could not parse the payload - %v", err)
+	}
+	return payload
+}
+
+func TestSlackPayload(t *testing.T) {
+	server := newWebhookServer(t)
+	payload := notifyWebhook(t, server, "slack", ExampleEvent())
+	text, _ := payload["text"].(string)
+	for _, want := range []string{"*WebRecon: example run", "*120* subdomains", "api.example.com", "docs.example.com -> example.github.io"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected the Slack text to contain %q, got %q", want, text)
+		}
+	}
+}
+
+func TestDiscordPayload(t *testing.T) {
+	server := newWebhookServer(t)
+	payload := notifyWebhook(t, server, "discord", ExampleEvent())
+	if payload["username"] != "WebRecon" {
+		t.Errorf("expected the username WebRecon, got %v", payload["username"])
+	}
+	embeds, _ := payload["embeds"].([]interface{})
+	if len(embeds) != 1 {
+		t.Fatalf("expected 1 embed, got %v", payload["embeds"])
+	}
+	embed := embeds[0].(map[string]interface{})
+	if !strings.HasPrefix(embed["title"].(string), "WebRecon: example run") {
+		t.Errorf("unexpected title %q", embed["title"])
+	}
+	if !strings.Contains(embed["description"].(string), "**120** subdomains") {
+		t.Errorf("expected the description to hold the counts, got %q", embed["description"])
+	}
+	// the example has a takeover candidate, so the embed is red
+	if embed["color"] != float64(0xcf222e) {
+		t.Errorf("expected the takeover colour, got %v", embed["color"])
+	}
+}
+
+func TestDiscordPayloadTruncatesOnRunes(t *testing.T) {
+	event := ExampleEvent()
+	event.NewHosts = nil
+	for index := 0; index < maxListedHosts; index++ {
+		event.NewHosts = append(event.NewHosts, strings.Repeat("é", 200)+".example.com")
+	}
+	embed := DiscordPayload(event)["embeds"].([]map[string]interface{})[0]
+	description := embed["description"].(string)
+	if !utf8.ValidString(description) {
+		t.Fatal("the description was cut in the middle of a character")
+	}
+	if !strings.HasSuffix(description, "\n...") || utf8.RuneCountInString(description) > 4096 {
+		t.Errorf("expected the description to be cut to 4000 characters, got %d", utf8.RuneCountInString(description))
+	}
+}
+
+func TestGenericPayload(t *testing.T) {
+	server := newWebhookServer(t)
+	event := ExampleEvent()
+	notifyWebhook(t, server, "", event)
+	var got Event
+	if err := json.Unmarshal(server.bodies[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Program != event.Program || got.Subdomains != event.Subdomains || len(got.NewHosts) != len(event.NewHosts) || len(got.Takeovers) != 1 {
+		t.Errorf("expected the event as is, got %+v", got)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	fastBackoff(t)
+	// a server error and a rate limit are retried
+	server := newWebhookServer(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	notifier, _ := NewWebhookNotifier(WebhookConfig{URL: server.URL})
+	if err := notifier.Notify(ExampleEvent()); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if server.requests() != 3 {
+		t.Errorf("expected 3 requests, got %d", server.requests())
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	fastBackoff(t)
+	server := newWebhookServer(t, 502, 502, 502, 502, 502)
+	notifier, _ := NewWebhookNotifier(WebhookConfig{URL: server.URL})
+	err := notifier.Notify(ExampleEvent())
+	if err == nil || !strings.Contains(err.Error(), "gave up after 4 attempts") {
+		t.Fatalf("expected the notifier to give up, got %v", err)
+	}
+	if server.requests() != webhookAttempts {
+		t.Errorf("expected %d requests, got %d", webhookAttempts, server.requests())
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	fastBackoff(t)
+	server := newWebhookServer(t, http.StatusNotFound)
+	notifier, _ := NewWebhookNotifier(WebhookConfig{URL: server.URL})
+	if err := notifier.Notify(ExampleEvent()); err == nil {
+		t.Fatal("expected a 404 to fail")
+	}
+	if server.requests() != 1 {
+		t.Errorf("expected 1 request, got %d", server.requests())
+	}
+}
+
+func TestFailedRunPayload(t *testing.T) {
+	server := newWebhookServer(t)
+	run := wrutils.RunInfo{Program: "example", Date: "10-19-2026", Status: wrutils.RunStatusFailed, Error: "could not run puredns - exit status 1", DurationSeconds: 3600}
+	payload := notifyWebhook(t, server, "slack", RunInfoEvent(run))
+	text, _ := payload["text"].(string)
+	for _, want := range []string{"*WebRecon: example run 10-19-2026 failed*", "The run failed after 1h0m0s: could not run puredns - exit status 1"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected the Slack text to contain %q, got %q", want, text)
+		}
+	}
+	if strings.Contains(text, "subdomains") {
+		t.Errorf("expected no counts for a failed run, got %q", text)
+	}
+}
+
+func TestPayloadWithoutProbe(t *testing.T) {
+	event := ExampleEvent()
+	event.Probed, event.Alive = false, 0
+	text := SlackPayload(event)["text"].(string)
+	if strings.Contains(text, "answered HTTP") {
+		t.Errorf("expected no HTTP count for a run made without -probe, got %q", text)
+	}
+	if !strings.Contains(text, "*120* subdomains, 95 with DNS records. Finished in") {
+		t.Errorf("expected the other counts, got %q", text)
+	}
+	if text := SlackPayload(ExampleEvent())["text"].(string); !strings.Contains(text, "60 answered HTTP") {
+		t.Errorf("expected the HTTP count for a probed run, got %q", text)
+	}
+}
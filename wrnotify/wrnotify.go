@@ -0,0 +1,268 @@
+package wrnotify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrreport"
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// NOTIFICATION FUNCTIONS
+// notifiers are told about runs through events: one when a run ends and, for notifiers that want them, one after each phase.
+// they are configured in ./notify.json, which isn't committed since it holds webhook URLs and credentials:
+//
+//	{
+//	  "webhooks": [{"url": "https://hooks.slack.com/services/...", "format": "slack", "phases": false, "programs": ["Starbucks"]}],
+//	  "smtp": [{"host": "smtp.example.com", "username": "webrecon", "password_env": "SMTP_PASSWORD", "from": "webrecon@example.com", "to": ["team@example.com"]}]
+//	}
+
+// DefaultConfigFile is where notifiers are configured
+const DefaultConfigFile = "./notify.json"
+
+// event types
+const (
+	EventRun   = "run"
+	EventPhase = "phase"
+)
+
+// the most hosts listed in a message. the full lists are in the generic JSON payload and the run's files.
+const maxListedHosts = 25
+
+// Event is what notifiers are told about a run. Phase events only carry the stages finished so far.
+type Event struct {
+	Type            string    `json:"type"`
+	Program         string    `json:"program"`
+	Date            string    `json:"date"`
+	Phase           string    `json:"phase,omitempty"`
+	Status          string    `json:"status"`
+	Error           string    `json:"error,omitempty"`
+	Time            time.Time `json:"time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Subdomains      int       `json:"subdomains"`
+	Resolved        int       `json:"resolved"`
+	// whether the run probed its hosts over HTTP. Alive is only meaningful if it did.
+	Probed       bool                        `json:"probed"`
+	Alive        int                         `json:"alive"`
+	PreviousDate string                      `json:"previous_date,omitempty"`
+	NewHosts     []string                    `json:"new_hosts"`
+	RemovedHosts []string                    `json:"removed_hosts"`
+	Takeovers    []wrutils.TakeoverCandidate `json:"takeover_candidates"`
+	Stages       []wrutils.Stage             `json:"stages,omitempty"`
+	ReportPath   string                      `json:"report_path,omitempty"`
+}
+
+// function to build the event sent when a run ends, from its report
+func RunEvent(report *wrreport.RunReport) Event {
+	event := Event{
+		Type:            EventRun,
+		Program:         report.Program,
+		Date:            report.Date,
+		Status:          report.Run.Status,
+		Time:            time.Now(),
+		DurationSeconds: report.Run.DurationSeconds,
+		Subdomains:      len(report.Records),
+		Resolved:        report.Clusters.Resolved,
+		Probed:          runProbed(report),
+		Alive:           report.Alive(),
+		PreviousDate:    report.PreviousDate,
+		NewHosts:        report.Added,
+		RemovedHosts:    report.Removed,
+		Takeovers:       report.Takeovers,
+		Stages:          report.Run.Stages,
+		ReportPath:      wrutils.RunDirectory(report.Program, report.Date) + "report.html",
+	}
+	if event.PreviousDate == "" {
+		// the first run of a program has nothing to compare against, so it doesn't report every host as new
+		event.NewHosts, event.RemovedHosts = []string{}, []string{}
+	}
+	if event.Takeovers == nil {
+		event.Takeovers = []wrutils.TakeoverCandidate{}
+	}
+	return event
+}
+
+// returns whether a run was made with -probe, from the options in its manifest. a run whose manifest has no options was
+// probed if any of its hosts has an HTTP record.
+func runProbed(report *wrreport.RunReport) bool {
+	var options struct {
+		Probe bool `json:"probe"`
+	}
+	if len(report.Run.Options) > 0 && json.Unmarshal(report.Run.Options, &options) == nil {
+		return options.Probe
+	}
+	return report.Probed()
+}
+
+// function to build the event sent when a run ends without a report, from its manifest: when it failed or was cancelled,
+// or its report couldn't be built
+func RunInfoEvent(run wrutils.RunInfo) Event {
+	return Event{
+		Type:            EventRun,
+		Program:         run.Program,
+		Date:            run.Date,
+		Status:          run.Status,
+		Error:           run.Error,
+		Time:            time.Now(),
+		DurationSeconds: run.DurationSeconds,
+		Subdomains:      run.Subdomains,
+		NewHosts:        []string{},
+		RemovedHosts:    []string{},
+		Takeovers:       []wrutils.TakeoverCandidate{},
+		Stages:          run.Stages,
+	}
+}
+
+// function to build the event sent when a phase of a run ends. count is the number of subdomains the phase produced.
+func PhaseEvent(run wrutils.RunInfo, phase string, count int) Event {
+	return Event{
+		Type:            EventPhase,
+		Program:         run.Program,
+		Date:            run.Date,
+		Phase:           phase,
+		Status:          run.Status,
+		Time:            time.Now(),
+		DurationSeconds: time.Since(run.Started).Seconds(),
+		Subdomains:      count,
+		NewHosts:        []string{},
+		RemovedHosts:    []string{},
+		Takeovers:       []wrutils.TakeoverCandidate{},
+		Stages:          run.Stages,
+	}
+}
+
+// returns an example run event, for checking notifiers are set up without running anything
+func ExampleEvent() Event {
+	return Event{
+		Type:            EventRun,
+		Program:         "example",
+		Date:            time.Now().Format(wrutils.RunDateFormat),
+		Status:          wrutils.RunStatusComplete,
+		Time:            time.Now(),
+		DurationSeconds: 5400,
+		Subdomains:      120,
+		Resolved:        95,
+		Probed:          true,
+		Alive:           60,
+		PreviousDate:    time.Now().AddDate(0, 0, -7).Format(wrutils.RunDateFormat),
+		NewHosts:        []string{"api.example.com", "staging.example.com"},
+		RemovedHosts:    []string{"old.example.com"},
+		Takeovers:       []wrutils.TakeoverCandidate{{Host: "docs.example.com", CNAME: "example.github.io", Service: "GitHub Pages", Confidence: wrutils.TakeoverHigh, Reason: "CNAME target doesn't resolve"}},
+	}
+}
+
+// returns the title of an event, e.g. "WebRecon: Starbucks run 10-19-2026 complete"
+func (e Event) Title() string {
+	if e.Type == EventPhase {
+		return fmt.Sprintf("WebRecon: %s run %s finished %s", e.Program, e.Date, e.Phase)
+	}
+	return fmt.Sprintf("WebRecon: %s run %s %s", e.Program, e.Date, e.Status)
+}
+
+// returns the run time of the event as a duration
+func (e Event) Duration() time.Duration {
+	return (time.Duration(e.DurationSeconds * float64(time.Second))).Round(time.Second)
+}
+
+// returns at most maxListedHosts hosts, and how many were left out
+func listedHosts(hosts []string) ([]string, int) {
+	if len(hosts) > maxListedHosts {
+		return hosts[:maxListedHosts], len(hosts) - maxListedHosts
+	}
+	return hosts, 0
+}
+
+// Notifier is something runs can be reported to.
+type Notifier interface {
+	// the name printed when the notifier fails
+	Name() string
+	// whether the notifier wants an event
+	Wants(event Event) bool
+	Notify(event Event) error
+}
+
+// Config is the contents of notify.json.
+type Config struct {
+	Webhooks []WebhookConfig `json:"webhooks"`
+	SMTP     []SMTPConfig    `json:"smtp"`
+}
+
+// returns whether a notifier configured with programs and phases wants an event
+func wants(programs []string, phases bool, event Event) bool {
+	if len(programs) > 0 && !wrutils.SliceContainsString(programs, event.Program) {
+		return false
+	}
+	return event.Type == EventRun || phases
+}
+
+// function to read the notifiers configured in a notify.json. returns no notifiers if the file doesn't exist.
+func LoadNotifiers(path string) ([]Notifier, error) {
+	var config Config
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("could not parse %s - %v", path, err)
+	}
+	var notifiers []Notifier
+	for index, webhook := range config.Webhooks {
+		notifier, err := NewWebhookNotifier(webhook)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %d in %s - %v", index+1, path, err)
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	for index, email := range config.SMTP {
+		notifier, err := NewSMTPNotifier(email)
+		if err != nil {
+			return nil, fmt.Errorf("smtp %d in %s - %v", index+1, path, err)
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers, nil
+}
+
+// Dispatcher sends events to notifiers in the background, so slow or retrying notifiers don't hold up a run.
+type Dispatcher struct {
+	notifiers []Notifier
+	wg        sync.WaitGroup
+}
+
+// returns a dispatcher sending events to notifiers
+func NewDispatcher(notifiers []Notifier) *Dispatcher {
+	return &Dispatcher{notifiers: notifiers}
+}
+
+// returns the number of notifiers events are sent to
+func (d *Dispatcher) Len() int {
+	return len(d.notifiers)
+}
+
+// function to send an event to every notifier that wants it. failures are printed, not returned.
+func (d *Dispatcher) Send(event Event) {
+	for _, notifier := range d.notifiers {
+		if !notifier.Wants(event) {
+			continue
+		}
+		d.wg.Add(1)
+		go func(notifier Notifier) {
+			defer d.wg.Done()
+			if err := notifier.Notify(event); err != nil {
+				slog.Error("Could not notify", "notifier", notifier.Name(), "program", event.Program, "event", event.Type, "error", err)
+			}
+		}(notifier)
+	}
+}
+
+// function to wait for every event sent to be delivered (or to fail)
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
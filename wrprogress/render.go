@@ -0,0 +1,159 @@
+package wrprogress
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrlog"
+)
+
+// how often the terminal display is redrawn, and how often progress is logged otherwise
+var (
+	RedrawInterval = 500 * time.Millisecond
+	LogInterval    = 30 * time.Second
+)
+
+// function to show the progress until the returned function is called: as a live display if stdout is a terminal, and
+// as log lines otherwise
+func (p *Progress) Show() func() {
+	if terminalWidth(os.Stdout) > 0 {
+		return p.ShowTerminal()
+	}
+	return p.ShowLog()
+}
+
+// function to log the progress of every running stage each LogInterval, until the returned function is called
+func (p *Progress) ShowLog() func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		out := wrlog.NewConsoleOutput()
+		ticker := time.NewTicker(LogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			for _, status := range p.Snapshot() {
+				if status.Running {
+					out.Writeln("\t<comment>PROGRESS - " + p.program + " " + status.String() + "</comment>")
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+// display is the status display at the bottom of the terminal. while it is shown it is the console writer, and prints what
+// is written to it above itself.
+type display struct {
+	progress *Progress
+	terminal *os.File
+	mutex    sync.Mutex
+	// the number of lines the display takes up
+	lines int
+	// the end of the output which hasn't had its newline written yet
+	partial []byte
+}
+
+// erases the display, leaving the cursor where it started
+func (d *display) clear() {
+	if d.lines > 0 {
+		fmt.Fprintf(d.terminal, "\x1b[%dF\x1b[J", d.lines)
+		d.lines = 0
+	}
+}
+
+// draws the display below the cursor. lines are cut to the terminal's width, as a wrapped line would throw off clear.
+func (d *display) draw() {
+	width := terminalWidth(d.terminal)
+	lines := []string{fmt.Sprintf("%s - %v elapsed", d.progress.program, time.Since(d.progress.started).Round(time.Second))}
+	for _, status := range d.progress.Snapshot() {
+		lines = append(lines, "  "+status.String())
+	}
+	for index, line := range lines {
+		if runes := []rune(line); width > 1 && len(runes) >= width {
+			line = string(runes[:width-1])
+		}
+		if index == 0 {
+			line = "\x1b[1m" + line + "\x1b[0m"
+		}
+		fmt.Fprintln(d.terminal, line)
+	}
+	d.lines = len(lines)
+}
+
+func (d *display) redraw() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.clear()
+	d.draw()
+}
+
+// function to print the output written to the display above it. output is printed a line at a time, so the end of a line
+// is held until its newline is written.
+func (d *display) Write(data []byte) (int, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.partial = append(d.partial, data...)
+	end := bytes.LastIndexByte(d.partial, '\n')
+	if end < 0 {
+		return len(data), nil
+	}
+	d.clear()
+	d.terminal.Write(d.partial[:end+1])
+	d.partial = append([]byte{}, d.partial[end+1:]...)
+	d.draw()
+	return len(data), nil
+}
+
+// function to show the progress as a display at the bottom of the terminal until the returned function is called. while
+// it is shown, the console output is written through the display, so the output of the run scrolls above it.
+func (p *Progress) ShowTerminal() func() {
+	d := &display{progress: p, terminal: os.Stdout}
+	restore := wrlog.SetConsoleWriter(d)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(RedrawInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				d.redraw()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			restore()
+			close(done)
+			wg.Wait()
+			d.mutex.Lock()
+			d.clear()
+			d.terminal.Write(d.partial)
+			d.partial = nil
+			d.mutex.Unlock()
+		})
+	}
+}
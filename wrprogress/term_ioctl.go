@@ -0,0 +1,21 @@
+//go:build linux || darwin
+
+package wrprogress
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// returns the width of the terminal f is, or 0 if it isn't one. terminals which don't report a size are taken to be 100 columns wide.
+func terminalWidth(f *os.File) int {
+	var size struct{ rows, columns, x, y uint16 }
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&size))); errno != 0 {
+		return 0
+	}
+	if size.columns == 0 {
+		return 100
+	}
+	return int(size.columns)
+}
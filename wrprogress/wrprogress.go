@@ -0,0 +1,194 @@
+package wrprogress
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PROGRESS FUNCTIONS
+// each stage of a run reports how much of its input it has processed and how many results it has found. stages which know
+// their input up front (the resolution phases, the PTR sweep, ...) set a total, so their progress is shown with a rate and an
+// ETA; the enumeration tools only report what they find. a run's progress is shown as a live status display in a terminal,
+// and as periodic log lines otherwise (see render.go).
+
+// Stage is the progress of one stage of a run. every method may be called on a nil stage, which tracks nothing, so the
+// tools can be run without a display.
+type Stage struct {
+	name      string
+	started   time.Time
+	total     atomic.Int64
+	processed atomic.Int64
+	found     atomic.Int64
+	// set once the stage ends
+	mutex    sync.Mutex
+	finished time.Time
+	err      error
+}
+
+// function to set the number of items the stage will process
+func (s *Stage) SetTotal(total int) {
+	if s != nil {
+		s.total.Store(int64(total))
+	}
+}
+
+// function to record that n more items were processed
+func (s *Stage) AddProcessed(n int) {
+	if s != nil {
+		s.processed.Add(int64(n))
+	}
+}
+
+// function to set the number of items processed so far, for tools which report it themselves. the count never goes back.
+func (s *Stage) SetProcessed(processed int) {
+	if s == nil {
+		return
+	}
+	for {
+		current := s.processed.Load()
+		if int64(processed) <= current || s.processed.CompareAndSwap(current, int64(processed)) {
+			return
+		}
+	}
+}
+
+// function to record that n more results were found
+func (s *Stage) AddFound(n int) {
+	if s != nil {
+		s.found.Add(int64(n))
+	}
+}
+
+// function to mark the stage as ended. found is the stage's final count, which replaces the live one.
+func (s *Stage) Done(found int, err error) {
+	if s == nil {
+		return
+	}
+	s.found.Store(int64(found))
+	if err == nil {
+		// a tool which doesn't report its progress has still processed everything once it finishes
+		s.SetProcessed(int(s.total.Load()))
+	}
+	s.mutex.Lock()
+	s.finished, s.err = time.Now(), err
+	s.mutex.Unlock()
+}
+
+// StageStatus is a stage's progress at a point in time.
+type StageStatus struct {
+	Name      string    `json:"name"`
+	Started   time.Time `json:"started"`
+	Running   bool      `json:"running"`
+	Error     string    `json:"error,omitempty"`
+	Total     int       `json:"total,omitempty"`
+	Processed int       `json:"processed"`
+	Found     int       `json:"found"`
+	// processed items per second, and the estimated time left, when the stage has a total
+	Rate       float64 `json:"rate"`
+	ETASeconds float64 `json:"eta_seconds,omitempty"`
+	// how long the stage ran, or has been running
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// returns the stage's progress now
+func (s *Stage) Status() StageStatus {
+	s.mutex.Lock()
+	finished, err := s.finished, s.err
+	s.mutex.Unlock()
+	status := StageStatus{
+		Name:      s.name,
+		Started:   s.started,
+		Running:   finished.IsZero(),
+		Total:     int(s.total.Load()),
+		Processed: int(s.processed.Load()),
+		Found:     int(s.found.Load()),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	end := finished
+	if status.Running {
+		end = time.Now()
+	}
+	elapsed := end.Sub(s.started)
+	status.ElapsedSeconds = elapsed.Seconds()
+	// the rate is the throughput observed since the stage started, so the ETA settles as the stage goes on
+	if elapsed >= time.Second {
+		status.Rate = float64(status.Processed) / elapsed.Seconds()
+	}
+	if status.Running && status.Total > 0 && status.Rate > 0 && status.Processed < status.Total {
+		status.ETASeconds = float64(status.Total-status.Processed) / status.Rate
+	}
+	return status
+}
+
+// returns the status as a single line, e.g. "puredns-stage-1  45000/120000 (37.5%)  850/s  ETA 1m28s  1204 found"
+func (s StageStatus) String() string {
+	parts := []string{}
+	if s.Total > 0 {
+		parts = append(parts, fmt.Sprintf("%d/%d (%.1f%%)", s.Processed, s.Total, 100*float64(s.Processed)/float64(s.Total)))
+	} else if s.Processed > 0 {
+		parts = append(parts, fmt.Sprintf("%d processed", s.Processed))
+	}
+	if s.Rate >= 10 {
+		parts = append(parts, fmt.Sprintf("%.0f/s", s.Rate))
+	} else if s.Rate > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f/s", s.Rate))
+	}
+	parts = append(parts, fmt.Sprintf("%d found", s.Found))
+	elapsed := time.Duration(s.ElapsedSeconds * float64(time.Second)).Round(time.Second)
+	switch {
+	case s.Error != "":
+		parts = append(parts, "failed after "+elapsed.String()+" - "+s.Error)
+	case !s.Running:
+		parts = append(parts, "done in "+elapsed.String())
+	case s.ETASeconds > 0:
+		parts = append(parts, elapsed.String()+" elapsed", "ETA "+time.Duration(s.ETASeconds*float64(time.Second)).Round(time.Second).String())
+	default:
+		parts = append(parts, elapsed.String()+" elapsed")
+	}
+	return fmt.Sprintf("%-16s %s", s.Name, strings.Join(parts, "  "))
+}
+
+// Progress is the progress of every stage of a run.
+type Progress struct {
+	program string
+	started time.Time
+	mutex   sync.Mutex
+	stages  []*Stage
+}
+
+// returns the progress of a run of a program
+func New(program_name string) *Progress {
+	return &Progress{program: program_name, started: time.Now()}
+}
+
+// function to start tracking a stage. returns nil, which tracks nothing, if p is nil.
+func (p *Progress) Stage(name string) *Stage {
+	if p == nil {
+		return nil
+	}
+	stage := &Stage{name: name, started: time.Now()}
+	p.mutex.Lock()
+	p.stages = append(p.stages, stage)
+	p.mutex.Unlock()
+	return stage
+}
+
+// returns the status of every stage, in the order they started
+func (p *Progress) Snapshot() []StageStatus {
+	statuses := []StageStatus{}
+	if p == nil {
+		return statuses
+	}
+	p.mutex.Lock()
+	stages := append([]*Stage{}, p.stages...)
+	p.mutex.Unlock()
+	for _, stage := range stages {
+		statuses = append(statuses, stage.Status())
+	}
+	return statuses
+}
@@ -0,0 +1,188 @@
+package wrreport
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// EXPORT FUNCTIONS
+// flat exports of a run report: a CSV of every host, a Markdown summary to paste into tickets and JSON for other tools.
+
+// export formats accepted by WriteExport
+var ExportFormats = []string{"csv", "md", "json"}
+
+// ExportHost is a single host of a run, as written by the CSV and JSON exports.
+type ExportHost struct {
+	Host    string            `json:"host"`
+	New     bool              `json:"new"`
+	Sources []string          `json:"sources,omitempty"`
+	A       []string          `json:"a,omitempty"`
+	AAAA    []string          `json:"aaaa,omitempty"`
+	CNAME   []string          `json:"cname,omitempty"`
+	IPs     []wrutils.IPInfo  `json:"ips,omitempty"`
+	HTTP    *wrutils.HTTPInfo `json:"http,omitempty"`
+}
+
+// RunExport is the JSON export of a run.
+type RunExport struct {
+	Program       string                      `json:"program"`
+	Date          string                      `json:"date"`
+	Status        string                      `json:"status"`
+	PreviousDate  string                      `json:"previous_date,omitempty"`
+	Removed       []string                    `json:"removed,omitempty"`
+	Hosts         []ExportHost                `json:"hosts"`
+	Takeovers     []wrutils.TakeoverCandidate `json:"takeover_candidates"`
+	WildcardZones []string                    `json:"wildcard_zones,omitempty"`
+}
+
+// returns every host of the report with its sources and whether it is new
+func (r *RunReport) Hosts() []ExportHost {
+	hosts := []ExportHost{}
+	for _, record := range r.Records {
+		hosts = append(hosts, ExportHost{Host: record.Host, New: r.IsNew(record.Host), Sources: r.Sources[record.Host], A: record.A, AAAA: record.AAAA, CNAME: record.CNAME, IPs: record.IPs, HTTP: record.HTTP})
+	}
+	return hosts
+}
+
+// function to write a run report in one of ExportFormats
+func WriteExport(report *RunReport, format string, w io.Writer) error {
+	switch format {
+	case "csv":
+		return WriteCSV(report, w)
+	case "md":
+		return WriteMarkdown(report, w)
+	case "json":
+		return WriteJSON(report, w)
+	}
+	return fmt.Errorf("unknown export format %s, expected one of %s", format, strings.Join(ExportFormats, ", "))
+}
+
+// function to write one CSV row per host. multiple values in a column are separated by spaces.
+func WriteCSV(report *RunReport, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"host", "new", "sources", "ips", "cname", "asn", "provider", "http_status", "http_url", "title", "takeover"})
+	takeovers := make(map[string]string)
+	for _, candidate := range report.Takeovers {
+		takeovers[candidate.Host] = candidate.Confidence
+	}
+	for _, host := range report.Hosts() {
+		var asns, providers []string
+		for _, info := range host.IPs {
+			if info.ASN != 0 && !wrutils.SliceContainsString(asns, strconv.Itoa(info.ASN)) {
+				asns = append(asns, strconv.Itoa(info.ASN))
+			}
+			if info.Provider != "" && !wrutils.SliceContainsString(providers, info.Provider) {
+				providers = append(providers, info.Provider)
+			}
+		}
+		status, url, title := "", "", ""
+		if host.HTTP != nil {
+			if host.HTTP.Status != 0 {
+				status = strconv.Itoa(host.HTTP.Status)
+			}
+			url, title = host.HTTP.URL, host.HTTP.Title
+		}
+		writer.Write([]string{
+			host.Host,
+			strconv.FormatBool(host.New),
+			strings.Join(host.Sources, " "),
+			strings.Join(append(append([]string{}, host.A...), host.AAAA...), " "),
+			strings.Join(host.CNAME, " "),
+			strings.Join(asns, " "),
+			strings.Join(providers, " "),
+			status,
+			url,
+			title,
+			takeovers[host.Host],
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// escapes text for a Markdown table cell
+func markdownCell(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "|", "\\|"), "\n", " ")
+}
+
+// function to write a Markdown summary of the run: counts, new subdomains, takeover candidates, live hosts and hosting
+func WriteMarkdown(report *RunReport, w io.Writer) error {
+	var md strings.Builder
+	fmt.Fprintf(&md, "# %s - run %s\n\n", report.Program, report.Date)
+	fmt.Fprintf(&md, "| | |\n|---|---|\n")
+	fmt.Fprintf(&md, "| Status | %s |\n", report.Run.Status)
+	fmt.Fprintf(&md, "| Duration | %s |\n", report.Run.Duration())
+	fmt.Fprintf(&md, "| Domains | %s |\n", markdownCell(strings.Join(report.Domains, ", ")))
+	fmt.Fprintf(&md, "| Subdomains | %d |\n", len(report.Records))
+	fmt.Fprintf(&md, "| With DNS records | %d |\n", report.Clusters.Resolved)
+	if report.Probed() {
+		fmt.Fprintf(&md, "| Answered HTTP | %d |\n", report.Alive())
+	}
+	if report.PreviousDate != "" {
+		fmt.Fprintf(&md, "| New since %s | %d |\n", report.PreviousDate, len(report.Added))
+		fmt.Fprintf(&md, "| Gone since %s | %d |\n", report.PreviousDate, len(report.Removed))
+	}
+	fmt.Fprintf(&md, "| Takeover candidates | %d |\n", len(report.Takeovers))
+	fmt.Fprintf(&md, "| Wildcard zones | %d |\n", len(report.WildcardZones))
+
+	if report.PreviousDate != "" && len(report.Added) > 0 {
+		fmt.Fprintf(&md, "\n## New subdomains since %s\n\n", report.PreviousDate)
+		for _, host := range report.Added {
+			fmt.Fprintf(&md, "- `%s`\n", host)
+		}
+	}
+
+	if len(report.Takeovers) > 0 {
+		fmt.Fprintf(&md, "\n## Takeover candidates\n\n| Host | CNAME | Service | Confidence | Reason |\n|---|---|---|---|---|\n")
+		for _, candidate := range report.Takeovers {
+			fmt.Fprintf(&md, "| `%s` | `%s` | %s | %s | %s |\n", candidate.Host, candidate.CNAME, candidate.Service, candidate.Confidence, markdownCell(candidate.Reason))
+		}
+	}
+
+	if report.Alive() > 0 {
+		fmt.Fprintf(&md, "\n## Live hosts\n\n| Host | Status | Title | Server |\n|---|---|---|---|\n")
+		for _, record := range report.Records {
+			if record.HTTP == nil || record.HTTP.Status == 0 {
+				continue
+			}
+			status := strconv.Itoa(record.HTTP.Status)
+			if record.HTTP.Location != "" {
+				status += " → " + record.HTTP.Location
+			}
+			fmt.Fprintf(&md, "| [%s](%s) | %s | %s | %s |\n", record.Host, record.HTTP.URL, markdownCell(status), markdownCell(record.HTTP.Title), markdownCell(record.HTTP.Server))
+		}
+	}
+
+	if len(report.ASNs) > 0 {
+		fmt.Fprintf(&md, "\n## Hosting\n\n| ASN | Organisation | Provider | Hosts |\n|---|---|---|---|\n")
+		for _, asn := range report.ASNs {
+			fmt.Fprintf(&md, "| AS%d | %s | %s | %d |\n", asn.ASN, markdownCell(asn.Org), asn.Provider, asn.Hosts)
+		}
+	}
+
+	_, err := io.WriteString(w, md.String())
+	return err
+}
+
+// function to write the run's hosts, takeover candidates and wildcard zones as JSON
+func WriteJSON(report *RunReport, w io.Writer) error {
+	export := RunExport{
+		Program:       report.Program,
+		Date:          report.Date,
+		Status:        report.Run.Status,
+		PreviousDate:  report.PreviousDate,
+		Removed:       report.Removed,
+		Hosts:         report.Hosts(),
+		Takeovers:     report.Takeovers,
+		WildcardZones: report.WildcardZones,
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
@@ -0,0 +1,176 @@
+package wrreport
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// RUN REPORT FUNCTIONS
+// a RunReport gathers everything stored in a run directory (manifest.json, dns_records.json, clusters, takeover candidates, ...)
+// so it can be rendered as a self-contained HTML page, shared with people who don't want to read .out files.
+
+//go:embed templates
+var templates embed.FS
+
+// RunReport is the summary of a single run.
+type RunReport struct {
+	Program       string
+	Date          string
+	Generated     time.Time
+	Run           wrutils.RunInfo
+	Domains       []string
+	ScopeRules    []string
+	IPRanges      []string
+	PreviousDate  string
+	Added         []string
+	Removed       []string
+	Records       []wrutils.HostRecord
+	Sources       map[string][]string
+	Clusters      wrutils.ClusterReport
+	Takeovers     []wrutils.TakeoverCandidate
+	WildcardZones []string
+	ASNs          []wrutils.ASNSummary
+	HostKinds     map[string]int
+	// the hosts in Added, to look them up
+	added map[string]bool
+}
+
+// returns whether host was first found by this run
+func (r *RunReport) IsNew(host string) bool {
+	return r.PreviousDate != "" && r.added[host]
+}
+
+// returns whether the run probed its hosts over HTTP. runs made without -probe have no HTTP columns in the report.
+func (r *RunReport) Probed() bool {
+	for _, record := range r.Records {
+		if record.HTTP != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the number of resolved hosts that answered the HTTP probe
+func (r *RunReport) Alive() int {
+	alive := 0
+	for _, record := range r.Records {
+		if record.HTTP != nil && record.HTTP.Status != 0 {
+			alive += 1
+		}
+	}
+	return alive
+}
+
+// function to gather the report of a run from the files in its directory
+func BuildRunReport(program_name string, date string) (*RunReport, error) {
+	run_directory := wrutils.RunDirectory(program_name, date)
+	if _, err := os.Stat(run_directory); err != nil {
+		return nil, fmt.Errorf("run %s of %s does not exist", date, program_name)
+	}
+	report := &RunReport{Program: program_name, Date: date, Generated: time.Now(), Run: wrutils.ReadRunInfo(program_name, date)}
+
+	report.Domains = wrutils.WordlistToArray(run_directory + "domains.txt")
+	scope_lines := []string{}
+	if _, err := os.Stat(wrutils.ProgramsDirectory + program_name + "/recon-data/scope.txt"); err == nil {
+		scope_lines = wrutils.WordlistToArray(wrutils.ProgramsDirectory + program_name + "/recon-data/scope.txt")
+	}
+	if scope, err := wrutils.ParseScopeRules(scope_lines, report.Domains); err == nil {
+		report.ScopeRules = scope.Rules()
+	}
+	if ip_ranges, err := wrutils.ReadIPRanges(program_name); err == nil {
+		for _, ip_range := range ip_ranges {
+			report.IPRanges = append(report.IPRanges, ip_range.String())
+		}
+	}
+
+	if previous, ok := wrutils.PreviousRun(program_name, date); ok {
+		report.PreviousDate = previous.Date
+		report.Added, report.Removed = wrutils.DiffRuns(program_name, previous.Date, date)
+		report.added = make(map[string]bool, len(report.Added))
+		for _, host := range report.Added {
+			report.added[host] = true
+		}
+	}
+
+	records := wrutils.ReadRunHosts(program_name, date)
+	report.Records = records
+	report.Sources = wrutils.HostSources(program_name, date)
+	report.Clusters = wrutils.ClusterDNSRecords(records)
+	report.Takeovers = wrutils.ReadTakeoverCandidates(program_name, date)
+	if report.Takeovers == nil {
+		report.Takeovers = wrutils.FindTakeoverCandidates(records)
+	}
+	report.WildcardZones = wrutils.ReadWildcardZones(program_name, date)
+	report.ASNs = wrutils.SummarizeASNs(records)
+	report.HostKinds = wrutils.SummarizeHostKinds(records)
+	return report, nil
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"seconds": func(seconds float64) string {
+		return (time.Duration(seconds * float64(time.Second))).Round(time.Second).String()
+	},
+	"datetime": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05")
+	},
+	"kinds": func(kinds map[string]int) []string {
+		var sorted []string
+		for kind := range kinds {
+			sorted = append(sorted, kind)
+		}
+		sort.Slice(sorted, func(a, b int) bool { return kinds[sorted[a]] > kinds[sorted[b]] })
+		return sorted
+	},
+	"hostkind": wrutils.HostKind,
+	"asns": func(record wrutils.HostRecord) string {
+		var asns []string
+		for _, info := range record.IPs {
+			if info.ASN == 0 {
+				continue
+			}
+			asn := fmt.Sprintf("AS%d %s", info.ASN, info.Org)
+			if info.Provider != "" {
+				asn += " [" + info.Provider + "]"
+			}
+			if !wrutils.SliceContainsString(asns, asn) {
+				asns = append(asns, asn)
+			}
+		}
+		return strings.Join(asns, ", ")
+	},
+}
+
+// function to render a run report as a self-contained HTML page
+func WriteHTML(report *RunReport, w io.Writer) error {
+	t, err := template.New("report.html").Funcs(templateFuncs).ParseFS(templates, "templates/report.html")
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, report)
+}
+
+// function to write a run's report.html into its run directory. returns the path written.
+func WriteHTMLReport(report *RunReport) (string, error) {
+	path := wrutils.RunDirectory(report.Program, report.Date) + "report.html"
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := WriteHTML(report, file); err != nil {
+		file.Close()
+		return "", err
+	}
+	return path, file.Close()
+}
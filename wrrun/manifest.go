@@ -0,0 +1,81 @@
+package wrrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrtools"
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// MANIFEST FUNCTIONS
+// besides how a run went, its manifest.json records what it was run with: the WebRecon version, the effective options, a
+// hash of every file it read (the normalized domains, the scope, the resolvers, ...) and the path and version of every
+// tool it ran. two runs with the same inputs and tools can be compared, and a run can be reproduced from its manifest.
+
+// returns the tools a run with opts runs: the enumeration tools it was asked for, then the ones every run uses
+func RunTools(opts Options) []string {
+	var tools []string
+	for _, tool := range opts.Tools {
+		// the subdomain generator is built in
+		if tool != "sub-generator" {
+			tools = append(tools, tool)
+		}
+	}
+	return append(tools, "puredns", "dnsgen")
+}
+
+// returns the files a run of a program with opts reads, with their hashes. optional files which don't exist are left out,
+// and files which can't be read are returned in the error.
+func RunInputs(program_name string, date string, opts Options) ([]wrutils.InputFile, error) {
+	type input struct {
+		name     string
+		path     string
+		optional bool
+	}
+	recon_data := wrutils.ReconDataDirectory(program_name)
+	files := []input{
+		// the domains as they were normalized for the run, rather than domains.txt as it was written
+		{"domains", wrutils.RunDirectory(program_name, date) + "domains.txt", false},
+		{"scope", recon_data + "scope.txt", true},
+		{"ip-ranges", recon_data + "ips.txt", true},
+		{"resolvers", wrtools.ResolversFile, false},
+		{"asn-database", wrutils.DefaultASNDatabase, true},
+	}
+	if wrutils.SliceContainsString(opts.Tools, "sub-generator") {
+		files = append(files, input{"wordlist", wrtools.SubdomainWordlist, false})
+	}
+	var inputs []wrutils.InputFile
+	var errs []error
+	for _, file := range files {
+		described, err := wrutils.ReadInputFile(file.name, file.path)
+		if errors.Is(err, fs.ErrNotExist) && file.optional {
+			continue
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		inputs = append(inputs, described)
+	}
+	return inputs, errors.Join(errs...)
+}
+
+// function to record what a run is started with in run_info. a file which can't be read is logged rather than failing
+// the run, as the tool which reads it reports it more usefully.
+func DescribeRun(ctx context.Context, run_info *wrutils.RunInfo, opts Options) {
+	logger := wrlog.FromContext(ctx)
+	run_info.Version = wrutils.BuildVersion()
+	run_info.Options, _ = json.Marshal(opts)
+	inputs, err := RunInputs(run_info.Program, run_info.Date, opts)
+	if err != nil {
+		logger.Warn("Could not record the run's inputs in its manifest", "error", err)
+	}
+	run_info.Inputs = inputs
+	run_info.Tools = wrtools.ToolVersions(ctx, RunTools(opts))
+	for _, tool := range run_info.Tools {
+		logger.Debug("Tool version", "tool", tool.Name, "path", tool.Path, "version", tool.Version, "error", tool.Error)
+	}
+}
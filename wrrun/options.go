@@ -0,0 +1,221 @@
+package wrrun
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrtools"
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// RUN OPTIONS
+// the options a run is started with. they are parsed from the same flags whether they come from the command line
+// (./WebRecon [flags] <name>) or a line of the daemon's schedule.
+
+// the enumeration tools, and the default of -tools
+var Tools = []string{"subfinder", "amass", "sub-generator"}
+
+// what happens when a run's query budget is exhausted: the stages resolve only the candidates which fit in it, or the run stops
+const (
+	BudgetTruncate = "truncate"
+	BudgetAbort    = "abort"
+)
+
+// the stages -stage-timeout can limit. the subdomain generator only writes out the wordlist, so it isn't one of them.
+var TimeoutStages = []string{"amass", "subfinder", "ptr-sweep", "puredns-stage-1", "dnsgen", "dnsgen-puredns", "http-probe"}
+
+// ErrUsage is returned when the arguments don't name exactly one program.
+var ErrUsage = errors.New("expected a single program name")
+
+// Options holds the flags enumeration was started with
+type Options struct {
+	Program      string   `json:"program"`
+	AmassTimeout uint     `json:"amass_timeout"`
+	Tools        []string `json:"tools"`
+	Wildcard     bool     `json:"wildcard"`
+	PTRMax       int      `json:"ptr_max"`
+	Probe        bool     `json:"probe"`
+	// DNS query limits: queries per second in total and to each resolver, and the run's query budget. 0 is no limit.
+	QPS             int    `json:"qps"`
+	ResolverQPS     int    `json:"resolver_qps"`
+	QueryBudget     int    `json:"query_budget"`
+	BudgetExhausted string `json:"budget_exhausted"`
+	// how long each stage may run, and how long any stage may go without output, before it is stopped. 0 is no limit.
+	StageTimeouts StageTimeouts `json:"stage_timeouts,omitempty"`
+	StallTimeout  Duration      `json:"stall_timeout"`
+	// the address the resolution rounds are served to workers at, or "" to resolve on this machine only, and the number of
+	// candidates in each chunk they are handed
+	Distribute string `json:"distribute,omitempty"`
+	ChunkSize  int    `json:"chunk_size"`
+	// the certificate and key to serve the workers HTTPS with, or "" to serve HTTP
+	DistributeCert string `json:"distribute_cert,omitempty"`
+	DistributeKey  string `json:"distribute_key,omitempty"`
+	// the most memory, in MiB, combining and deduplicating the run's intermediate files may hold. larger files are sorted on disk.
+	SortMemory int `json:"sort_memory"`
+}
+
+// Duration is a time.Duration written in JSON as a string, e.g. "1h30m0s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("durations are strings like \"30m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// StageTimeouts are the longest each stage may run, by stage name. as a flag it is a comma-separated list of
+// <stage>=<duration> pairs, e.g. subfinder=30m,puredns-stage-1=2h.
+type StageTimeouts map[string]Duration
+
+func (t StageTimeouts) String() string {
+	var pairs []string
+	for name, timeout := range t {
+		pairs = append(pairs, name+"="+time.Duration(timeout).String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (t *StageTimeouts) Set(value string) error {
+	if *t == nil {
+		*t = make(StageTimeouts)
+	}
+	for _, pair := range strings.Split(value, ",") {
+		name, timeout, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return fmt.Errorf("expected <stage>=<duration>, got %q", pair)
+		}
+		parsed, err := time.ParseDuration(timeout)
+		if err != nil {
+			return err
+		}
+		(*t)[name] = Duration(parsed)
+	}
+	return nil
+}
+
+// returns the options a program is run with when no flags are given
+func DefaultOptions(program_name string) Options {
+	return Options{Program: program_name, AmassTimeout: 45, Tools: append([]string{}, Tools...), PTRMax: 65536, BudgetExhausted: BudgetTruncate, ChunkSize: 50000, SortMemory: 512}
+}
+
+// function to parse run flags and the program name from args, defining the flags on flags
+func ParseOptions(flags *flag.FlagSet, args []string) (Options, error) {
+	defaults := DefaultOptions("")
+	atimeout := flags.Uint("atimeout", defaults.AmassTimeout, "Max timeout to use for Amass")
+	tools := flags.String("tools", strings.Join(defaults.Tools, ","), "Comma-separated list of enum tools (default subfinder,amass,sub-generator)")
+	wildcard := flags.Bool("wildcard", defaults.Wildcard, "Whether or not to run PureDNS with wildcard filtering on")
+	ptrmax := flags.Int("ptr-max", defaults.PTRMax, "Maximum number of addresses from ips.txt to sweep with PTR lookups")
+	probe := flags.Bool("probe", defaults.Probe, "Whether or not to probe the resolved subdomains over HTTP(S), which sends requests to every one of them")
+	qps := flags.Int("qps", defaults.QPS, "Maximum DNS queries per second across all resolvers (0 for no limit)")
+	resolver_qps := flags.Int("resolver-qps", defaults.ResolverQPS, "Maximum DNS queries per second to each resolver (0 for no limit). puredns is limited to this times the number of resolvers in total, not for each resolver")
+	query_budget := flags.Int("query-budget", defaults.QueryBudget, "Maximum DNS queries for the whole run (0 for no limit)")
+	budget_exhausted := flags.String("budget-exhausted", defaults.BudgetExhausted, "What to do when the query budget runs out: truncate (resolve what fits) or abort")
+	var stage_timeouts StageTimeouts
+	flags.Var(&stage_timeouts, "stage-timeout", "Maximum run time of stages, e.g. subfinder=30m,puredns-stage-1=2h. A stage which runs out of time is stopped and keeps its partial results")
+	stall_timeout := flags.Duration("stall-timeout", time.Duration(defaults.StallTimeout), "Stop any stage which finds or processes nothing for this long, keeping its partial results (0 for no limit)")
+	distribute := flags.String("distribute", defaults.Distribute, "Address to serve the resolution rounds to workers at, e.g. 0.0.0.0:8090 (./WebRecon worker)")
+	chunk_size := flags.Int("chunk-size", defaults.ChunkSize, "Number of candidates in each chunk handed to a worker")
+	distribute_cert := flags.String("distribute-cert", defaults.DistributeCert, "PEM certificate to serve the workers HTTPS with (with -distribute-key)")
+	distribute_key := flags.String("distribute-key", defaults.DistributeKey, "PEM private key of -distribute-cert")
+	sort_memory := flags.Int("sort-memory", defaults.SortMemory, "Maximum memory in MiB for combining and deduplicating intermediate files; larger files are sorted on disk")
+	if err := flags.Parse(args); err != nil {
+		return Options{}, err
+	}
+	if len(flags.Args()) != 1 {
+		return Options{}, ErrUsage
+	}
+	opts := Options{Program: flags.Args()[0], AmassTimeout: *atimeout, Tools: strings.Split(*tools, ","), Wildcard: *wildcard, PTRMax: *ptrmax, Probe: *probe,
+		QPS: *qps, ResolverQPS: *resolver_qps, QueryBudget: *query_budget, BudgetExhausted: *budget_exhausted,
+		StageTimeouts: stage_timeouts, StallTimeout: Duration(*stall_timeout), Distribute: *distribute, ChunkSize: *chunk_size,
+		DistributeCert: *distribute_cert, DistributeKey: *distribute_key, SortMemory: *sort_memory}
+	return opts, opts.Validate()
+}
+
+// function to check the options can be run: the program exists and the tools are known
+func (o Options) Validate() error {
+	if !wrutils.ValidProgramName(o.Program) {
+		return fmt.Errorf("invalid program name %s", o.Program)
+	}
+	if !wrutils.ProgramExists(o.Program) {
+		return fmt.Errorf("program %s does not exist. Create it with ./WebRecon init %s", o.Program, o.Program)
+	}
+	if len(o.Tools) == 0 || len(o.Tools) > len(Tools) {
+		return fmt.Errorf("too many or no tools in list supplied to -tools flags")
+	}
+	for _, tool := range o.Tools {
+		if !wrutils.SliceContainsString(Tools, tool) {
+			return fmt.Errorf("invalid tool %s supplied", tool)
+		}
+	}
+	if o.PTRMax < 0 {
+		return fmt.Errorf("-ptr-max must not be negative")
+	}
+	if o.QPS < 0 || o.ResolverQPS < 0 || o.QueryBudget < 0 {
+		return fmt.Errorf("-qps, -resolver-qps and -query-budget must not be negative")
+	}
+	if o.BudgetExhausted != BudgetTruncate && o.BudgetExhausted != BudgetAbort {
+		return fmt.Errorf("-budget-exhausted must be %s or %s", BudgetTruncate, BudgetAbort)
+	}
+	for name, timeout := range o.StageTimeouts {
+		if !wrutils.SliceContainsString(TimeoutStages, name) {
+			return fmt.Errorf("invalid stage %s supplied to -stage-timeout, expected one of %s", name, strings.Join(TimeoutStages, ","))
+		}
+		if timeout < 0 {
+			return fmt.Errorf("-stage-timeout for %s must not be negative", name)
+		}
+	}
+	if o.StallTimeout < 0 {
+		return fmt.Errorf("-stall-timeout must not be negative")
+	}
+	if o.Distribute != "" {
+		if _, _, err := net.SplitHostPort(o.Distribute); err != nil {
+			return fmt.Errorf("invalid -distribute address %s, expected host:port", o.Distribute)
+		}
+		if o.ChunkSize < 1 {
+			return fmt.Errorf("-chunk-size must be at least 1")
+		}
+	}
+	if (o.DistributeCert == "") != (o.DistributeKey == "") {
+		return fmt.Errorf("-distribute-cert and -distribute-key must be given together")
+	}
+	if o.DistributeCert != "" && o.Distribute == "" {
+		return fmt.Errorf("-distribute-cert and -distribute-key need -distribute")
+	}
+	if o.SortMemory < 1 {
+		return fmt.Errorf("-sort-memory must be at least 1 (MiB)")
+	}
+	return nil
+}
+
+// returns the DNS query limits of a run with the options
+func (o Options) Limits() *wrtools.Limits {
+	return wrtools.NewLimits(o.QPS, o.ResolverQPS, o.QueryBudget, o.BudgetExhausted == BudgetAbort)
+}
+
+// returns the most memory, in bytes, combining and deduplicating the intermediate files of a run with the options may hold
+func (o Options) SortMemoryBytes() int64 {
+	return int64(o.SortMemory) << 20
+}
+
+// returns the run time and silence limits of a stage of a run with the options
+func (o Options) StageLimits(name string) StageLimits {
+	return StageLimits{Timeout: time.Duration(o.StageTimeouts[name]), Stall: time.Duration(o.StallTimeout)}
+}
@@ -0,0 +1,296 @@
+package wrrun
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrtools"
+	"github.com/sammooredev/WebRecon/wrutils"
+
+	"github.com/DrSmithFr/go-console/pkg/formatter"
+)
+
+// PLAN FUNCTIONS
+// ./WebRecon -dry-run <name> prints what a run would do without running anything: its stages in order with the tools'
+// command lines, how many names each stage would produce, how many DNS queries the run would send and how long they take
+// at -qps and -resolver-qps, whether they fit in -query-budget, and how much disk space the run directory would take. the
+// volumes of the sub-generator and the PTR sweep follow from the wordlist, the domains, the scope and ips.txt; those of
+// the enumeration tools, the resolution rounds and dnsgen can't be known up front, so they are taken from the program's
+// last complete run when it has one. without one, dnsgen's permutations are estimated from its wordlist and the names
+// resolved before it, when those are known.
+
+// the number of DNS queries above which the plan asks for a second look
+const largeQueryVolume = 10000000
+
+// the bytes written for each resolved subdomain besides its name: its records in the massdns output, dns_records.json and
+// the reports
+const resolvedRecordBytes = 600
+
+// PlannedStage is a stage of a planned run.
+type PlannedStage struct {
+	Name string
+	// the tool's command line, or "" for the stages WebRecon runs itself
+	Command string
+	// the names the stage would produce (-1 if that can't be estimated), what they are, and how the number was arrived at
+	Output int
+	Unit   string
+	Basis  string
+	// the DNS queries the stage would send
+	Queries int
+}
+
+// Plan is what a run would do.
+type Plan struct {
+	Options Options
+	Date    string
+	Stages  []PlannedStage
+	// the DNS queries the run would send. a lower bound when a stage's volume couldn't be estimated.
+	Queries  int
+	Complete bool
+	// the permutations dnsgen would make of each resolved name, when the resolved names couldn't be estimated. each is
+	// a query on top of Queries.
+	PermutationsPerName int
+	// the space the run directory would take
+	DiskBytes int64
+}
+
+// returns the program's latest complete run, and whether it has one
+func lastCompleteRun(program_name string) (wrutils.RunInfo, bool) {
+	runs := wrutils.ListRuns(program_name)
+	for index := len(runs) - 1; index >= 0; index-- {
+		if runs[index].Status == wrutils.RunStatusComplete {
+			return runs[index], true
+		}
+	}
+	return wrutils.RunInfo{}, false
+}
+
+// returns the number of lines in a wordlist, their average length, and how many of the names the sub-generator makes of
+// them under domains are in scope
+func wordlistStats(path string, domains []string, scope *wrutils.Scope) (int, int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer file.Close()
+	lines, length, in_scope := 0, 0, 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines += 1
+		length += len(scanner.Text())
+		for _, domain := range domains {
+			if scope.InScope(scanner.Text() + "." + domain) {
+				in_scope += 1
+			}
+		}
+	}
+	if lines == 0 {
+		return 0, 0, 0, scanner.Err()
+	}
+	return lines, length / lines, in_scope, scanner.Err()
+}
+
+// function to plan a run of a program with opts, printing its domains and IP ranges as a run would. nothing is written.
+func BuildPlan(opts Options) (Plan, error) {
+	plan := Plan{Options: opts, Date: time.Now().Format(wrutils.RunDateFormat), Complete: true}
+	if err := opts.Validate(); err != nil {
+		return plan, err
+	}
+	arg1, date := opts.Program, plan.Date
+	domains, err := CheckDomainsList(arg1)
+	if err != nil {
+		return plan, err
+	}
+	scope, err := wrutils.ReadScope(arg1, domains)
+	if err != nil {
+		return plan, err
+	}
+	ip_ranges, err := CheckIPRanges(arg1, opts.PTRMax)
+	if err != nil {
+		return plan, err
+	}
+	limits := opts.Limits()
+
+	previous, has_previous := lastCompleteRun(arg1)
+	// returns the count of a stage in the last complete run, and where it came from
+	estimate := func(name string) (int, string) {
+		if has_previous {
+			if stage, ok := previous.Stage(name); ok {
+				return stage.Count, "as in the run of " + previous.Date
+			}
+		}
+		return -1, "unknown until the program has a complete run with this stage"
+	}
+	add := func(stage PlannedStage) {
+		if stage.Output < 0 {
+			plan.Complete = false
+		}
+		plan.Queries += stage.Queries
+		plan.Stages = append(plan.Stages, stage)
+	}
+	// a subdomain is about a label longer than the domains it was found under
+	domain_length := 0
+	for _, domain := range domains {
+		domain_length += len(domain)
+	}
+	domain_length /= len(domains)
+	name_bytes := int64(domain_length + len(".www") + 1)
+
+	// phase 1: every name it produces is written to its stage's file and the combined list, then resolved
+	enumerated := 0
+	if wrutils.SliceContainsString(opts.Tools, "sub-generator") {
+		words, word_length, generated, err := wordlistStats(wrtools.SubdomainWordlist, domains, scope)
+		if err != nil {
+			return plan, err
+		}
+		basis := fmt.Sprintf("%s words x %d domains", formatCount(words), len(domains))
+		if out_of_scope := words*len(domains) - generated; out_of_scope > 0 {
+			basis += ", less " + formatCount(out_of_scope) + " out of scope names"
+		}
+		add(PlannedStage{Name: "sub-generator", Output: generated, Unit: "candidates", Basis: basis})
+		plan.DiskBytes += 2 * int64(generated) * int64(word_length+1+domain_length+1)
+		enumerated += generated
+	}
+	blacklist := ""
+	if len(scope.ExcludedDomains()) > 0 {
+		blacklist = wrutils.ScopeBlacklistPath(arg1, date)
+	}
+	tools := []struct {
+		name    string
+		command string
+	}{
+		{"amass", wrtools.AmassCommand(arg1, date, int(opts.AmassTimeout), blacklist, scope.HasRegexExclusions())},
+		{"subfinder", wrtools.SubfinderCommand(arg1, date)},
+	}
+	for _, tool := range tools {
+		if !wrutils.SliceContainsString(opts.Tools, tool.name) {
+			continue
+		}
+		count, basis := estimate(tool.name)
+		add(PlannedStage{Name: tool.name, Command: tool.command, Output: count, Unit: "subdomains", Basis: basis})
+		if count > 0 {
+			plan.DiskBytes += 2 * int64(count) * name_bytes
+			enumerated += count
+		}
+	}
+	if len(ip_ranges) > 0 {
+		addresses := wrutils.CountAddresses(ip_ranges, opts.PTRMax)
+		count, basis := estimate("ptr-sweep")
+		add(PlannedStage{Name: "ptr-sweep", Output: count, Unit: "hostnames", Basis: formatCount(addresses) + " addresses swept, " + basis, Queries: addresses})
+		if count > 0 {
+			plan.DiskBytes += 3 * int64(count) * name_bytes
+			enumerated += count
+		}
+	}
+
+	// phases 2 to 4: resolve, permute the resolved names, and resolve the permutations
+	resolved, basis := estimate("puredns-stage-1")
+	add(PlannedStage{Name: "puredns-stage-1", Command: wrtools.PurednsCommand(arg1, date, 0, opts.Wildcard, limits), Output: resolved, Unit: "resolved", Basis: basis, Queries: enumerated})
+	permutations, basis := estimate("dnsgen")
+	// without a run to go by, dnsgen makes about as many permutations of each resolved name as its wordlist has words. when
+	// the resolved names are unknown too, the permutations (and the queries resolving them) are given per resolved name.
+	if permutations < 0 {
+		wordlist, err := wrtools.DnsgenWordlist()
+		if words := wrutils.CountLines(wordlist); err == nil && words > 0 && resolved >= 0 {
+			permutations, basis = words*resolved, fmt.Sprintf("%s words in dnsgen's wordlist x %s resolved names", formatCount(words), formatCount(resolved))
+		} else if err == nil && words > 0 {
+			plan.PermutationsPerName = words
+			basis = fmt.Sprintf("about %s per resolved name, the words in dnsgen's wordlist", formatCount(words))
+		}
+	}
+	add(PlannedStage{Name: "dnsgen", Command: wrtools.DnsgenCommand(arg1, date), Output: permutations, Unit: "permutations", Basis: basis})
+	found, basis := estimate("dnsgen-puredns")
+	add(PlannedStage{Name: "dnsgen-puredns", Command: wrtools.PurednsCommand(arg1, date, 1, opts.Wildcard, limits), Output: found, Unit: "resolved", Basis: basis, Queries: max(permutations, 0)})
+	if opts.Probe {
+		add(PlannedStage{Name: "http-probe", Output: max(resolved, 0) + max(found, 0), Unit: "hosts", Basis: "every resolved subdomain, over HTTP and HTTPS"})
+	}
+	plan.DiskBytes += int64(max(permutations, 0)) * name_bytes
+	plan.DiskBytes += int64(max(resolved, 0)+max(found, 0)) * (3*name_bytes + resolvedRecordBytes)
+	return plan, nil
+}
+
+// function to print a plan
+func PrintPlan(plan Plan) {
+	out := wrlog.NewConsoleOutput()
+	out.Writeln(fmt.Sprintf("\n<info><b>Execution plan for %s (dry run, nothing was run):</b></info>", plan.Options.Program))
+	for index, stage := range plan.Stages {
+		volume := "unknown " + stage.Unit
+		if stage.Output >= 0 {
+			volume = "~" + formatCount(stage.Output) + " " + stage.Unit
+		}
+		out.Writeln(fmt.Sprintf("\t<comment>%d. %-16s %s (%s)</comment>", index+1, stage.Name, volume, stage.Basis))
+		if stage.Command != "" {
+			out.Writeln("\t\t<info>$ " + formatter.Escape(stage.Command) + "</info>")
+		}
+		if stage.Queries > 0 {
+			out.Writeln("\t\t<info>" + formatCount(stage.Queries) + " DNS queries</info>")
+		}
+	}
+
+	at_least := ""
+	if !plan.Complete {
+		at_least = "at least "
+	}
+	// without a limit, puredns sends as fast as the resolvers answer, so the duration is estimated at the trusted rate
+	rate, limited := plan.Options.Limits().Rate(len(wrtools.ReadNameservers())), "-qps/-resolver-qps"
+	if rate == 0 {
+		rate, limited = wrtools.PurednsTrustedRateLimit, "no -qps limit"
+	}
+	seconds := plan.Queries / rate
+	per_name := ""
+	if plan.PermutationsPerName > 0 {
+		per_name = fmt.Sprintf(" plus %s per name puredns-stage-1 resolves", formatCount(plan.PermutationsPerName))
+	}
+	out.Writeln("\n<info><b>Estimates:</b></info>")
+	out.Writeln(fmt.Sprintf("\t<comment>DNS queries    %s%s%s, %s at %d queries/s (%s)</comment>", at_least, formatCount(plan.Queries), per_name,
+		(time.Duration(seconds) * time.Second).String(), rate, limited))
+	if budget := plan.Options.QueryBudget; budget > 0 {
+		fits := "enough for the planned queries"
+		if plan.Queries > budget && plan.Options.BudgetExhausted == BudgetAbort {
+			fits = "too few, the run would stop once it is spent"
+		} else if plan.Queries > budget {
+			fits = "too few, the candidates over it would be left out"
+		} else if !plan.Complete {
+			fits = "enough for the queries which could be estimated"
+		}
+		out.Writeln(fmt.Sprintf("\t<comment>Query budget   %s queries, %s</comment>", formatCount(budget), fits))
+	}
+	if plan.Options.Distribute != "" {
+		out.Writeln(fmt.Sprintf("\t<comment>Resolution     in chunks of %s candidates, shared with the workers at %s</comment>", formatCount(plan.Options.ChunkSize), plan.Options.Distribute))
+	}
+	out.Writeln(fmt.Sprintf("\t<comment>Disk usage     %s%s in %s</comment>", at_least, formatBytes(plan.DiskBytes), wrutils.RunDirectory(plan.Options.Program, plan.Date)))
+	if plan.Queries > largeQueryVolume {
+		out.Writeln(fmt.Sprintf("\n<comment>WARNING - this run would send over %s DNS queries. Narrow the scope or leave the sub-generator out of -tools if that isn't intended.</comment>", formatCount(largeQueryVolume)))
+	}
+	out.Writeln("")
+}
+
+// returns n with thousands separators, e.g. 1,250,000
+func formatCount(n int) string {
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+	digits := fmt.Sprint(n)
+	for index := len(digits) - 3; index > 0; index -= 3 {
+		digits = digits[:index] + "," + digits[index:]
+	}
+	return digits
+}
+
+// returns a size in bytes in the largest unit it has one of, e.g. 1.5 GB
+func formatBytes(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit += 1
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
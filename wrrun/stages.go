@@ -0,0 +1,365 @@
+package wrrun
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrmetrics"
+	"github.com/sammooredev/WebRecon/wrprogress"
+	"github.com/sammooredev/WebRecon/wrreport"
+	"github.com/sammooredev/WebRecon/wrtools"
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// STAGE FUNCTIONS
+// the steps of a run which read the program's inputs and write the run's records and reports, printing what they found.
+
+// function to check whether a domains list exists. if it does, it normalizes the entries and prints out the domains to be tested. returns an error listing the line numbers of invalid entries. Return a string array of the domains,
+// less the entries which are subdomains of other entries, so the tools don't enumerate them twice
+func CheckDomainsList(arg1 string) ([]string, error) {
+	out := wrlog.NewConsoleOutput()
+	var lines []string
+	domains_list, err := os.Open("./Programs/" + arg1 + "/recon-data/domains.txt")
+	if err != nil {
+		return nil, fmt.Errorf("did you add a domains.txt file to ./Programs/%s/recon-data/domains.txt", arg1)
+	}
+	defer domains_list.Close()
+	scanner := bufio.NewScanner(domains_list)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	domains, invalid := wrutils.NormalizeDomainsList(lines)
+	if len(invalid) > 0 {
+		var messages []string
+		for _, e := range invalid {
+			messages = append(messages, e.Error())
+		}
+		return nil, fmt.Errorf("invalid entries in ./Programs/%s/recon-data/domains.txt:\n\t%s", arg1, strings.Join(messages, "\n\t"))
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("./Programs/%s/recon-data/domains.txt has no domains", arg1)
+	}
+
+	// an entry under another entry is left out, as enumerating the other entry covers it
+	kept := make(map[string]bool)
+	for _, domain := range wrutils.CatchRedundanciesInDomains(domains) {
+		kept[domain] = true
+	}
+	var tested, redundant []string
+	for _, domain := range domains {
+		if kept[domain] {
+			tested = append(tested, domain)
+		} else {
+			redundant = append(redundant, domain)
+		}
+	}
+
+	out.Writeln("\n<info><b>Domains to be tested: </info></b>")
+	for _, a := range tested {
+		out.Writeln("\t<comment>" + a + "</comment>")
+	}
+	if len(redundant) > 0 {
+		out.Writeln(fmt.Sprintf("\t<comment>NOTE - left out %d domains which are subdomains of other entries: %s</comment>", len(redundant), strings.Join(redundant, ", ")))
+	}
+	out.Writeln("\n")
+	return tested, nil
+}
+
+// function to read the IP ranges in ips.txt, printing them out. returns an error if an entry is invalid or the ranges hold more than ptrmax addresses.
+func CheckIPRanges(arg1 string, ptrmax int) ([]netip.Prefix, error) {
+	out := wrlog.NewConsoleOutput()
+	ip_ranges, err := wrutils.ReadIPRanges(arg1)
+	if err != nil {
+		return nil, err
+	}
+	if len(ip_ranges) == 0 {
+		return nil, nil
+	}
+	addresses := wrutils.CountAddresses(ip_ranges, ptrmax)
+	if addresses > ptrmax {
+		return nil, fmt.Errorf("the ranges in ./Programs/%s/recon-data/ips.txt hold more than %d addresses. Raise -ptr-max to sweep them", arg1, ptrmax)
+	}
+	out.Writeln("<info><b>IP ranges to be swept with PTR lookups: </info></b>")
+	for _, ip_range := range ip_ranges {
+		out.Writeln("\t<comment>" + ip_range.String() + "</comment>")
+	}
+	out.Writeln(fmt.Sprintf("\t<comment>(%d addresses)</comment>\n", addresses))
+	return ip_ranges, nil
+}
+
+// the stages which generate candidate subdomains, which find subdomains, and which send DNS queries, for the metrics
+var (
+	candidateStages = []string{"sub-generator", "dnsgen"}
+	sourceStages    = []string{"amass", "subfinder", "ptr-sweep", "dnsgen-puredns"}
+	queryStages     = []string{"puredns-stage-1", "dnsgen-puredns", "ptr-sweep"}
+)
+
+// why a stage was stopped before it finished: it ran out of time, or the watchdog found it had stopped producing output
+var (
+	ErrStageTimeout = errors.New("the stage timed out")
+	ErrStageStalled = errors.New("the stage stalled")
+)
+
+// StageLimits are how long a stage may run, and how long it may go without finding or processing anything, before it is
+// stopped. 0 is no limit.
+type StageLimits struct {
+	Timeout time.Duration
+	Stall   time.Duration
+}
+
+// function to stop a stage with ErrStageStalled once its progress hasn't moved for window. returns when ctx is done.
+func watchStage(ctx context.Context, tracker *wrprogress.Stage, window time.Duration, stop context.CancelCauseFunc) {
+	ticker := time.NewTicker(min(window/4, 10*time.Second))
+	defer ticker.Stop()
+	last, moved := tracker.Status(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		status := tracker.Status()
+		if status.Processed != last.Processed || status.Found != last.Found {
+			last, moved = status, time.Now()
+		} else if time.Since(moved) >= window {
+			stop(fmt.Errorf("%w: no output for %s", ErrStageStalled, window))
+			return
+		}
+	}
+}
+
+// how often the queries a stage has sent are added to the metrics while it runs
+const queryMetricsInterval = 5 * time.Second
+
+// function to add the queries a stage sends to the metrics as it sends them, reading them from its progress every
+// queryMetricsInterval. returns a function which adds the last of them once the stage has ended.
+func countQueries(program_name string, name string, tracker *wrprogress.Stage) func() {
+	counted := 0
+	add := func() {
+		if processed := tracker.Status().Processed; processed > counted {
+			wrmetrics.AddDNSQueries(program_name, name, processed-counted)
+			counted = processed
+		}
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(queryMetricsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				add()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		add()
+	}
+}
+
+// function to run a stage of a run, recording its count and run time in run_info and the metrics, showing its progress in
+// progress and logging how it ended. the stage is given a context which is cancelled when it runs past limits, and is then
+// recorded as stopped rather than failed, so the run goes on with what it produced. its tools are sent SIGTERM and given a
+// grace period to write out their results before they are killed, but a tool which only writes them when it finishes and
+// doesn't on SIGTERM keeps nothing but the names it had already printed.
+func RunStage(ctx context.Context, run_info *wrutils.RunInfo, progress *wrprogress.Progress, name string, limits StageLimits, run func(context.Context, *wrprogress.Stage) (int, error)) (int, error) {
+	logger := wrlog.FromContext(ctx).With("stage", name)
+	logger.Debug("Stage started")
+	start := time.Now()
+	tracker := progress.Stage(name)
+	stage_ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	if limits.Timeout > 0 {
+		timer := time.AfterFunc(limits.Timeout, func() { stop(fmt.Errorf("%w after %s", ErrStageTimeout, limits.Timeout)) })
+		defer timer.Stop()
+	}
+	if limits.Stall > 0 && tracker != nil {
+		go watchStage(stage_ctx, tracker, limits.Stall, stop)
+	}
+	// the queries a stage sent are the items it processed, as the resolution stages report them
+	count_queries := func() {}
+	if tracker != nil && wrutils.SliceContainsString(queryStages, name) {
+		count_queries = countQueries(run_info.Program, name, tracker)
+	}
+	var stopped error
+	count, err := run_info.TimeStage(name, func() (int, error) {
+		count, err := run(stage_ctx, tracker)
+		// a stage cancelled along with the run is cancelled, not stopped
+		if cause := context.Cause(stage_ctx); err != nil && ctx.Err() == nil && (errors.Is(cause, ErrStageTimeout) || errors.Is(cause, ErrStageStalled)) {
+			stopped, err = cause, nil
+		}
+		return count, err
+	})
+	duration := time.Since(start)
+	count_queries()
+	if stopped != nil {
+		run_info.StopStage(name, stopped.Error())
+		tracker.Done(count, stopped)
+		logger.Warn("Stage stopped, keeping its partial results", "reason", stopped, "count", count, "duration", duration)
+	} else if err != nil {
+		tracker.Done(count, err)
+		logger.Error("Stage failed", "count", count, "duration", duration, "error", err)
+	} else {
+		tracker.Done(count, err)
+		logger.Info("Stage finished", "count", count, "duration", duration)
+	}
+
+	wrmetrics.ObserveStage(run_info.Program, name, duration, err)
+	if wrutils.SliceContainsString(candidateStages, name) {
+		wrmetrics.AddCandidates(run_info.Program, name, count)
+	}
+	if wrutils.SliceContainsString(sourceStages, name) {
+		wrmetrics.AddSubdomains(run_info.Program, name, count)
+	}
+	return count, err
+}
+
+// function to build dns_records.json for a run, enriching the addresses with their ASN, organisation and provider when ./wordlists/ip2asn.tsv exists,
+// and with their HTTP responses when opts.Probe is set. prints a summary of where the hosts live.
+func CreateDNSRecords(ctx context.Context, arg1 string, date string, opts Options, run_info *wrutils.RunInfo, progress *wrprogress.Progress) ([]wrutils.HostRecord, error) {
+	out := wrlog.NewConsoleOutput()
+	logger := wrlog.FromContext(ctx)
+	records := wrutils.BuildDNSRecords(arg1, date)
+	if opts.Probe {
+		if _, err := RunStage(ctx, run_info, progress, "http-probe", opts.StageLimits("http-probe"), func(ctx context.Context, tracker *wrprogress.Stage) (int, error) {
+			return wrtools.RunHTTPProbe(ctx, arg1, records, tracker)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := os.Stat(wrutils.DefaultASNDatabase); err == nil {
+		db, err := wrutils.LoadASNDatabase(wrutils.DefaultASNDatabase)
+		if err != nil {
+			logger.Error("Could not load the ASN database, skipping ASN enrichment", "path", wrutils.DefaultASNDatabase, "error", err)
+		} else {
+			wrutils.EnrichDNSRecords(records, db)
+		}
+	} else {
+		logger.Info("No ASN database found, skipping ASN enrichment. Import one with ./WebRecon asndb import <file>", "path", wrutils.DefaultASNDatabase)
+	}
+
+	if err := wrutils.WriteDNSRecords(arg1, date, records); err != nil {
+		return nil, err
+	}
+	logger.Info("Wrote DNS records", "subdomains", len(records), "path", "./Programs/"+arg1+"/"+date+"/dns_records.json")
+
+	asns := wrutils.SummarizeASNs(records)
+	if len(asns) == 0 {
+		return records, nil
+	}
+	kinds := wrutils.SummarizeHostKinds(records)
+	out.Writeln("\n<info><b>Hosting summary:</b></info>")
+	for _, kind := range []string{wrutils.HostKindSelfHosted, wrutils.ProviderKindCloud, wrutils.ProviderKindCDN, wrutils.ProviderKindSaaS, wrutils.ProviderKindHosting, wrutils.HostKindUnknown} {
+		if kinds[kind] > 0 {
+			out.Writeln(fmt.Sprintf("\t<comment>%-12s %d hosts</comment>", kind, kinds[kind]))
+		}
+	}
+	out.Writeln("\n<info><b>Top ASNs:</b></info>")
+	for index, asn := range asns {
+		if index == 10 {
+			break
+		}
+		provider := ""
+		if asn.Provider != "" {
+			provider = " [" + asn.Provider + "]"
+		}
+		out.Writeln(fmt.Sprintf("\t<comment>AS%-8d %-6d %s%s</comment>", asn.ASN, asn.Hosts, asn.Org, provider))
+	}
+	return records, nil
+}
+
+// function to write clusters.json and clusters.txt for a run, printing the hosts found on unique infrastructure
+func CreateClusterReport(ctx context.Context, arg1 string, date string, records []wrutils.HostRecord) error {
+	out := wrlog.NewConsoleOutput()
+	report := wrutils.ClusterDNSRecords(records)
+	if err := wrutils.WriteClusterReport(arg1, date, report); err != nil {
+		return err
+	}
+	wrlog.FromContext(ctx).Info("Clustered the resolved subdomains", "subdomains", report.Resolved, "ips", len(report.ByIP), "subnets", len(report.BySubnet),
+		"cname_targets", len(report.ByCNAME), "asns", len(report.ByASN), "path", "./Programs/"+arg1+"/"+date+"/clusters.txt")
+	if len(report.Unique) == 0 {
+		return nil
+	}
+	out.Writeln(fmt.Sprintf("\n<info><b>Hosts on unique infrastructure (%d):</b></info>", len(report.Unique)))
+	for index, host := range report.Unique {
+		if index == 20 {
+			out.Writeln(fmt.Sprintf("\t<comment>... and %d more in clusters.txt</comment>", len(report.Unique)-20))
+			break
+		}
+		out.Writeln("\t<comment>" + host.Host + " (" + strings.Join(host.Subnets, ", ") + ")</comment>")
+	}
+	return nil
+}
+
+// function to write takeover_candidates.json for a run, printing the candidates
+func CreateTakeoverCandidates(ctx context.Context, arg1 string, date string, records []wrutils.HostRecord) error {
+	out := wrlog.NewConsoleOutput()
+	candidates := wrutils.FindTakeoverCandidates(records)
+	if err := wrutils.WriteTakeoverCandidates(arg1, date, candidates); err != nil {
+		return err
+	}
+	wrlog.FromContext(ctx).Info("Wrote takeover candidates", "candidates", len(candidates), "path", "./Programs/"+arg1+"/"+date+"/takeover_candidates.json")
+	if len(candidates) == 0 {
+		return nil
+	}
+	out.Writeln(fmt.Sprintf("\n<info><b>Takeover candidates (%d):</b></info>", len(candidates)))
+	for _, candidate := range candidates {
+		out.Writeln(fmt.Sprintf("\t<comment>[%s] %s -> %s (%s)</comment>", candidate.Confidence, candidate.Host, candidate.CNAME, candidate.Reason))
+	}
+	return nil
+}
+
+// function to write report.html for a run. returns the report, or nil if it couldn't be built.
+func CreateHTMLReport(ctx context.Context, arg1 string, date string) *wrreport.RunReport {
+	logger := wrlog.FromContext(ctx)
+	report, err := wrreport.BuildRunReport(arg1, date)
+	if err != nil {
+		logger.Error("Could not build the run report", "error", err)
+		return nil
+	}
+	path, err := wrreport.WriteHTMLReport(report)
+	if err != nil {
+		logger.Error("Could not write the run report", "error", err)
+		return report
+	}
+	logger.Info("Wrote the run report", "path", path)
+	return report
+}
+
+// function to add a run to its program's inventory, printing the hosts that were never seen before
+func UpdateInventory(ctx context.Context, arg1 string, date string) {
+	logger := wrlog.FromContext(ctx)
+	added, err := wrutils.UpdateInventory(arg1, date)
+	if err != nil {
+		logger.Error("Could not update the inventory", "error", err)
+		return
+	}
+	logger.Info("Updated the inventory", "path", wrutils.InventoryPath(), "new_hosts", len(added))
+}
+
+// function to remove out of scope entries from a file produced by a stage, printing how many were removed
+func EnforceScope(ctx context.Context, path string, scope *wrutils.Scope) error {
+	removed, err := wrutils.FilterFileByScope(path, scope)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		wrlog.FromContext(ctx).Info("Removed out of scope entries", "removed", removed, "path", path)
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package wrrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrdist"
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrmetrics"
+	"github.com/sammooredev/WebRecon/wrnotify"
+	"github.com/sammooredev/WebRecon/wrprogress"
+	"github.com/sammooredev/WebRecon/wrtools"
+	"github.com/sammooredev/WebRecon/wrutils"
+
+	"github.com/DrSmithFr/go-console/pkg/input"
+	"github.com/DrSmithFr/go-console/pkg/style"
+)
+
+// RUN FUNCTIONS
+// a run enumerates a program's subdomains, resolves them, permutes and resolves them again, and writes the records and reports
+// for the result into ./Programs/<program>/<date>/. runs can be started from the command line, the daemon or the API; they
+// stop when their context is cancelled, and their outcome is recorded in the run's manifest.json either way.
+
+// function to run enumeration against a program. events are sent to notifications, and the progress of each stage to
+// progress; either may be nil. returns the run's manifest, and an error if the run failed or was cancelled.
+func Run(ctx context.Context, opts Options, notifications *wrnotify.Dispatcher, progress *wrprogress.Progress) (wrutils.RunInfo, error) {
+	// cmd output styling stuff
+	in := input.NewArgvInput(nil)
+	out := wrlog.NewConsoleOutput()
+	io := style.NewGoStyler(in, out)
+
+	// declare variables
+	var wg sync.WaitGroup // for running cmd commands simultaneously
+	var mute sync.Mutex   // to establish queue for writing using multiple threads
+	arg1 := opts.Program
+	if notifications == nil {
+		notifications = wrnotify.NewDispatcher(nil)
+	}
+	// the stages are tracked without a display, so their counts reach the metrics
+	if progress == nil {
+		progress = wrprogress.New(arg1)
+	}
+
+	// get full tool run time
+	start_time := time.Now()
+
+	// get date
+	date := start_time.Format(wrutils.RunDateFormat)
+	run_info := wrutils.RunInfo{Program: arg1, Date: date, Status: wrutils.RunStatusRunning, Started: start_time}
+
+	// a run which stops before it has started (a bad domains.txt, a program being run already, ...) has no manifest, and
+	// is returned as failed
+	not_started := func(err error) (wrutils.RunInfo, error) {
+		run_info.Status = wrutils.RunStatusFailed
+		run_info.Error = err.Error()
+		return run_info, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return not_started(err)
+	}
+	// only one run of a program at a time; runs of a program on the same day share a run directory
+	unlock, err := wrutils.LockProgram(arg1)
+	if err != nil {
+		return not_started(err)
+	}
+	defer unlock()
+
+	// check domains list exists, has content, and output the domains to be tested
+	domains, err := CheckDomainsList(arg1)
+	if err != nil {
+		return not_started(err)
+	}
+	// load the scope rules. every stage below drops out of scope names before they are resolved or reported.
+	scope, err := wrutils.ReadScope(arg1, domains)
+	if err != nil {
+		return not_started(err)
+	}
+	// IP ranges to sweep with PTR lookups, from the optional ips.txt
+	ip_ranges, err := CheckIPRanges(arg1, opts.PTRMax)
+	if err != nil {
+		return not_started(err)
+	}
+	// build directory structure for new program
+	if err := wrutils.BuildNewProgramDirectory(arg1, date, domains); err != nil {
+		return not_started(err)
+	}
+	// the tools read the normalized copy of domains.txt in the run directory
+	if _, err := wrutils.WriteDomainsList(arg1, date, domains); err != nil {
+		return not_started(err)
+	}
+	data_directory := wrutils.RunDirectory(arg1, date)
+	// everything the run does is logged to run.log in its directory, as well as to the console
+	logger, close_log, err := wrlog.OpenRunLog(data_directory+wrlog.RunLogFile, arg1, date)
+	if err != nil {
+		return not_started(err)
+	}
+	defer close_log()
+	ctx = wrlog.WithLogger(ctx, logger)
+	logger.Info("Run started", "tools", strings.Join(opts.Tools, ","), "amass_timeout", opts.AmassTimeout, "wildcard", opts.Wildcard, "ptr_max", opts.PTRMax, "probe", opts.Probe)
+	if len(opts.StageTimeouts) > 0 || opts.StallTimeout > 0 {
+		logger.Info("Stage limits", "stage_timeouts", opts.StageTimeouts.String(), "stall_timeout", time.Duration(opts.StallTimeout))
+	}
+	// record the run in manifest.json so ./WebRecon programs show can summarise it, with what it is run with. from here on, a
+	// run which fails or is cancelled is recorded as such.
+	DescribeRun(ctx, &run_info, opts)
+	writeRunInfo(logger, run_info)
+	// the DNS queries of the resolution rounds and the PTR sweep share the run's rate limits and budget
+	limits := opts.Limits()
+	wrmetrics.RunStarted(arg1)
+	fail := func(err error) (wrutils.RunInfo, error) {
+		run_info.Status = wrutils.RunStatusFailed
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			run_info.Status = wrutils.RunStatusCancelled
+		}
+		run_info.Error = err.Error()
+		run_info.Queries = limits.Usage()
+		run_info.Finished = time.Now()
+		run_info.DurationSeconds = time.Since(start_time).Seconds()
+		logger.Error("Run "+run_info.Status, "duration", run_info.Duration(), "error", err)
+		writeRunInfo(logger, run_info)
+		wrmetrics.RunFinished(arg1, run_info.Status)
+		notifications.Send(wrnotify.RunInfoEvent(run_info))
+		notifications.Wait()
+		return run_info, err
+	}
+
+	// with -distribute, the resolution rounds are shared with the workers which connect to the run. the coordinator
+	// listens from the start, so a bad address fails the run before the enumeration and workers can connect early
+	var distributor wrtools.Distributor
+	if opts.Distribute != "" {
+		coordinator, err := wrdist.NewCoordinator(ctx, opts.Distribute, os.Getenv(wrdist.TokenEnv), opts.DistributeCert, opts.DistributeKey, opts.ChunkSize, limits)
+		if err != nil {
+			return fail(fmt.Errorf("could not serve the resolution to workers - %w", err))
+		}
+		defer coordinator.Close()
+		distributor = coordinator
+	}
+
+	////                    ////
+	//  start of enumeration  //
+	////    				////
+	io.Section("Starting Subdomain Enumeration & Generating Potential Subdomains for " + arg1)
+	///
+	// Phase 1: subdomain generation. - generate subdomains, run amass, run subfinder, run X simultaneously.
+	///
+
+	// the first tool to fail stops the others
+	phase_ctx, cancel_phase := context.WithCancel(ctx)
+	defer cancel_phase()
+	var phase_error error
+	var phase_once sync.Once
+	stage := func(name string, run func(context.Context, *wrprogress.Stage) (int, error)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := RunStage(phase_ctx, &run_info, progress, name, opts.StageLimits(name), run); err != nil {
+				phase_once.Do(func() {
+					phase_error = fmt.Errorf("%s: %w", name, err)
+					cancel_phase()
+				})
+			}
+		}()
+	}
+
+	// each stage's output count and run time is recorded in manifest.json
+	if wrutils.SliceContainsString(opts.Tools, "sub-generator") {
+		stage("sub-generator", func(ctx context.Context, tracker *wrprogress.Stage) (int, error) {
+			return wrtools.PotentialSubdomainGeneratorMain(ctx, domains, arg1, date, scope, &mute, tracker)
+		})
+	}
+	if wrutils.SliceContainsString(opts.Tools, "amass") {
+		blacklist, err := wrutils.WriteScopeBlacklist(arg1, date, scope)
+		if err != nil {
+			return fail(err)
+		}
+		// regex exclusions can't be passed to amass, so it is kept from touching the names they exclude
+		passive := scope.HasRegexExclusions()
+		if passive {
+			logger.Info("Running amass passively, as scope.txt has regex exclusions which can't be passed to it")
+		}
+		stage("amass", func(ctx context.Context, tracker *wrprogress.Stage) (int, error) {
+			return wrtools.RunAmass(ctx, arg1, date, int(opts.AmassTimeout), blacklist, passive, tracker)
+		})
+	}
+	if wrutils.SliceContainsString(opts.Tools, "subfinder") {
+		stage("subfinder", func(ctx context.Context, tracker *wrprogress.Stage) (int, error) {
+			return wrtools.RunSubfinder(ctx, arg1, date, tracker)
+		})
+	}
+	// the outputs combined after phase 1. "ptr-sweep" is only run when the program has an ips.txt
+	sources := append([]string{}, opts.Tools...)
+	if len(ip_ranges) > 0 {
+		stage("ptr-sweep", func(ctx context.Context, tracker *wrprogress.Stage) (int, error) {
+			return wrtools.RunPTRSweep(ctx, arg1, date, ip_ranges, scope, limits, tracker)
+		})
+		sources = append(sources, "ptr-sweep")
+	}
+	wg.Wait()
+	// a cancelled run is reported as cancelled, not as the tool it happened to stop first
+	if ctx.Err() != nil {
+		return fail(ctx.Err())
+	}
+	if phase_error != nil {
+		return fail(phase_error)
+	}
+
+	// this function combines all the files within the date directory for the scan (./Programs/Google/01-25-23/*) into one file, sorted and without duplicates, sorting on disk when they don't fit in -sort-memory. outputs the file: "all_enumerated_subdomains_combined.txt"
+	if err := wrutils.CombineFiles(sources, arg1, date, opts.SortMemoryBytes()); err != nil {
+		return fail(err)
+	}
+	if err := EnforceScope(ctx, data_directory+"all_enumerated_subdomains_combined.txt", scope); err != nil {
+		return fail(err)
+	}
+	notifications.Send(wrnotify.PhaseEvent(run_info, "enumeration", wrutils.CountLines(data_directory+"all_enumerated_subdomains_combined.txt")))
+
+	///
+	// Phase 2: validate subdomains exist via bruteforcing reverse dns lookups
+	///
+
+	io.Section("Starting Reverse DNS Bruteforcing for " + arg1)
+	// start clock to get runtime
+	start2 := time.Now()
+
+	// run puredns for the domain - an instance of puredns is ran for each domain as its required for wildcard filtering.
+	resolved, err := RunStage(ctx, &run_info, progress, "puredns-stage-1", opts.StageLimits("puredns-stage-1"), func(ctx context.Context, tracker *wrprogress.Stage) (int, error) {
+		return wrtools.RunPuredns(ctx, arg1, date, 0, opts.Wildcard, limits, distributor, tracker)
+	})
+	if err != nil {
+		return fail(err)
+	}
+	notifications.Send(wrnotify.PhaseEvent(run_info, "resolution", resolved))
+
+	// print out the commands completed and the runtime
+	io.Success(fmt.Sprintf("Reverse DNS Bruteforcing Done! Finished in %v.", time.Since(start2)))
+
+	///
+	// Phase 3: Run dnsgen on each puredns output, generating permutations of the valid domains
+	///
+	io.Section("Starting generating permutations via dnsgen for " + arg1)
+	// start clock to get runtime
+	start3 := time.Now()
+
+	//run dnsgen for each puredns output
+	if _, err := RunStage(ctx, &run_info, progress, "dnsgen", opts.StageLimits("dnsgen"), func(ctx context.Context, tracker *wrprogress.Stage) (int, error) {
+		return wrtools.RunDnsgen(ctx, arg1, date, tracker)
+	}); err != nil {
+		return fail(err)
+	}
+	// dnsgen repeats permutations it reaches from several names, and appends to the output of an earlier run on the same day
+	if _, err := wrutils.SortUnique([]string{data_directory + "dnsgen.out"}, data_directory+"dnsgen.out", opts.SortMemoryBytes()); err != nil {
+		return fail(err)
+	}
+	if err := EnforceScope(ctx, data_directory+"dnsgen.out", scope); err != nil {
+		return fail(err)
+	}
+	notifications.Send(wrnotify.PhaseEvent(run_info, "permutation", wrutils.CountLines(data_directory+"dnsgen.out")))
+
+	// print out the commands completed and the runtime
+	io.Success(fmt.Sprintf("Permutation generation Done! Finished in %v.", time.Since(start3)))
+
+	///
+	// Phase 4: Validate dnsgen output subdomains exist via bruteforcing reverse dns lookups
+	///
+
+	io.Section("Starting second round of reverse DNS bruteforcing against the dnsgen output for " + arg1)
+	start4 := time.Now()
+
+	// run puredns for the domain - an instance of puredns is ran for each domain as its required for wildcard filtering.
+	permutations, err := RunStage(ctx, &run_info, progress, "dnsgen-puredns", opts.StageLimits("dnsgen-puredns"), func(ctx context.Context, tracker *wrprogress.Stage) (int, error) {
+		return wrtools.RunPuredns(ctx, arg1, date, 1, opts.Wildcard, limits, distributor, tracker)
+	})
+	if err != nil {
+		return fail(err)
+	}
+	notifications.Send(wrnotify.PhaseEvent(run_info, "permutation resolution", permutations))
+
+	// print out the commands completed and the runtime
+	io.Success(fmt.Sprintf("Reverse DNS Bruteforcing against dnsgen ouput done! Finished in %v.", time.Since(start4)))
+
+	///
+	// Phase 5: Completion and clean up. Combine dnsgen outputs, place into <date> directory for test.
+	///
+	io.Section("All enumeration and reverse DNS bruteforcing complete. Creating output files for " + arg1 + "...")
+	if err := wrutils.CreateFileOfAllValidSubdomainsCombined(arg1, date, opts.SortMemoryBytes()); err != nil {
+		return fail(err)
+	}
+	for _, path := range []string{data_directory + "final_list.out", data_directory + "final_list_unique.out"} {
+		if err := EnforceScope(ctx, path, scope); err != nil {
+			return fail(err)
+		}
+	}
+	// collect the DNS records of every subdomain found, tagged with ASN information if an ASN database was imported
+	records, err := CreateDNSRecords(ctx, arg1, date, opts, &run_info, progress)
+	if err != nil {
+		return fail(err)
+	}
+	// group the subdomains by shared infrastructure and list the ones on unique infrastructure
+	if err := CreateClusterReport(ctx, arg1, date, records); err != nil {
+		return fail(err)
+	}
+	// flag subdomains pointing at services that allow takeovers
+	if err := CreateTakeoverCandidates(ctx, arg1, date, records); err != nil {
+		return fail(err)
+	}
+
+	run_info.Status = wrutils.RunStatusComplete
+	run_info.Finished = time.Now()
+	run_info.DurationSeconds = time.Since(start_time).Seconds()
+	run_info.Subdomains = wrutils.CountLines(data_directory + "final_list_unique.out")
+	run_info.Queries = limits.Usage()
+	writeRunInfo(logger, run_info)
+	wrmetrics.RunFinished(arg1, run_info.Status)
+	logger.Info("Run complete", "subdomains", run_info.Subdomains, "duration", run_info.Duration(), "dns_queries", run_info.Queries.Used)
+	if len(run_info.Queries.Truncated) > 0 {
+		logger.Warn("The query budget ran out, some candidates weren't resolved", "budget", run_info.Queries.Budget, "truncated", strings.Join(run_info.Queries.Truncated, ","))
+	}
+	for _, stage := range run_info.Stages {
+		if stage.Stopped != "" {
+			logger.Warn("A stage was stopped before it finished, the run's results are partial", "stage", stage.Name, "reason", stage.Stopped)
+		}
+	}
+	// summarise the run in a self-contained report.html
+	report := CreateHTMLReport(ctx, arg1, date)
+	// record the hosts in the program's inventory
+	UpdateInventory(ctx, arg1, date)
+	if report != nil {
+		notifications.Send(wrnotify.RunEvent(report))
+	} else {
+		notifications.Send(wrnotify.RunInfoEvent(run_info))
+	}
+	notifications.Wait()
+	return run_info, nil
+}
+
+// function to write a run's manifest.json, logging it rather than failing the run if it can't be written
+func writeRunInfo(logger *slog.Logger, run_info wrutils.RunInfo) {
+	if err := wrutils.WriteRunInfo(run_info); err != nil {
+		logger.Error("Could not write the run's manifest", "path", wrutils.RunDirectory(run_info.Program, run_info.Date)+"manifest.json", "error", err)
+	}
+}
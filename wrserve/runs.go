@@ -0,0 +1,265 @@
+package wrserve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrnotify"
+	"github.com/sammooredev/WebRecon/wrprogress"
+	"github.com/sammooredev/WebRecon/wrrun"
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// RUN MANAGEMENT FUNCTIONS
+// runs started through the API happen in the server's process. each run's phase and run events are recorded, so a client
+// which starts streaming a run part way through is sent everything that happened before it connected. the progress of
+// the run's stages is sent every few seconds as well, but isn't recorded; a client is sent the latest when it connects.
+
+// stream event names
+const (
+	StreamPhase    = "phase"
+	StreamRun      = "run"
+	StreamProgress = "progress"
+	StreamFinished = "finished"
+)
+
+// how often a run's stream is sent the progress of its stages
+var streamProgressInterval = 2 * time.Second
+
+// StreamEvent is an event of a run's progress stream.
+type StreamEvent struct {
+	Name string      `json:"name"`
+	Data interface{} `json:"data"`
+}
+
+// FinishedEvent is the last event of a run's stream.
+type FinishedEvent struct {
+	Run   wrutils.RunInfo `json:"run"`
+	Error string          `json:"error,omitempty"`
+}
+
+// ActiveRun is a run started through the API which hasn't finished.
+type ActiveRun struct {
+	Program string        `json:"program"`
+	Options wrrun.Options `json:"options"`
+	Started time.Time     `json:"started"`
+	// the latest phase the run finished, and the stages it has finished
+	Phase  string          `json:"phase,omitempty"`
+	Stages []wrutils.Stage `json:"stages"`
+	// the progress of every stage started so far, including the ones running
+	Progress []wrprogress.StageStatus `json:"progress"`
+
+	cancel      context.CancelFunc
+	progress    *wrprogress.Progress
+	mutex       sync.Mutex
+	events      []StreamEvent
+	subscribers map[chan StreamEvent]bool
+	done        chan struct{}
+}
+
+// ErrRunActive is returned when a run is started for a program which is already being run.
+var ErrRunActive = errors.New("program is already being run")
+
+// function to record an event and send it to every subscriber
+func (a *ActiveRun) publish(event StreamEvent) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.events = append(a.events, event)
+	a.send(event)
+}
+
+// function to send an event to every subscriber, without recording it. subscribers which can't keep up miss events rather
+// than holding up the run. the caller must hold the run's mutex.
+func (a *ActiveRun) send(event StreamEvent) {
+	for subscriber := range a.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// function to send the progress of the run's stages to its subscribers every streamProgressInterval until done is closed
+func (a *ActiveRun) publishProgress(done chan struct{}) {
+	ticker := time.NewTicker(streamProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			event := StreamEvent{Name: StreamProgress, Data: a.progress.Snapshot()}
+			a.mutex.Lock()
+			a.send(event)
+			a.mutex.Unlock()
+		case <-done:
+			return
+		}
+	}
+}
+
+// function to subscribe to a run's events. returns the events so far, and a channel of the events to come which is closed when the run ends.
+func (a *ActiveRun) subscribe() ([]StreamEvent, chan StreamEvent) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	events := append([]StreamEvent{}, a.events...)
+	subscriber := make(chan StreamEvent, 64)
+	select {
+	case <-a.done:
+		close(subscriber)
+	default:
+		a.subscribers[subscriber] = true
+	}
+	return events, subscriber
+}
+
+// function to stop receiving a run's events
+func (a *ActiveRun) unsubscribe(subscriber chan StreamEvent) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.subscribers[subscriber] {
+		delete(a.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// returns a copy of the run's state, for encoding
+func (a *ActiveRun) snapshot() *ActiveRun {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return &ActiveRun{Program: a.Program, Options: a.Options, Started: a.Started, Phase: a.Phase, Stages: append([]wrutils.Stage{}, a.Stages...), Progress: a.progress.Snapshot()}
+}
+
+// streamNotifier passes a run's notification events to its stream.
+type streamNotifier struct {
+	run *ActiveRun
+}
+
+func (s *streamNotifier) Name() string {
+	return "progress stream of " + s.run.Program
+}
+
+func (s *streamNotifier) Wants(event wrnotify.Event) bool {
+	return event.Program == s.run.Program
+}
+
+func (s *streamNotifier) Notify(event wrnotify.Event) error {
+	name := StreamRun
+	if event.Type == wrnotify.EventPhase {
+		name = StreamPhase
+		s.run.mutex.Lock()
+		s.run.Phase, s.run.Stages = event.Phase, event.Stages
+		s.run.mutex.Unlock()
+	}
+	s.run.publish(StreamEvent{Name: name, Data: event})
+	return nil
+}
+
+// RunManager starts runs and keeps track of the ones in progress.
+type RunManager struct {
+	// the notifiers in notify.json, which are told about API runs as they are about any other
+	notifiers []wrnotify.Notifier
+	ctx       context.Context
+	mutex     sync.Mutex
+	active    map[string]*ActiveRun
+	wg        sync.WaitGroup
+}
+
+// returns a run manager whose runs are cancelled when ctx is
+func NewRunManager(ctx context.Context, notifiers []wrnotify.Notifier) *RunManager {
+	return &RunManager{notifiers: notifiers, ctx: ctx, active: make(map[string]*ActiveRun)}
+}
+
+// function to start a run in the background. returns ErrRunActive if the program is already being run by the server, or
+// wrutils.ErrProgramLocked if it is being run by another process.
+func (m *RunManager) Start(opts wrrun.Options) (*ActiveRun, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.active[opts.Program]; ok {
+		return nil, ErrRunActive
+	}
+	if lock, locked := wrutils.ProgramLocked(opts.Program); locked {
+		return nil, fmt.Errorf("%w: %s is being run by process %d since %s", wrutils.ErrProgramLocked, opts.Program, lock.PID, lock.Started.Format(time.RFC3339))
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	run := &ActiveRun{
+		Program:     opts.Program,
+		Options:     opts,
+		Started:     time.Now(),
+		Stages:      []wrutils.Stage{},
+		cancel:      cancel,
+		progress:    wrprogress.New(opts.Program),
+		subscribers: make(map[chan StreamEvent]bool),
+		done:        make(chan struct{}),
+	}
+	m.active[opts.Program] = run
+	notifications := wrnotify.NewDispatcher(append(append([]wrnotify.Notifier{}, m.notifiers...), &streamNotifier{run: run}))
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+		stop_progress := run.progress.ShowLog()
+		stop_stream := make(chan struct{})
+		go run.publishProgress(stop_stream)
+		run_info, err := wrrun.Run(ctx, opts, notifications, run.progress)
+		close(stop_stream)
+		stop_progress()
+		finished := FinishedEvent{Run: run_info}
+		if err != nil {
+			finished.Error = err.Error()
+		}
+		run.publish(StreamEvent{Name: StreamFinished, Data: finished})
+
+		m.mutex.Lock()
+		delete(m.active, opts.Program)
+		m.mutex.Unlock()
+		run.mutex.Lock()
+		close(run.done)
+		for subscriber := range run.subscribers {
+			close(subscriber)
+		}
+		run.subscribers = nil
+		run.mutex.Unlock()
+	}()
+	return run, nil
+}
+
+// returns the run of a program in progress, if there is one
+func (m *RunManager) Active(program_name string) (*ActiveRun, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	run, ok := m.active[program_name]
+	return run, ok
+}
+
+// returns every run in progress, sorted by program
+func (m *RunManager) ActiveRuns() []*ActiveRun {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	runs := []*ActiveRun{}
+	for _, run := range m.active {
+		runs = append(runs, run.snapshot())
+	}
+	sort.Slice(runs, func(a, b int) bool { return runs[a].Program < runs[b].Program })
+	return runs
+}
+
+// function to cancel the run of a program in progress. returns false if there is none.
+func (m *RunManager) Cancel(program_name string) bool {
+	run, ok := m.Active(program_name)
+	if ok {
+		run.cancel()
+	}
+	return ok
+}
+
+// function to wait for every run to end. runs end when they finish or the manager's context is cancelled.
+func (m *RunManager) Wait() {
+	m.wg.Wait()
+}
@@ -0,0 +1,34 @@
+package wrserve
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// WEB UI FUNCTIONS
+// the web UI is a page which browses programs, runs and results through the API, for anyone without a shell on the
+// machine. its files hold no data, so they are served without the token; the page asks for it and sends it to /api/.
+
+//go:embed ui
+var uiFiles embed.FS
+
+// returns the handler serving the web UI
+func UIHandler() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	file_server := http.FileServer(http.FS(files))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, errorStatus(http.StatusMethodNotAllowed, "method not allowed"))
+			return
+		}
+		// results include page titles and hostnames chosen by the targets, so the page runs only its own script
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		file_server.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,450 @@
+// WebRecon web UI. every page is built from the JSON API with the token entered at sign in, so the UI itself holds no data.
+"use strict";
+
+var tokenKey = "webrecon-token";
+var view = document.getElementById("view");
+var breadcrumbs = document.getElementById("breadcrumbs");
+var signOut = document.getElementById("sign-out");
+// the stream of the run being watched, aborted when the page changes
+var stream = null;
+
+// builds an element. children are elements or strings, which are always added as text
+function el(tag, attributes, children) {
+	var element = document.createElement(tag);
+	Object.keys(attributes || {}).forEach(function (name) {
+		if (name === "text") {
+			element.textContent = attributes[name];
+		} else if (name.indexOf("on") === 0) {
+			element.addEventListener(name.slice(2), attributes[name]);
+		} else {
+			element.setAttribute(name, attributes[name]);
+		}
+	});
+	(children || []).forEach(function (child) {
+		if (child !== null && child !== undefined) {
+			element.appendChild(typeof child === "string" ? document.createTextNode(child) : child);
+		}
+	});
+	return element;
+}
+
+function link(href, text) {
+	return el("a", {href: href, text: text});
+}
+
+function badge(text, kind) {
+	return el("span", {"class": "badge " + (kind || text), text: text});
+}
+
+function datetime(value) {
+	if (!value || value.indexOf("0001-") === 0) {
+		return "-";
+	}
+	return new Date(value).toLocaleString();
+}
+
+function seconds(value) {
+	value = Math.round(value || 0);
+	var h = Math.floor(value / 3600), m = Math.floor(value % 3600 / 60), s = value % 60;
+	return (h ? h + "h" : "") + (h || m ? m + "m" : "") + s + "s";
+}
+
+function programPath(name) {
+	return "/api/programs/" + encodeURIComponent(name);
+}
+
+// fetches an API path as JSON. an invalid token signs the user out.
+function api(path) {
+	return fetch(path, {headers: {"Authorization": "Bearer " + sessionStorage.getItem(tokenKey)}}).then(function (response) {
+		if (response.status === 401) {
+			sessionStorage.removeItem(tokenKey);
+			showSignIn("The API token was not accepted.");
+			throw new Error("unauthorized");
+		}
+		return response.json().then(function (body) {
+			if (!response.ok) {
+				throw new Error(body.error || response.statusText);
+			}
+			return body;
+		});
+	});
+}
+
+function setBreadcrumbs(parts) {
+	breadcrumbs.textContent = "";
+	parts.forEach(function (part, index) {
+		if (index > 0) {
+			breadcrumbs.appendChild(document.createTextNode(" / "));
+		}
+		breadcrumbs.appendChild(typeof part === "string" ? document.createTextNode(part) : part);
+	});
+}
+
+function showError(error) {
+	if (error.message !== "unauthorized") {
+		view.textContent = "";
+		view.appendChild(el("section", {}, [el("p", {"class": "error", text: error.message})]));
+	}
+}
+
+function showSignIn(message) {
+	signOut.hidden = true;
+	setBreadcrumbs([]);
+	view.textContent = "";
+	view.appendChild(document.getElementById("sign-in").content.cloneNode(true));
+	var form = view.querySelector("form");
+	var error = view.querySelector(".error");
+	if (message) {
+		error.textContent = message;
+		error.hidden = false;
+	}
+	form.addEventListener("submit", function (event) {
+		event.preventDefault();
+		sessionStorage.setItem(tokenKey, form.token.value);
+		route();
+	});
+	form.token.focus();
+}
+
+// builds a table whose columns sort when their header is clicked. a cell is a string, an element, or {text, sort} / {node, sort}.
+function table(headers, rows, id) {
+	var tbody = el("tbody");
+	rows.forEach(function (row) {
+		tbody.appendChild(el("tr", {}, row.map(function (cell) {
+			if (cell !== null && typeof cell === "object" && !(cell instanceof Node)) {
+				return el("td", {"data-sort": String(cell.sort)}, [cell.node || String(cell.text)]);
+			}
+			return el("td", {}, [cell === null || cell === undefined ? "" : cell instanceof Node ? cell : String(cell)]);
+		})));
+	});
+	var result = el("table", {"class": "sortable"}, [el("thead", {}, [el("tr", {}, headers.map(function (header) { return el("th", {text: header}); }))]), tbody]);
+	if (id) {
+		result.id = id;
+	}
+	result.querySelectorAll("th").forEach(function (th, column) {
+		var ascending = true;
+		th.addEventListener("click", function () {
+			var sorted = Array.prototype.slice.call(tbody.rows);
+			var value = function (row) {
+				var cell = row.cells[column];
+				return cell.hasAttribute("data-sort") ? cell.getAttribute("data-sort") : cell.textContent.trim().toLowerCase();
+			};
+			sorted.sort(function (a, b) {
+				var x = value(a), y = value(b);
+				var n = parseFloat(x) - parseFloat(y);
+				var result = isNaN(n) ? x.localeCompare(y) : n;
+				return ascending ? result : -result;
+			});
+			ascending = !ascending;
+			sorted.forEach(function (row) { tbody.appendChild(row); });
+		});
+	});
+	return result;
+}
+
+function cards(values) {
+	return el("div", {"class": "cards"}, values.map(function (value) {
+		return el("div", {"class": "card"}, [el("div", {"class": "value", text: String(value[0])}), el("div", {"class": "label", text: value[1]})]);
+	}));
+}
+
+// the content security policy allows no inline styles, so widths are set through the DOM
+function bar(width) {
+	var element = el("div", {"class": "bar"});
+	element.style.width = Math.round(width) + "px";
+	return element;
+}
+
+function stagesTable(stages) {
+	var longest = Math.max.apply(null, stages.map(function (stage) { return stage.duration_seconds; }).concat([1]));
+	return table(["Stage", "Count", "Started", "Duration", ""], stages.map(function (stage) {
+		return [
+			stage.error ? el("span", {}, [stage.name + " ", badge("failed")]) : stage.name,
+			{text: stage.count, sort: stage.count},
+			datetime(stage.started),
+			{text: seconds(stage.duration_seconds), sort: stage.duration_seconds},
+			{node: bar(200 * stage.duration_seconds / longest), sort: stage.duration_seconds}
+		];
+	}));
+}
+
+// the progress of a run's stages: what each has processed and found so far, and how long the running ones have left
+function progressTable(progress) {
+	return table(["Stage", "Processed", "Found", "Rate", "Elapsed", "Left"], progress.map(function (stage) {
+		return [
+			stage.error ? el("span", {}, [stage.name + " ", badge("failed")]) : stage.running ? el("span", {}, [stage.name + " ", badge("running")]) : stage.name,
+			{text: stage.total ? stage.processed + " / " + stage.total : stage.processed, sort: stage.processed},
+			{text: stage.found, sort: stage.found},
+			{text: stage.rate ? Math.round(stage.rate) + "/s" : "", sort: stage.rate},
+			{text: seconds(stage.elapsed_seconds), sort: stage.elapsed_seconds},
+			{text: stage.running && stage.eta_seconds ? seconds(stage.eta_seconds) : "", sort: stage.eta_seconds || 0}
+		];
+	}));
+}
+
+// PAGES
+
+function programsPage() {
+	setBreadcrumbs(["Programs"]);
+	return api("/api/programs").then(function (programs) {
+		view.textContent = "";
+		if (programs.length === 0) {
+			view.appendChild(el("section", {}, [el("p", {"class": "muted", text: "No programs yet. Create one with ./WebRecon init <name> or POST /api/programs."})]));
+			return;
+		}
+		var rows = programs.map(function (program) {
+			var last = program.last_run;
+			return [
+				link("#/programs/" + encodeURIComponent(program.name), program.name),
+				{text: program.domains, sort: program.domains},
+				{text: program.runs, sort: program.runs},
+				last ? link("#/programs/" + encodeURIComponent(program.name) + "/runs/" + last.date, last.date) : "-",
+				program.active ? badge("running") : last ? badge(last.status) : "-",
+				last ? {text: last.subdomains, sort: last.subdomains} : "-",
+				last ? {text: seconds(last.duration_seconds), sort: last.duration_seconds} : "-"
+			];
+		});
+		view.appendChild(el("section", {}, [
+			el("h2", {text: "Programs (" + programs.length + ")"}),
+			table(["Program", "Domains", "Runs", "Last run", "Status", "Subdomains", "Duration"], rows)
+		]));
+	});
+}
+
+function programPage(name) {
+	setBreadcrumbs([link("#/", "Programs"), name]);
+	return api(programPath(name)).then(function (program) {
+		view.textContent = "";
+		var runs = program.runs.slice().reverse();
+		var last = runs.length ? runs[0] : null;
+		view.appendChild(cards([
+			[program.domains.length, "domains"],
+			[program.scope.length, "scope rules"],
+			[program.runs.length, "runs"],
+			[last ? last.subdomains : "-", "subdomains in the last run"]
+		]));
+		if (program.active) {
+			view.appendChild(activeRunSection(name, program.active));
+		}
+		view.appendChild(el("section", {}, [
+			el("h2", {text: "Runs"}),
+			runs.length === 0 ? el("p", {"class": "muted", text: "No runs yet."}) : table(["Run", "Status", "Started", "Finished", "Duration", "Subdomains", "Stages"], runs.map(function (run) {
+				return [
+					link("#/programs/" + encodeURIComponent(name) + "/runs/" + run.date, run.date),
+					badge(run.status),
+					datetime(run.started),
+					datetime(run.finished),
+					{text: seconds(run.duration_seconds), sort: run.duration_seconds},
+					{text: run.subdomains, sort: run.subdomains},
+					(run.stages || []).map(function (stage) { return stage.name + " " + seconds(stage.duration_seconds); }).join(", ")
+				];
+			}))
+		]));
+		view.appendChild(el("section", {}, [
+			el("h2", {text: "Inputs"}),
+			el("h3", {text: "Domains (" + program.domains.length + ")"}),
+			el("ul", {"class": "columns"}, program.domains.map(function (domain) { return el("li", {text: domain}); })),
+			el("h3", {text: "Scope rules (" + program.scope.length + ")"}),
+			el("ul", {"class": "columns"}, program.scope.map(function (rule) { return el("li", {}, [el("code", {text: rule})]); })),
+			program.ip_ranges.length ? el("h3", {text: "IP ranges (" + program.ip_ranges.length + ")"}) : null,
+			program.ip_ranges.length ? el("ul", {"class": "columns"}, program.ip_ranges.map(function (range) { return el("li", {text: range}); })) : null
+		]));
+	});
+}
+
+// the run in progress of a program, updated from its event stream until it ends
+function activeRunSection(name, active) {
+	var stages = el("div", {}, [stagesTable(active.stages)]);
+	var progress = el("div", {}, [progressTable(active.progress || [])]);
+	var log = el("pre", {"class": "log"});
+	var section = el("section", {}, [
+		el("h2", {}, ["Run in progress ", badge("running")]),
+		el("p", {"class": "muted", text: "Started " + datetime(active.started) + " with " + active.options.tools.join(", ")}),
+		stages,
+		el("h3", {text: "Progress"}),
+		progress,
+		el("h3", {text: "Events"}),
+		log
+	]);
+	var append = function (line) {
+		log.appendChild(document.createTextNode(new Date().toLocaleTimeString() + "  " + line + "\n"));
+		log.scrollTop = log.scrollHeight;
+	};
+	watchRun(name, function (name, data) {
+		if (name === "phase") {
+			append("finished " + data.phase + ": " + data.subdomains + " subdomains");
+			stages.textContent = "";
+			stages.appendChild(stagesTable(data.stages || []));
+		} else if (name === "progress") {
+			progress.textContent = "";
+			progress.appendChild(progressTable(data));
+		} else if (name === "finished") {
+			append("run " + data.run.status + (data.error ? ": " + data.error : ""));
+			// show the finished run as any other
+			setTimeout(route, 1000);
+		}
+	});
+	return section;
+}
+
+// reads a run's server-sent events. EventSource can't send the token, so the stream is read with fetch.
+function watchRun(name, handle) {
+	stream = new AbortController();
+	fetch(programPath(name) + "/runs/active/events", {headers: {"Authorization": "Bearer " + sessionStorage.getItem(tokenKey)}, signal: stream.signal}).then(function (response) {
+		if (!response.ok || !response.body) {
+			return;
+		}
+		var reader = response.body.getReader();
+		var decoder = new TextDecoder();
+		var buffer = "";
+		var read = function () {
+			return reader.read().then(function (chunk) {
+				if (chunk.done) {
+					return;
+				}
+				buffer += decoder.decode(chunk.value, {stream: true});
+				var events = buffer.split("\n\n");
+				buffer = events.pop();
+				events.forEach(function (event) {
+					var name = "", data = "";
+					event.split("\n").forEach(function (line) {
+						if (line.indexOf("event: ") === 0) {
+							name = line.slice(7);
+						} else if (line.indexOf("data: ") === 0) {
+							data += line.slice(6);
+						}
+					});
+					if (name && data) {
+						handle(name, JSON.parse(data));
+					}
+				});
+				return read();
+			});
+		};
+		return read();
+	}).catch(function () {});
+}
+
+function runPage(name, date) {
+	setBreadcrumbs([link("#/", "Programs"), link("#/programs/" + encodeURIComponent(name), name), date]);
+	return Promise.all([api(programPath(name) + "/runs/" + date), api(programPath(name) + "/runs/" + date + "/results")]).then(function (responses) {
+		var run = responses[0], results = responses[1];
+		view.textContent = "";
+		var alive = results.hosts.filter(function (host) { return host.http && host.http.status; }).length;
+		var fresh = results.hosts.filter(function (host) { return host.new; }).length;
+		var summary = [[results.hosts.length, "subdomains"]];
+		if (probed(results)) {
+			summary.push([alive, "answered HTTP"]);
+		}
+		if (results.previous_date) {
+			summary.push([fresh, "new since " + results.previous_date], [(results.removed || []).length, "gone since " + results.previous_date]);
+		}
+		summary.push([results.takeover_candidates.length, "takeover candidates"], [seconds(run.duration_seconds), "duration"]);
+		view.appendChild(el("p", {}, [badge(run.status), " Started " + datetime(run.started) + ", finished " + datetime(run.finished)]));
+		if (run.error) {
+			view.appendChild(el("p", {"class": "error", text: run.error}));
+		}
+		view.appendChild(cards(summary));
+		view.appendChild(resultsSection(results));
+		if (results.previous_date) {
+			var removed = results.removed || [];
+			view.appendChild(el("section", {}, [
+				el("h2", {text: "Gone since " + results.previous_date + " (" + removed.length + ")"}),
+				removed.length ? el("ul", {"class": "columns"}, removed.map(function (host) { return el("li", {}, [badge(host, "removed")]); })) : el("p", {"class": "muted", text: "Every host of the previous run was found again."})
+			]));
+		}
+		if (results.takeover_candidates.length) {
+			view.appendChild(el("section", {}, [
+				el("h2", {text: "Takeover candidates"}),
+				table(["Confidence", "Host", "CNAME", "Service", "Reason"], results.takeover_candidates.map(function (candidate) {
+					return [badge(candidate.confidence), candidate.host, candidate.cname, candidate.service, candidate.reason];
+				}))
+			]));
+		}
+		if (run.stages && run.stages.length) {
+			view.appendChild(el("section", {}, [el("h2", {text: "Stages"}), stagesTable(run.stages)]));
+		}
+	});
+}
+
+// whether a run probed its hosts over HTTP (-probe)
+function probed(results) {
+	return results.hosts.some(function (host) { return host.http; });
+}
+
+// the hosts of a run, searchable, with the hosts the previous run didn't find marked new
+function resultsSection(results) {
+	var withHTTP = probed(results);
+	var rows = results.hosts.map(function (host) {
+		var http = host.http && host.http.status ? host.http : null;
+		var row = [
+			host.host,
+			host.new ? badge("new") : "",
+			(host.sources || []).join(", "),
+			(host.a || []).concat(host.aaaa || []).join(" "),
+			(host.cname || []).join(" "),
+			(host.ips || []).map(function (ip) { return ip.org || ip.provider || ""; }).filter(Boolean).join(", ")
+		];
+		if (withHTTP) {
+			row.push(http ? {text: http.status, sort: http.status} : "", http ? http.title || "" : "");
+		}
+		return row;
+	});
+	var columns = ["Host", "", "Sources", "Addresses", "CNAME", "Organisation"];
+	if (withHTTP) {
+		columns.push("HTTP", "Title");
+	}
+	var hosts = table(columns, rows, "hosts");
+	var search = el("input", {"class": "filter", type: "search", placeholder: "Search hosts, addresses, titles..."});
+	var onlyNew = el("input", {type: "checkbox"});
+	var count = el("span", {"class": "muted"});
+	var filter = function () {
+		var needle = search.value.toLowerCase();
+		var shown = 0;
+		Array.prototype.forEach.call(hosts.tBodies[0].rows, function (row) {
+			var visible = row.textContent.toLowerCase().indexOf(needle) !== -1 && (!onlyNew.checked || row.cells[1].textContent !== "");
+			row.style.display = visible ? "" : "none";
+			shown += visible ? 1 : 0;
+		});
+		count.textContent = shown + " of " + rows.length + " hosts";
+	};
+	search.addEventListener("input", filter);
+	onlyNew.addEventListener("change", filter);
+	filter();
+	return el("section", {}, [
+		el("h2", {text: "Subdomains"}),
+		el("div", {"class": "toolbar"}, [search, results.previous_date ? el("label", {}, [onlyNew, " new since " + results.previous_date + " only"]) : null, count]),
+		hosts
+	]);
+}
+
+// ROUTING
+
+function route() {
+	if (stream) {
+		stream.abort();
+		stream = null;
+	}
+	if (!sessionStorage.getItem(tokenKey)) {
+		showSignIn();
+		return;
+	}
+	signOut.hidden = false;
+	var parts = location.hash.replace(/^#\/?/, "").split("/").map(decodeURIComponent);
+	var page;
+	if (parts[0] === "programs" && parts.length === 4 && parts[2] === "runs") {
+		page = runPage(parts[1], parts[3]);
+	} else if (parts[0] === "programs" && parts.length === 2) {
+		page = programPage(parts[1]);
+	} else {
+		page = programsPage();
+	}
+	page.catch(showError);
+}
+
+signOut.addEventListener("click", function () {
+	sessionStorage.removeItem(tokenKey);
+	route();
+});
+window.addEventListener("hashchange", route);
+route();
@@ -0,0 +1,437 @@
+package wrserve
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrmetrics"
+	"github.com/sammooredev/WebRecon/wrreport"
+	"github.com/sammooredev/WebRecon/wrrun"
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// API FUNCTIONS
+// ./WebRecon serve exposes programs and runs over HTTP. every request needs the API token (Authorization: Bearer <token>).
+//
+//	GET    /api/programs                              list programs
+//	POST   /api/programs                              create a program: {"name": "...", "domains": ["..."]}
+//	GET    /api/programs/<name>                       a program's domains, scope and runs
+//	PUT    /api/programs/<name>/domains               replace domains.txt with the request body
+//	GET    /api/programs/<name>/runs                  a program's runs
+//	POST   /api/programs/<name>/runs                  start a run, optionally with options: {"tools": ["subfinder"], "probe": true}
+//	GET    /api/programs/<name>/runs/active           the run in progress
+//	GET    /api/programs/<name>/runs/active/events    stream the run in progress (server-sent events)
+//	DELETE /api/programs/<name>/runs/active           cancel the run in progress
+//	GET    /api/programs/<name>/runs/<date>           a run's manifest
+//	GET    /api/programs/<name>/runs/<date>/results   a run's results (?format=json|csv|md, default json)
+//	GET    /api/runs                                  every run in progress
+//
+// errors are returned as {"error": "..."}. /metrics serves the metrics in the Prometheus text format (see wrmetrics), with the
+// same token, and every other path serves the web UI (see ui.go).
+
+// the largest request body accepted, e.g. an uploaded domains.txt
+const maxBodySize = 10 << 20
+
+// how often an idle event stream is sent a comment, so proxies don't close it
+var streamKeepAlive = 15 * time.Second
+
+// Server is the API server.
+type Server struct {
+	token string
+	runs  *RunManager
+}
+
+// returns an API server requiring token, starting runs with runs
+func NewServer(token string, runs *RunManager) *Server {
+	return &Server{token: token, runs: runs}
+}
+
+// apiError is an error with the HTTP status it is returned with.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// returns an error which is returned to the client with status
+func errorStatus(status int, format string, args ...interface{}) error {
+	return &apiError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// writes value as JSON with status
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// writes an error as JSON, with its status if it has one and 500 otherwise
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var api_error *apiError
+	if errors.As(err, &api_error) {
+		status = api_error.status
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// returns whether a request carries the API token
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// returns a handler which passes the requests carrying the API token to handler
+func (s *Server) requireToken(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="WebRecon"`)
+			writeError(w, errorStatus(http.StatusUnauthorized, "missing or invalid API token"))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP checks the request's token and routes it
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requireToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		if err := s.route(w, r); err != nil {
+			writeError(w, err)
+		}
+	})).ServeHTTP(w, r)
+}
+
+// routes a request by its path and method
+func (s *Server) route(w http.ResponseWriter, r *http.Request) error {
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api"), "/"), "/")
+	not_allowed := errorStatus(http.StatusMethodNotAllowed, "method %s not allowed on %s", r.Method, r.URL.Path)
+	switch {
+	case len(path) == 1 && path[0] == "runs":
+		if r.Method != http.MethodGet {
+			return not_allowed
+		}
+		writeJSON(w, http.StatusOK, s.runs.ActiveRuns())
+		return nil
+	case len(path) == 1 && path[0] == "programs":
+		switch r.Method {
+		case http.MethodGet:
+			return s.listPrograms(w)
+		case http.MethodPost:
+			return s.createProgram(w, r)
+		}
+		return not_allowed
+	case len(path) < 2 || path[0] != "programs":
+		return errorStatus(http.StatusNotFound, "no such endpoint %s", r.URL.Path)
+	}
+
+	program_name := path[1]
+	if !wrutils.ProgramExists(program_name) {
+		return errorStatus(http.StatusNotFound, "program %s does not exist", program_name)
+	}
+	route := strings.Join(path[2:], "/")
+	switch {
+	case route == "" && r.Method == http.MethodGet:
+		return s.showProgram(w, program_name)
+	case route == "domains" && r.Method == http.MethodPut:
+		return s.uploadDomains(w, r, program_name)
+	case route == "runs" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, wrutils.ListRuns(program_name))
+		return nil
+	case route == "runs" && r.Method == http.MethodPost:
+		return s.startRun(w, r, program_name)
+	case route == "runs/active" && r.Method == http.MethodGet:
+		run, ok := s.runs.Active(program_name)
+		if !ok {
+			return errorStatus(http.StatusNotFound, "%s has no run in progress", program_name)
+		}
+		writeJSON(w, http.StatusOK, run.snapshot())
+		return nil
+	case route == "runs/active" && r.Method == http.MethodDelete:
+		if !s.runs.Cancel(program_name) {
+			return errorStatus(http.StatusNotFound, "%s has no run in progress", program_name)
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "cancelling"})
+		return nil
+	case route == "runs/active/events" && r.Method == http.MethodGet:
+		return s.streamRun(w, r, program_name)
+	case len(path) == 4 && path[2] == "runs" && r.Method == http.MethodGet:
+		return s.showRun(w, program_name, path[3])
+	case len(path) == 5 && path[2] == "runs" && path[4] == "results" && r.Method == http.MethodGet:
+		return s.runResults(w, r, program_name, path[3])
+	}
+	return errorStatus(http.StatusNotFound, "no such endpoint %s %s", r.Method, r.URL.Path)
+}
+
+// ProgramSummary is a program in the program list.
+type ProgramSummary struct {
+	Name    string           `json:"name"`
+	Domains int              `json:"domains"`
+	Runs    int              `json:"runs"`
+	LastRun *wrutils.RunInfo `json:"last_run,omitempty"`
+	Active  bool             `json:"active"`
+}
+
+// ProgramDetail is a program, its inputs and its runs.
+type ProgramDetail struct {
+	Name     string            `json:"name"`
+	Domains  []string          `json:"domains"`
+	Scope    []string          `json:"scope"`
+	IPRanges []string          `json:"ip_ranges"`
+	Runs     []wrutils.RunInfo `json:"runs"`
+	Active   *ActiveRun        `json:"active,omitempty"`
+}
+
+// returns the non-empty lines of a file in recon-data which aren't comments
+func readReconData(program_name string, name string) []string {
+	lines := []string{}
+	for _, line := range wrutils.WordlistToArray(wrutils.ReconDataDirectory(program_name) + name) {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (s *Server) listPrograms(w http.ResponseWriter) error {
+	programs := []ProgramSummary{}
+	for _, program_name := range wrutils.ListPrograms() {
+		summary := ProgramSummary{Name: program_name, Domains: len(readReconData(program_name, "domains.txt"))}
+		runs := wrutils.ListRuns(program_name)
+		summary.Runs = len(runs)
+		if len(runs) > 0 {
+			summary.LastRun = &runs[len(runs)-1]
+		}
+		_, summary.Active = s.runs.Active(program_name)
+		programs = append(programs, summary)
+	}
+	writeJSON(w, http.StatusOK, programs)
+	return nil
+}
+
+func (s *Server) showProgram(w http.ResponseWriter, program_name string) error {
+	detail := ProgramDetail{
+		Name:     program_name,
+		Domains:  readReconData(program_name, "domains.txt"),
+		Scope:    readReconData(program_name, "scope.txt"),
+		IPRanges: readReconData(program_name, "ips.txt"),
+		Runs:     wrutils.ListRuns(program_name),
+	}
+	if run, ok := s.runs.Active(program_name); ok {
+		detail.Active = run.snapshot()
+	}
+	writeJSON(w, http.StatusOK, detail)
+	return nil
+}
+
+// normalizes a domains list, returning the invalid entries as a 400 error
+func normalizeDomains(lines []string) ([]string, error) {
+	domains, invalid := wrutils.NormalizeDomainsList(lines)
+	if len(invalid) > 0 {
+		var messages []string
+		for _, e := range invalid {
+			messages = append(messages, e.Error())
+		}
+		return nil, errorStatus(http.StatusBadRequest, "invalid domains: %s", strings.Join(messages, "; "))
+	}
+	return domains, nil
+}
+
+func (s *Server) createProgram(w http.ResponseWriter, r *http.Request) error {
+	var request struct {
+		Name    string   `json:"name"`
+		Domains []string `json:"domains"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return errorStatus(http.StatusBadRequest, "invalid request body - %v", err)
+	}
+	if !wrutils.ValidProgramName(request.Name) {
+		return errorStatus(http.StatusBadRequest, "invalid program name %q. Use letters, numbers, '.', '_' and '-'", request.Name)
+	}
+	if wrutils.ProgramExists(request.Name) {
+		return errorStatus(http.StatusConflict, "program %s already exists", request.Name)
+	}
+	domains, err := normalizeDomains(request.Domains)
+	if err != nil {
+		return err
+	}
+	if err := wrutils.CreateProgram(request.Name); err != nil {
+		return err
+	}
+	if err := wrutils.WriteProgramDomains(request.Name, domains); err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/api/programs/"+request.Name)
+	writeJSON(w, http.StatusCreated, ProgramDetail{Name: request.Name, Domains: domains, Scope: []string{}, IPRanges: []string{}, Runs: []wrutils.RunInfo{}})
+	return nil
+}
+
+func (s *Server) uploadDomains(w http.ResponseWriter, r *http.Request, program_name string) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return errorStatus(http.StatusBadRequest, "could not read the request body - %v", err)
+	}
+	domains, err := normalizeDomains(strings.Split(string(body), "\n"))
+	if err != nil {
+		return err
+	}
+	if len(domains) == 0 {
+		return errorStatus(http.StatusBadRequest, "the domains list has no domains")
+	}
+	if err := wrutils.WriteProgramDomains(program_name, domains); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"domains": domains})
+	return nil
+}
+
+func (s *Server) startRun(w http.ResponseWriter, r *http.Request, program_name string) error {
+	opts := wrrun.DefaultOptions(program_name)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return errorStatus(http.StatusBadRequest, "could not read the request body - %v", err)
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &opts); err != nil {
+			return errorStatus(http.StatusBadRequest, "invalid run options - %v", err)
+		}
+	}
+	opts.Program = program_name
+	if err := opts.Validate(); err != nil {
+		return errorStatus(http.StatusBadRequest, "%v", err)
+	}
+	run, err := s.runs.Start(opts)
+	if errors.Is(err, ErrRunActive) || errors.Is(err, wrutils.ErrProgramLocked) {
+		return errorStatus(http.StatusConflict, "%v", err)
+	} else if err != nil {
+		return err
+	}
+	w.Header().Set("Location", "/api/programs/"+program_name+"/runs/active")
+	writeJSON(w, http.StatusAccepted, run.snapshot())
+	return nil
+}
+
+// streams a run's events as server-sent events until it finishes or the client goes away. events from before the
+// client connected are sent first.
+func (s *Server) streamRun(w http.ResponseWriter, r *http.Request, program_name string) error {
+	run, ok := s.runs.Active(program_name)
+	if !ok {
+		return errorStatus(http.StatusNotFound, "%s has no run in progress", program_name)
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming is not supported")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(event StreamEvent) {
+		b, _ := json.Marshal(event.Data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, b)
+		flusher.Flush()
+	}
+	past, subscriber := run.subscribe()
+	defer run.unsubscribe(subscriber)
+	write(StreamEvent{Name: "started", Data: run.snapshot()})
+	for _, event := range past {
+		write(event)
+	}
+	keep_alive := time.NewTicker(streamKeepAlive)
+	defer keep_alive.Stop()
+	for {
+		select {
+		case event, ok := <-subscriber:
+			if !ok {
+				return nil
+			}
+			write(event)
+		case <-keep_alive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+// returns an error unless a run directory exists
+func checkRun(program_name string, date string) error {
+	if _, err := time.Parse(wrutils.RunDateFormat, date); err != nil {
+		return errorStatus(http.StatusNotFound, "no such run %s, expected a date such as %s", date, time.Now().Format(wrutils.RunDateFormat))
+	}
+	if _, err := os.Stat(wrutils.RunDirectory(program_name, date)); err != nil {
+		return errorStatus(http.StatusNotFound, "%s has no run %s", program_name, date)
+	}
+	return nil
+}
+
+func (s *Server) showRun(w http.ResponseWriter, program_name string, date string) error {
+	if err := checkRun(program_name, date); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, wrutils.ReadRunInfo(program_name, date))
+	return nil
+}
+
+func (s *Server) runResults(w http.ResponseWriter, r *http.Request, program_name string, date string) error {
+	if err := checkRun(program_name, date); err != nil {
+		return err
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	content_types := map[string]string{"json": "application/json", "csv": "text/csv; charset=utf-8", "md": "text/markdown; charset=utf-8"}
+	if _, ok := content_types[format]; !ok {
+		return errorStatus(http.StatusBadRequest, "unknown format %s, expected one of %s", format, strings.Join(wrreport.ExportFormats, ", "))
+	}
+	report, err := wrreport.BuildRunReport(program_name, date)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", content_types[format])
+	return wrreport.WriteExport(report, format, w)
+}
+
+// function to serve the API on address until ctx is cancelled, cancelling the runs in progress before returning
+func Serve(ctx context.Context, address string, server *Server) error {
+	mux := http.NewServeMux()
+	mux.Handle("/api/", server)
+	mux.Handle("/metrics", server.requireToken(wrmetrics.Handler()))
+	mux.Handle("/", UIHandler())
+	http_server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 30 * time.Second,
+		// requests (and event streams) end when the server stops
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- http_server.ListenAndServe() }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	http_server.Shutdown(shutdown)
+	server.runs.Wait()
+	return nil
+}
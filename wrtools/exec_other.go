@@ -0,0 +1,19 @@
+//go:build !unix
+
+package wrtools
+
+import (
+	"os/exec"
+)
+
+// process groups are unix only; cancelling a command elsewhere only stops bash itself
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// there is no SIGKILL timer outside unix
+func stopKillTimer(cmd *exec.Cmd) {
+}
+
+// bash's children are left to exit on their own
+func killProcessGroup(cmd *exec.Cmd) {
+}
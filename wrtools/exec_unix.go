@@ -0,0 +1,48 @@
+//go:build unix
+
+package wrtools
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// the timers sending SIGKILL to the process groups of cancelled commands, by command. a timer is stopped once its command
+// has been waited for, so it never fires at a process group whose ID has since been reused.
+var (
+	kill_timers_mutex sync.Mutex
+	kill_timers       = make(map[*exec.Cmd]*time.Timer)
+)
+
+// runs a command in its own process group, so cancelling it also stops the tools bash started. the group is sent SIGTERM
+// first, so the tools can write out what they have, and SIGKILL once killGrace has passed.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		group := -cmd.Process.Pid
+		kill_timers_mutex.Lock()
+		kill_timers[cmd] = time.AfterFunc(killGrace, func() { syscall.Kill(group, syscall.SIGKILL) })
+		kill_timers_mutex.Unlock()
+		return syscall.Kill(group, syscall.SIGTERM)
+	}
+	// the pipes of a tool which outlives the grace period are closed shortly after it is killed
+	cmd.WaitDelay = killGrace + 5*time.Second
+}
+
+// function to stop the SIGKILL timer of a command which has been waited for, if it was cancelled
+func stopKillTimer(cmd *exec.Cmd) {
+	kill_timers_mutex.Lock()
+	defer kill_timers_mutex.Unlock()
+	if timer, ok := kill_timers[cmd]; ok {
+		timer.Stop()
+		delete(kill_timers, cmd)
+	}
+}
+
+// function to kill whatever is left of a stopped command's process group once bash has exited, such as a tool's children
+// which ignored SIGTERM
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
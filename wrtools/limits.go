@@ -0,0 +1,212 @@
+package wrtools
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// QUERY LIMITS
+// the DNS queries of a run are limited to -qps across every resolver, -resolver-qps for each of them, and -query-budget
+// for the whole run. the PTR sweep, which sends its own queries, waits its turn for each one. puredns only takes a rate
+// across all its resolvers, so it is passed the rate the limits allow together, -resolver-qps times the number of
+// resolvers at most, and a resolver it happens to pick more often than the others may be sent more than -resolver-qps. the budget counts a query for each candidate a resolution round is given (puredns's retries
+// and wildcard tests come on top) and for each lookup of the PTR sweep. once it is spent, the candidates which don't fit are
+// left out and kept in <candidates>.over-budget, or the run is stopped, as -budget-exhausted says.
+
+// ErrQueryBudgetExhausted is returned by a stage which needs more queries than the budget has left, when the run is
+// stopped rather than truncated.
+var ErrQueryBudgetExhausted = errors.New("the query budget is exhausted")
+
+// Limits are the DNS query limits of a run, and the queries it has sent. every method may be called on nil limits, which
+// limit nothing.
+type Limits struct {
+	qps          int
+	resolver_qps int
+	budget       int
+	abort        bool
+	global       *rateLimiter
+	mutex        sync.Mutex
+	resolvers    map[string]*rateLimiter
+	used         int
+	truncated    []string
+}
+
+// returns limits of qps queries per second in total and resolver_qps to each resolver, and a budget of queries, where 0
+// is no limit. abort is whether a stage which needs more than the budget has left fails rather than being truncated.
+func NewLimits(qps int, resolver_qps int, budget int, abort bool) *Limits {
+	return &Limits{qps: qps, resolver_qps: resolver_qps, budget: budget, abort: abort, global: newRateLimiter(qps), resolvers: make(map[string]*rateLimiter)}
+}
+
+// returns the total rate the limits allow across the given number of resolvers, or 0 if there is no limit
+func (l *Limits) Rate(resolvers int) int {
+	if l == nil {
+		return 0
+	}
+	rate := l.qps
+	if l.resolver_qps > 0 && resolvers > 0 && (rate == 0 || l.resolver_qps*resolvers < rate) {
+		rate = l.resolver_qps * resolvers
+	}
+	return rate
+}
+
+// returns the rate puredns validates its results at with the trusted resolvers. there are only a handful of them, so
+// their rate is capped by both limits.
+func (l *Limits) TrustedRate() int {
+	rate := PurednsTrustedRateLimit
+	if l != nil && l.qps > 0 && l.qps < rate {
+		rate = l.qps
+	}
+	if l != nil && l.resolver_qps > 0 && l.resolver_qps < rate {
+		rate = l.resolver_qps
+	}
+	return rate
+}
+
+// function to take n queries for stage from the budget. returns how many of them may be sent, which is fewer than n when
+// the budget runs out and the run is truncated, or ErrQueryBudgetExhausted when it is stopped instead.
+func (l *Limits) Reserve(stage string, n int) (int, error) {
+	if l == nil {
+		return n, nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	remaining := l.budget - l.used
+	if l.budget == 0 || n <= remaining {
+		l.used += n
+		return n, nil
+	}
+	if l.abort {
+		return 0, fmt.Errorf("%w: %s needs %d queries, %d of %d are left", ErrQueryBudgetExhausted, stage, n, remaining, l.budget)
+	}
+	l.used = l.budget
+	if !wrutils.SliceContainsString(l.truncated, stage) {
+		l.truncated = append(l.truncated, stage)
+	}
+	return remaining, nil
+}
+
+// function to wait until a query may be sent to nameserver. returns ctx's error if it is cancelled first.
+func (l *Limits) Wait(ctx context.Context, nameserver string) error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	resolver, ok := l.resolvers[nameserver]
+	if !ok {
+		resolver = newRateLimiter(l.resolver_qps)
+		l.resolvers[nameserver] = resolver
+	}
+	l.mutex.Unlock()
+	if err := l.global.wait(ctx); err != nil {
+		return err
+	}
+	return resolver.wait(ctx)
+}
+
+// returns the budget and the queries used so far, and the stages which were truncated, for the run's manifest
+func (l *Limits) Usage() *wrutils.QueryUsage {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return &wrutils.QueryUsage{Budget: l.budget, Used: l.used, Truncated: append([]string{}, l.truncated...)}
+}
+
+// rateLimiter spaces out events to a rate per second. a nil limiter doesn't wait.
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// returns a limiter of rate events per second, or nil for no limit
+func newRateLimiter(rate int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(rate)}
+}
+
+// function to wait for the next event's turn
+func (r *rateLimiter) wait(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	delay := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mutex.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// returns puredns's rate limit flags for the limits. -resolver-qps becomes a limit across every resolver in
+// ./wordlists/resolvers.txt, as puredns doesn't limit each of them.
+func purednsRateFlags(limits *Limits) string {
+	flags := ""
+	if rate := limits.Rate(len(ReadNameservers())); rate > 0 {
+		flags = "--rate-limit " + strconv.Itoa(rate) + " "
+	}
+	return flags + "--rate-limit-trusted " + strconv.Itoa(limits.TrustedRate())
+}
+
+// function to keep only the first keep lines of a file of candidates. the rest are moved to <path>.over-budget.
+func truncateCandidates(path string, keep int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	kept, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer kept.Close()
+	over, err := os.Create(path + ".over-budget")
+	if err != nil {
+		return err
+	}
+	defer over.Close()
+	kept_writer, over_writer := bufio.NewWriter(kept), bufio.NewWriter(over)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 0; scanner.Scan(); line++ {
+		if line < keep {
+			kept_writer.WriteString(scanner.Text() + "\n")
+		} else {
+			over_writer.WriteString(scanner.Text() + "\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := kept_writer.Flush(); err != nil {
+		return err
+	}
+	if err := over_writer.Flush(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
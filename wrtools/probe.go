@@ -0,0 +1,121 @@
+package wrtools
+
+import (
+	"context"
+	"crypto/tls"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrprogress"
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// number of concurrent HTTP probes, the timeout of each request and how much of the body is read looking for a <title>
+const probeWorkers = 50
+const probeTimeout = 10 * time.Second
+const probeBodyLimit = 64 * 1024
+
+var titleRegex = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// returns an HTTP client which doesn't follow redirects or verify certificates, so every host reports its own response
+func newProbeClient() *http.Client {
+	return &http.Client{
+		Timeout: probeTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+			MaxIdleConnsPerHost: 1,
+			DisableKeepAlives:   true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// probes a host over https://, falling back to http://
+func probeHost(ctx context.Context, client *http.Client, host string) *wrutils.HTTPInfo {
+	var last_error error
+	for _, scheme := range []string{"https://", "http://"} {
+		url := scheme + host + "/"
+		request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return &wrutils.HTTPInfo{Error: err.Error()}
+		}
+		request.Header.Set("User-Agent", "Mozilla/5.0 (compatible; WebRecon)")
+		response, err := client.Do(request)
+		if err != nil {
+			last_error = err
+			continue
+		}
+		body, _ := io.ReadAll(io.LimitReader(response.Body, probeBodyLimit))
+		response.Body.Close()
+		info := &wrutils.HTTPInfo{URL: url, Status: response.StatusCode, Server: response.Header.Get("Server"), Location: response.Header.Get("Location")}
+		if match := titleRegex.FindSubmatch(body); match != nil {
+			info.Title = strings.Join(strings.Fields(html.UnescapeString(string(match[1]))), " ")
+		}
+		return info
+	}
+	return &wrutils.HTTPInfo{Error: last_error.Error()}
+}
+
+// function to probe every resolved host over HTTP, storing the responses in the records. returns the number of hosts that answered.
+func RunHTTPProbe(ctx context.Context, program_name string, records []wrutils.HostRecord, stage *wrprogress.Stage) (int, error) {
+	logger := wrlog.FromContext(ctx)
+
+	start := time.Now()
+	client := newProbeClient()
+	total := 0
+	for _, record := range records {
+		if len(record.Addresses()) > 0 {
+			total += 1
+		}
+	}
+	stage.SetTotal(total)
+	logger.Info("Executing HTTP probe", "hosts", total)
+	indexes := make(chan int, probeWorkers)
+	var wg2 sync.WaitGroup
+	var mute sync.Mutex
+	alive := 0
+	for worker := 0; worker < probeWorkers; worker++ {
+		wg2.Add(1)
+		go func() {
+			defer wg2.Done()
+			for index := range indexes {
+				info := probeHost(ctx, client, records[index].Host)
+				records[index].HTTP = info
+				stage.AddProcessed(1)
+				if info.Status != 0 {
+					stage.AddFound(1)
+					mute.Lock()
+					alive += 1
+					mute.Unlock()
+				}
+			}
+		}()
+	}
+probe:
+	for index, record := range records {
+		if len(record.Addresses()) == 0 {
+			continue
+		}
+		select {
+		case indexes <- index:
+		case <-ctx.Done():
+			break probe
+		}
+	}
+	close(indexes)
+	wg2.Wait()
+	if ctx.Err() != nil {
+		return alive, ctx.Err()
+	}
+
+	logger.Info("HTTP probe complete", "answered", alive, "duration", time.Since(start))
+	return alive, nil
+}
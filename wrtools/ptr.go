@@ -0,0 +1,217 @@
+package wrtools
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrmetrics"
+	"github.com/sammooredev/WebRecon/wrprogress"
+	"github.com/sammooredev/WebRecon/wrutils"
+)
+
+// number of concurrent PTR lookups, the timeout of each lookup and how many times a failed lookup is retried on another resolver
+const ptrWorkers = 200
+const ptrTimeout = 3 * time.Second
+const ptrRetries = 2
+
+// Resolver sends every query to a single nameserver.
+type Resolver struct {
+	*net.Resolver
+	// the nameserver's address, as it appears in resolvers.txt
+	Nameserver string
+}
+
+// returns a resolver which sends every query to the given nameserver
+func newResolver(nameserver string) Resolver {
+	address := nameserver
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "53")
+	}
+	return Resolver{
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				dialer := net.Dialer{Timeout: ptrTimeout}
+				return dialer.DialContext(ctx, "udp", address)
+			},
+		},
+		Nameserver: nameserver,
+	}
+}
+
+// returns the nameservers in ./wordlists/resolvers.txt
+func ReadNameservers() []string {
+	var nameservers []string
+	seen := make(map[string]bool)
+	for _, nameserver := range wrutils.WordlistToArray(ResolversFile) {
+		nameserver = strings.TrimSpace(nameserver)
+		if nameserver != "" && !strings.HasPrefix(nameserver, "#") && !seen[nameserver] {
+			seen[nameserver] = true
+			nameservers = append(nameservers, nameserver)
+		}
+	}
+	return nameservers
+}
+
+// function to load a resolver for each nameserver in ./wordlists/resolvers.txt
+func LoadResolvers() ([]Resolver, error) {
+	var resolvers []Resolver
+	for _, nameserver := range ReadNameservers() {
+		resolvers = append(resolvers, newResolver(nameserver))
+	}
+	if len(resolvers) == 0 {
+		return nil, errors.New("no resolvers found in " + ResolversFile)
+	}
+	return resolvers, nil
+}
+
+// returned by lookupPTR when the query budget is spent and the run is truncated rather than stopped
+var errBudgetSpent = errors.New("the query budget is spent")
+
+// looks up the PTR records of an address within limits, retrying on a different resolver when a lookup times out or fails
+func lookupPTR(ctx context.Context, resolvers []Resolver, limits *Limits, addr netip.Addr, attempt int) ([]string, error) {
+	var names []string
+	for try := 0; try <= ptrRetries; try++ {
+		resolver := resolvers[(attempt+try)%len(resolvers)]
+		allowed, err := limits.Reserve("ptr-sweep", 1)
+		if err != nil {
+			return names, err
+		} else if allowed == 0 {
+			return names, errBudgetSpent
+		}
+		if err := limits.Wait(ctx, resolver.Nameserver); err != nil {
+			return names, err
+		}
+		lookup_ctx, cancel := context.WithTimeout(ctx, ptrTimeout)
+		result, err := resolver.LookupAddr(lookup_ctx, addr.String())
+		cancel()
+		if ctx.Err() != nil {
+			break
+		}
+		var dns_error *net.DNSError
+		not_found := errors.As(err, &dns_error) && dns_error.IsNotFound
+		wrmetrics.ObserveResolver(resolver.Nameserver, err == nil || not_found)
+		if err == nil {
+			names = result
+			break
+		}
+		if not_found {
+			break
+		}
+	}
+	return names, nil
+}
+
+// function to sweep IP ranges with reverse DNS lookups within limits. hostnames which are in scope are written to ptr-sweep.out,
+// and "<ip> <hostname>" pairs to ptr-records.out. the sweep stops early when the query budget is spent. returns the number of
+// in scope hostnames found.
+func RunPTRSweep(ctx context.Context, program_name string, date string, prefixes []netip.Prefix, scope *wrutils.Scope, limits *Limits, stage *wrprogress.Stage) (int, error) {
+	logger := wrlog.FromContext(ctx)
+	logger.Info("Executing PTR sweep", "ranges", len(prefixes))
+
+	start := time.Now()
+	program_path := "./Programs/" + program_name + "/" + date + "/"
+	// the ranges were limited to -ptr-max addresses when they were read, so their sizes fit
+	total := 0
+	for _, prefix := range prefixes {
+		total += 1 << (prefix.Addr().BitLen() - prefix.Bits())
+	}
+	stage.SetTotal(total)
+	resolvers, err := LoadResolvers()
+	if err != nil {
+		return 0, err
+	}
+	hosts_file, err := os.Create(program_path + "ptr-sweep.out")
+	if err != nil {
+		return 0, err
+	}
+	defer hosts_file.Close()
+	records_file, err := os.Create(program_path + "ptr-records.out")
+	if err != nil {
+		return 0, err
+	}
+	defer records_file.Close()
+	hosts_writer := bufio.NewWriter(hosts_file)
+	records_writer := bufio.NewWriter(records_file)
+
+	addresses := make(chan netip.Addr, ptrWorkers)
+	var mute sync.Mutex
+	var wg2 sync.WaitGroup
+	queried, found, out_of_scope := 0, 0, 0
+	seen := make(map[string]bool)
+	// the sweep stops early when the query budget runs out
+	sweep_ctx, stop_sweep := context.WithCancel(ctx)
+	defer stop_sweep()
+	var sweep_error error
+
+	for worker := 0; worker < ptrWorkers; worker++ {
+		wg2.Add(1)
+		go func(worker int) {
+			defer wg2.Done()
+			attempt := worker
+			for addr := range addresses {
+				if sweep_ctx.Err() != nil {
+					continue
+				}
+				attempt += 1
+				names, err := lookupPTR(sweep_ctx, resolvers, limits, addr, attempt)
+				mute.Lock()
+				if err != nil && sweep_error == nil && ctx.Err() == nil {
+					sweep_error = err
+					stop_sweep()
+				}
+				queried += 1
+				stage.AddProcessed(1)
+				for _, name := range names {
+					name = strings.TrimSuffix(strings.ToLower(name), ".")
+					if !scope.InScope(name) {
+						out_of_scope += 1
+						continue
+					}
+					records_writer.WriteString(addr.String() + " " + name + "\n")
+					if !seen[name] {
+						seen[name] = true
+						found += 1
+						stage.AddFound(1)
+						hosts_writer.WriteString(name + "\n")
+					}
+				}
+				mute.Unlock()
+			}
+		}(worker)
+	}
+
+sweep:
+	for _, prefix := range prefixes {
+		for addr := prefix.Addr(); addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+			select {
+			case addresses <- addr:
+			case <-sweep_ctx.Done():
+				break sweep
+			}
+		}
+	}
+	close(addresses)
+	wg2.Wait()
+
+	hosts_writer.Flush()
+	records_writer.Flush()
+	if ctx.Err() != nil {
+		return found, ctx.Err()
+	}
+	if errors.Is(sweep_error, errBudgetSpent) {
+		logger.Warn("Query budget spent, stopped the PTR sweep early", "addresses", queried, "of", total)
+	} else if sweep_error != nil {
+		return found, sweep_error
+	}
+	logger.Info("PTR sweep complete", "addresses", queried, "hostnames", found, "out_of_scope", out_of_scope, "duration", time.Since(start))
+	return found, nil
+}
@@ -2,50 +2,155 @@ package wrtools
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/sammooredev/WebRecon/wrlog"
+	"github.com/sammooredev/WebRecon/wrmetrics"
+	"github.com/sammooredev/WebRecon/wrprogress"
 	"github.com/sammooredev/WebRecon/wrutils"
-
-	"github.com/DrSmithFr/go-console/pkg/input"
-	"github.com/DrSmithFr/go-console/pkg/output"
-	"github.com/DrSmithFr/go-console/pkg/style"
 )
 
+// the wordlist the subdomain generator prefixes the domains with, and the resolvers puredns and the PTR sweep query
+const SubdomainWordlist = "./wordlists/httparchive_subdomains_2022_12_28.txt"
+const ResolversFile = "./wordlists/resolvers.txt"
+
+// how long a tool is given to print its version, and how much of what it prints is kept. a tool which doesn't know
+// --version may print its whole usage instead.
+const versionTimeout = 10 * time.Second
+const versionMaxLength = 2048
+
+// matches the colour codes some tools print even when they aren't writing to a terminal
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// function to look up each tool in the PATH and ask it for its version with --version. tools which aren't found, or don't
+// print anything, are returned with an error.
+func ToolVersions(ctx context.Context, tools []string) []wrutils.ToolInfo {
+	infos := make([]wrutils.ToolInfo, len(tools))
+	var wg sync.WaitGroup
+	for index, tool := range tools {
+		infos[index].Name = tool
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			infos[index].Error = err.Error()
+			continue
+		}
+		infos[index].Path = path
+		wg.Add(1)
+		go func(info *wrutils.ToolInfo) {
+			defer wg.Done()
+			version_ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+			defer cancel()
+			cmd := exec.CommandContext(version_ctx, info.Path, "--version")
+			// a tool which hangs may have started processes which keep its output open after it is killed
+			cmd.WaitDelay = time.Second
+			// some tools print their version and exit non-zero, so the output is what counts
+			output, err := cmd.CombinedOutput()
+			info.Version = strings.TrimSpace(ansiRegex.ReplaceAllString(string(output), ""))
+			if len(info.Version) > versionMaxLength {
+				info.Version = strings.ToValidUTF8(info.Version[:versionMaxLength], "") + "..."
+			}
+			if info.Version == "" && err != nil {
+				info.Error = err.Error()
+			}
+		}(&infos[index])
+	}
+	wg.Wait()
+	return infos
+}
+
+// how long the tools of a cancelled command have to write out their results before they are killed
+var killGrace = 30 * time.Second
+
+// returns a command running script with bash, which is stopped along with everything it started when ctx is cancelled.
+// the command line is logged, and so is everything the command writes to stderr, at debug level.
+func bashCommand(ctx context.Context, script string) *exec.Cmd {
+	logger := wrlog.FromContext(ctx).With("tool", commandTool(script))
+	logger.Debug("Running command", "command", script)
+	cmd := exec.CommandContext(ctx, "bash", "-c", script)
+	cmd.Stderr = wrlog.LineWriter(logger, slog.LevelDebug, "Command output")
+	setProcessGroup(cmd)
+	return cmd
+}
+
+// returns the tool a script runs
+func commandTool(script string) string {
+	if fields := strings.Fields(script); len(fields) > 0 {
+		return fields[0]
+	}
+	return "bash"
+}
+
+// waits for a command started with bashCommand. a command killed because ctx was cancelled returns ctx's error.
+func waitCommand(ctx context.Context, cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	stopKillTimer(cmd)
+	if ctx.Err() != nil {
+		killProcessGroup(cmd)
+		return ctx.Err()
+	}
+	// the tools exit non-zero on recoverable problems (a failed data source, ...), so only failing to run them is an error
+	var exit_error *exec.ExitError
+	if errors.As(err, &exit_error) {
+		wrlog.FromContext(ctx).Warn("Command exited with an error status, keeping its output", "tool", commandTool(cmd.Args[len(cmd.Args)-1]), "exit_code", exit_error.ExitCode())
+		return nil
+	}
+	return err
+}
+
+// function to write the names a tool printed before it was stopped to its output file, if it hadn't written them itself.
+// the tools write their output files as they see fit, some only once they finish, so a stopped tool's file may be missing
+// names or not exist.
+func keepPartialOutput(ctx context.Context, path string, lines []string) {
+	if len(lines) <= wrutils.CountLines(path) {
+		return
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		wrlog.FromContext(ctx).Error("Could not keep the partial output of a stopped tool", "path", path, "error", err)
+		return
+	}
+	wrlog.FromContext(ctx).Info("Kept the partial output of a stopped tool", "path", path, "lines", len(lines))
+}
+
 // TODO: rethink data structures
-// function to generate potential subdomains using a list of publicly sourced subdomain names
-func PotentialSubdomainGeneratorMain(domains []string, program string, date string, wg *sync.WaitGroup, mute *sync.Mutex) {
-	// cmd output styling
-	in := input.NewArgvInput(nil)
-	out := output.NewConsoleOutput(true, nil)
-	io := style.NewGoStyler(in, out)
-	wordlist_array := wrutils.WordlistToArray("./wordlists/httparchive_subdomains_2022_12_28.txt")
+// function to generate potential subdomains using a list of publicly sourced subdomain names. returns the number of subdomains generated.
+func PotentialSubdomainGeneratorMain(ctx context.Context, domains []string, program string, date string, scope *wrutils.Scope, mute *sync.Mutex, stage *wrprogress.Stage) (int, error) {
+	logger := wrlog.FromContext(ctx)
+	wordlist_array := wrutils.WordlistToArray(SubdomainWordlist)
 
 	// split wordlist_line string array into multiple slices
 	divided := wrutils.Wordlist2DArrayGenerator(wordlist_array, 20)
 
 	// start generation
-	out.Writeln("\t<info>INFO - Generating potential subdomains from file ./wordlists/httparchive_subdomains_2022_12_28.txt</info>")
+	logger.Info("Generating potential subdomains", "wordlist", SubdomainWordlist)
 	start := time.Now()
 	programPath := "./Programs/" + program + "/" + date + "/sub-generator.out"
-	total_generated := SubdomainGenerator(domains, divided, programPath, wg, out, mute)
-	time_elapsed := time.Since(start)
-	str := fmt.Sprintf("Generating potential subdomains complete! Finished in %v, generating %d subdomains.", time_elapsed, total_generated)
-	io.Success(str)
-	wg.Done()
+	stage.SetTotal(len(domains) * len(wordlist_array))
+	total_generated, err := SubdomainGenerator(domains, divided, programPath, scope, mute, stage)
+	if err != nil {
+		return 0, err
+	}
+	logger.Info("Generating potential subdomains complete", "subdomains", total_generated, "duration", time.Since(start))
+	return total_generated, nil
 }
 
-// performs grunt work for PotentialSubdomainGeneratorMain, taking in domains, path, and 2d wordlist array,
-func SubdomainGenerator(domains []string, wordlist_2d_array [][]string, path string, wg *sync.WaitGroup, out *output.ConsoleOutput, mute *sync.Mutex) int {
+// performs grunt work for PotentialSubdomainGeneratorMain, taking in domains, path, and 2d wordlist array, skipping generated subdomains that are out of scope
+func SubdomainGenerator(domains []string, wordlist_2d_array [][]string, path string, scope *wrutils.Scope, mute *sync.Mutex, stage *wrprogress.Stage) (int, error) {
 	// subdomains_generated_count = count total number of subdomains generated, threads_count = number of threads generated.
-	var subdomains_generated_count int
-	subdomains_generated_count = 0
+	// every worker adds to it, so it is counted atomically
+	var subdomains_generated_count atomic.Int64
 	// start go routine waitgroup
 	var wg2 sync.WaitGroup
 	//create a worker for each domain in domains.txt
@@ -57,9 +162,9 @@ func SubdomainGenerator(domains []string, wordlist_2d_array [][]string, path str
 	//create output file
 	output_file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		out.Writeln("<error>ERROR! \"</error>")
-		os.Exit(1)
+		return 0, err
 	}
+	defer output_file.Close()
 
 	for _, domain := range domains {
 		// for string arrays in divided
@@ -71,41 +176,61 @@ func SubdomainGenerator(domains []string, wordlist_2d_array [][]string, path str
 				//fmt.Println("started thread")
 				defer wg2.Done()
 				for _, line := range foo {
-					subdomains_generated_count += 1
+					if !scope.InScope(line + "." + domain) {
+						continue
+					}
+					subdomains_generated_count.Add(1)
+					stage.AddFound(1)
 
 					output_file.WriteString(line + "." + domain + "\n")
 				}
+				stage.AddProcessed(len(foo))
 			}(domain)
 		}
 	}
 	wg2.Wait()
 
-	return subdomains_generated_count
+	return int(subdomains_generated_count.Load()), nil
 }
 
-// function to run amass.
-func RunAmass(program_name string, date string, timeout int, wg *sync.WaitGroup) {
-	in := input.NewArgvInput(nil)
-	out := output.NewConsoleOutput(true, nil)
-	io := style.NewGoStyler(in, out)
-	out.Writeln("\t<info>INFO - Executing Amass against " + program_name + "</info>")
+// returns the command line RunAmass runs
+func AmassCommand(program_name string, date string, timeout int, blacklist string, passive bool) string {
+	blacklist_flag := ""
+	if blacklist != "" {
+		blacklist_flag = " -blf " + blacklist
+	}
+	if passive {
+		blacklist_flag += " -passive"
+	}
+	return "amass enum -timeout " + strconv.Itoa(timeout) + blacklist_flag + " -df ./Programs/" + program_name + "/" + date + "/domains.txt -o ./Programs/" + program_name + "/" + date + "/amass.out"
+}
+
+// function to run amass. blacklist is a file of out of scope domains passed to amass -blf, or "" for none, and passive
+// keeps amass to its data sources. returns the number of subdomains enumerated.
+func RunAmass(ctx context.Context, program_name string, date string, timeout int, blacklist string, passive bool, stage *wrprogress.Stage) (int, error) {
+	logger := wrlog.FromContext(ctx)
+	logger.Info("Executing amass", "timeout_minutes", timeout, "passive", passive)
+	start := time.Now()
 
-	cmd := exec.Command("bash", "-c", "amass enum -timeout "+strconv.Itoa(timeout)+" -df ./Programs/"+program_name+"/recon-data/domains.txt -o ./Programs/"+program_name+"/"+date+"/amass.out")
+	cmd := bashCommand(ctx, AmassCommand(program_name, date, timeout, blacklist, passive))
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
 	var wg2 sync.WaitGroup
 	wg2.Add(1)
 	count := 0
+	var lines []string
 
 	scanner := bufio.NewScanner(stdout)
 	go func() {
 		for scanner.Scan() {
 			count += 1
+			stage.AddFound(1)
+			lines = append(lines, scanner.Text())
 			if count == 1 {
-				out.Writeln("\t<info>INFO - Amass identified first subdomain for " + program_name + " successfully.</info>")
+				logger.Info("Amass identified its first subdomain")
 			}
 			// UNCOMMENT NEXT LINE TO DEBUG AMASS
 			//log.Printf(strconv.Itoa(count) + " amass out: %s", scanner.Text())
@@ -114,94 +239,323 @@ func RunAmass(program_name string, date string, timeout int, wg *sync.WaitGroup)
 	}()
 
 	if err = cmd.Start(); err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
 	wg2.Wait()
-	cmd.Wait()
-	io.Success("Amass Enumeration Complete. " + strconv.Itoa(count) + " subdomains enumerated.")
-	wg.Done()
+	if err := waitCommand(ctx, cmd); err != nil {
+		keepPartialOutput(ctx, "./Programs/"+program_name+"/"+date+"/amass.out", lines)
+		return count, err
+	}
+	logger.Info("Amass enumeration complete", "subdomains", count, "duration", time.Since(start))
+	return count, nil
+}
+
+// returns the command line RunSubfinder runs
+func SubfinderCommand(program_name string, date string) string {
+	return "subfinder -dL ./Programs/" + program_name + "/" + date + "/domains.txt -o ./Programs/" + program_name + "/" + date + "/subfinder.out"
 }
 
-// function to run subfinder.
-func RunSubfinder(program_name string, date string, wg *sync.WaitGroup) {
-	in := input.NewArgvInput(nil)
-	out := output.NewConsoleOutput(true, nil)
-	io := style.NewGoStyler(in, out)
-	out.Writeln("\t<info>INFO - Executing subfinder against " + program_name + "</info>")
+// function to run subfinder. returns the number of subdomains enumerated.
+func RunSubfinder(ctx context.Context, program_name string, date string, stage *wrprogress.Stage) (int, error) {
+	logger := wrlog.FromContext(ctx)
+	logger.Info("Executing subfinder")
 
 	start := time.Now()
-	cmd := exec.Command("bash", "-c", "subfinder -dL ./Programs/"+program_name+"/recon-data/domains.txt -o ./Programs/"+program_name+"/"+date+"/subfinder.out")
+	cmd := bashCommand(ctx, SubfinderCommand(program_name, date))
 	stdout, err := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
 	var wg2 sync.WaitGroup
 	wg2.Add(1)
 
 	count := 0
+	var lines []string
 	scanner := bufio.NewScanner(stdout)
-	scanner2 := bufio.NewScanner(stderr)
 	go func() {
 		for scanner.Scan() {
 			count += 1
+			stage.AddFound(1)
+			lines = append(lines, scanner.Text())
 			//log.Printf(strconv.Itoa(count) + " subfinder out: %s", scanner.Text())
 		}
 		wg2.Done()
 	}()
 
 	if err = cmd.Start(); err != nil {
-		log.Println(scanner2.Text())
-		log.Fatal(err)
+		return 0, err
 	}
 
 	wg2.Wait()
-	cmd.Wait() //bug where this also prints 0
+	if err := waitCommand(ctx, cmd); err != nil {
+		keepPartialOutput(ctx, "./Programs/"+program_name+"/"+date+"/subfinder.out", lines)
+		return count, err
+	}
 
-	time_elapsed := time.Since(start)
-	io.Success("Subfinder Enumeration Complete! Finished in " + time_elapsed.String() + ", enumerating " + strconv.Itoa(count) + " subdomains.")
-	wg.Done()
+	logger.Info("Subfinder enumeration complete", "subdomains", count, "duration", time.Since(start))
+	return count, nil
 }
 
-// Bruteforce reverse DNS resolving
-func RunPuredns(program_name string, date string, mode int, wildcard bool) {
-	out := output.NewConsoleOutput(true, nil)
-	out.Writeln("\t<info>INFO - Executing puredns against " + program_name + "</info>")
+// the rate puredns is limited to when it validates the results with the trusted resolvers, in queries per second, unless
+// the run's limits are lower
+const PurednsTrustedRateLimit = 1000
 
+// returns the file a resolution round reads its candidates from. mode 0 resolves the enumerated subdomains, 1 the
+// permutations dnsgen generated.
+func PurednsCandidates(program_name string, date string, mode int) string {
+	if mode != 0 {
+		return "./Programs/" + program_name + "/" + date + "/dnsgen.out"
+	}
+	return "./Programs/" + program_name + "/" + date + "/all_enumerated_subdomains_combined.txt"
+}
+
+// returns the command line RunPuredns runs within limits
+func PurednsCommand(program_name string, date string, mode int, wildcard bool, limits *Limits) string {
 	program_path := "./Programs/" + program_name + "/" + date + "/"
+	stage_name := "puredns-stage-1"
+	if mode != 0 {
+		stage_name = "dnsgen-puredns"
+	}
+	return purednsScript(PurednsCandidates(program_name, date, mode), program_path+stage_name+".massdns", program_path+stage_name+".wildcards", wildcard, limits)
+}
 
-	// get wildcard flag
-	wildflag := "--wildcard-batch 1250000"
+// returns a puredns command line resolving the candidates in a file within limits, writing the DNS records it receives
+// to massdns and, with wildcard filtering on, the wildcard roots it detects to wildcards
+func purednsScript(candidates string, massdns string, wildcards string, wildcard bool, limits *Limits) string {
+	wildflag := "--wildcard-batch 1250000 --write-wildcards " + wildcards
 	if !wildcard {
 		wildflag = "--skip-wildcard-filter"
 	}
+	return "puredns resolve " + candidates + " " + purednsRateFlags(limits) + " " + wildflag + " --write-massdns " + massdns + " -r " + ResolversFile
+}
+
+// ChunkResult is what resolving a chunk of a resolution round's candidates returned: the subdomains which resolved, the DNS
+// records puredns received for them in massdns format, and the wildcard roots it detected.
+type ChunkResult struct {
+	Resolved  []string `json:"resolved"`
+	Records   []string `json:"records"`
+	Wildcards []string `json:"wildcards,omitempty"`
+}
+
+// Distributor resolves the candidates of a resolution round in chunks, on other machines as well as this one (see
+// wrdist). the result of each chunk is written to output as soon as it is resolved, so the chunks which were resolved are
+// kept even when the round fails or is cancelled.
+type Distributor interface {
+	Resolve(ctx context.Context, stage_name string, candidates string, wildcard bool, output *RoundOutput, stage *wrprogress.Stage) error
+}
+
+// RoundOutput writes the results of a distributed round's chunks to the round's files as they come in: the subdomains
+// which resolved to its output file, the DNS records to its .massdns file and the wildcard roots, once each, to its
+// .wildcards file. it is not safe for concurrent use.
+type RoundOutput struct {
+	resolved  io.Writer
+	massdns   *os.File
+	wildcards *os.File
+	roots     map[string]bool
+	count     int
+}
+
+// function to create the files of a round's results, writing the subdomains which resolved to resolved
+func newRoundOutput(resolved io.Writer, massdns_path string, wildcards_path string, wildcard bool) (*RoundOutput, error) {
+	output := &RoundOutput{resolved: resolved, roots: make(map[string]bool)}
+	var err error
+	if output.massdns, err = os.Create(massdns_path); err != nil {
+		return nil, err
+	}
+	if wildcard {
+		if output.wildcards, err = os.Create(wildcards_path); err != nil {
+			output.massdns.Close()
+			return nil, err
+		}
+	}
+	return output, nil
+}
+
+// function to write the result of a chunk
+func (o *RoundOutput) Write(result ChunkResult) error {
+	writer := bufio.NewWriter(o.resolved)
+	for _, line := range result.Resolved {
+		writer.WriteString(line + "\n")
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	o.count += len(result.Resolved)
+	writer = bufio.NewWriter(o.massdns)
+	for _, line := range result.Records {
+		writer.WriteString(line + "\n")
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	// each chunk detects the wildcards among its own candidates, so the same root may be found by several
+	if o.wildcards == nil {
+		return nil
+	}
+	writer = bufio.NewWriter(o.wildcards)
+	for _, root := range result.Wildcards {
+		if !o.roots[root] {
+			o.roots[root] = true
+			writer.WriteString(root + "\n")
+		}
+	}
+	return writer.Flush()
+}
 
-	//select mode (changes cmd command value dependenant on mode value passed as arguement. 0 = run against enumerated, 1 = run against dnsgen output)
+// returns the number of subdomains written so far
+func (o *RoundOutput) Count() int {
+	return o.count
+}
+
+// function to close the round's files
+func (o *RoundOutput) Close() error {
+	err := o.massdns.Close()
+	if o.wildcards != nil {
+		err = errors.Join(err, o.wildcards.Close())
+	}
+	return err
+}
+
+// function to resolve a chunk of candidates with puredns within limits, keeping its files in the directory dir
+func ResolveChunk(ctx context.Context, dir string, candidates []string, wildcard bool, limits *Limits) (ChunkResult, error) {
+	var result ChunkResult
+	candidates_path := filepath.Join(dir, "candidates.txt")
+	massdns_path := filepath.Join(dir, "chunk.massdns")
+	wildcards_path := filepath.Join(dir, "chunk.wildcards")
+	if err := writeLines(candidates_path, candidates); err != nil {
+		return result, err
+	}
+	cmd := bashCommand(ctx, purednsScript(candidates_path, massdns_path, wildcards_path, wildcard, limits))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return result, err
+	}
+	if err := cmd.Start(); err != nil {
+		return result, err
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		result.Resolved = append(result.Resolved, strings.ToLower(scanner.Text()))
+	}
+	if err := waitCommand(ctx, cmd); err != nil {
+		return result, err
+	}
+	result.Records = wrutils.WordlistToArray(massdns_path)
+	if wildcard {
+		result.Wildcards = wrutils.WordlistToArray(wildcards_path)
+	}
+	return result, nil
+}
+
+// function to write lines to a file, replacing it
+func writeLines(path string, lines []string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	for _, line := range lines {
+		writer.WriteString(line + "\n")
+	}
+	return writer.Flush()
+}
+
+// Bruteforce reverse DNS resolving. the DNS records puredns receives are kept in massdns format (puredns-stage-1.massdns / dnsgen-puredns.massdns),
+// and with wildcard filtering on, the wildcard roots it detects are written to puredns-stage-1.wildcards / dnsgen-puredns.wildcards. returns the number of valid subdomains.
+// the stage's total is the number of candidates, and its progress is read from puredns's status output when it reports it.
+// the candidates are limited to what is left of the query budget, and resolved at the rate the limits allow, or handed to
+// distributor to resolve in chunks if it isn't nil.
+func RunPuredns(ctx context.Context, program_name string, date string, mode int, wildcard bool, limits *Limits, distributor Distributor, stage *wrprogress.Stage) (int, error) {
+	program_path := "./Programs/" + program_name + "/" + date + "/"
+
+	//select mode (changes the output file dependenant on mode value passed as arguement. 0 = run against enumerated, 1 = run against dnsgen output)
 	var cmd *exec.Cmd
 	var output_file *os.File
+	var err error
+	logger := wrlog.FromContext(ctx)
+	stage_name := "puredns-stage-1"
+	if mode != 0 {
+		stage_name = "dnsgen-puredns"
+	}
+	candidates := PurednsCandidates(program_name, date, mode)
+	total := wrutils.CountLines(candidates)
+	allowed, err := limits.Reserve(stage_name, total)
+	if err != nil {
+		return 0, err
+	}
+	budget_spent := allowed == 0 && total > 0
+	if allowed < total {
+		if err := truncateCandidates(candidates, allowed); err != nil {
+			return 0, err
+		}
+		logger.Warn("Query budget exhausted, resolving only part of the candidates", "candidates", total, "resolving", allowed, "left_out", candidates+".over-budget")
+		total = allowed
+	}
+	stage.SetTotal(total)
+	logger.Info("Executing puredns", "candidates", total, "wildcard_filter", wildcard)
+	start := time.Now()
 	if mode == 0 {
 		//out.Writeln("puredns -t 50000 -r ./wordlists/resolvers.txt -d " + domain + " -list " + program_path + domain + "-subdomains.out")
 		//cmd = exec.Command("bash", "-c", "puredns -t 50000 -r ./wordlists/resolvers.txt -d " + domain + " -list " + program_path + domain + "-subdomains.out")// -o " + program_path + domain + "-puredns.out")
 		//puredns testing
 		//out.Writeln("puredns resolve " + program_path + domain + "-puredns.out -r ./wordlists/resolvers.txt")
-		cmd = exec.Command("bash", "-c", "puredns resolve "+program_path+"all_enumerated_subdomains_combined.txt --rate-limit-trusted 1000 "+wildflag+" -r ./wordlists/resolvers.txt")
 		//create output file
-		output_file, _ = os.OpenFile(program_path+"puredns-stage-1.out", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		output_file, err = os.OpenFile(program_path+"puredns-stage-1.out", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	} else {
 		//out.Writeln("puredns -t 50000 -r ./wordlists/resolvers.txt -d " + domain + " -list " + program_path + domain + "-dnsgen.out")
 		//cmd = exec.Command("bash", "-c", "puredns -t 50000 -r ./wordlists/resolvers.txt -d " + domain + " -list " + program_path + domain + "-dnsgen.out")// + program_path + domain + "-dnsgen-puredns.out")
 		//puredns testing
 		//out.Writeln("puredns resolve " + program_path + domain + "-dnsgen.out -r ./wordlists/resolvers.txt")
-		cmd = exec.Command("bash", "-c", "puredns resolve "+program_path+"dnsgen.out --rate-limit-trusted 1000 "+wildflag+" -r ./wordlists/resolvers.txt")
-		output_file, _ = os.OpenFile(program_path+"dnsgen-puredns.out", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		output_file, err = os.OpenFile(program_path+"dnsgen-puredns.out", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer output_file.Close()
+	// nothing was left of the budget for this round
+	if budget_spent {
+		return 0, nil
+	}
+	if distributor != nil {
+		output, err := newRoundOutput(output_file, program_path+stage_name+".massdns", program_path+stage_name+".wildcards", wildcard)
+		if err != nil {
+			return 0, err
+		}
+		// the chunks which were resolved are kept even if the round failed or was stopped
+		err = distributor.Resolve(ctx, stage_name, candidates, wildcard, output, stage)
+		if close_error := output.Close(); err == nil {
+			err = close_error
+		}
+		observeMassdnsAnswers(program_path + stage_name + ".massdns")
+		if err != nil {
+			return output.Count(), err
+		}
+		logger.Info("Puredns complete", "valid_subdomains", output.Count(), "duration", time.Since(start), "distributed", true)
+		return output.Count(), nil
 	}
 
-	stdout, _ := cmd.StdoutPipe()
+	cmd = bashCommand(ctx, PurednsCommand(program_name, date, mode, wildcard, limits))
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	// puredns's stderr is read for its progress instead of being logged as is
+	cmd.Stderr = nil
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return 0, err
+	}
 
 	var wg2 sync.WaitGroup
-	wg2.Add(1)
+	wg2.Add(2)
+	go func() {
+		readPurednsProgress(stderr, total, stage, logger)
+		wg2.Done()
+	}()
 
 	var purednsout []string
 	count := 0
@@ -209,6 +563,7 @@ func RunPuredns(program_name string, date string, mode int, wildcard bool) {
 	go func() {
 		for scanner.Scan() {
 			count += 1
+			stage.AddFound(1)
 			//log.Printf(strconv.Itoa(count) + " puredns out: %s", scanner.Text())
 			purednsout = append(purednsout, strings.ToLower(scanner.Text())+"\n")
 		}
@@ -216,29 +571,151 @@ func RunPuredns(program_name string, date string, mode int, wildcard bool) {
 	}()
 
 	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
 	wg2.Wait()
-	cmd.Wait() //bug where this also prints 0
+	// the subdomains puredns printed before it was stopped are kept too
+	wait_error := waitCommand(ctx, cmd)
 	for _, line := range purednsout {
 		output_file.WriteString(line)
 	}
-	out.Writeln("\t<info>INFO - Puredns Complete - Found " + strconv.Itoa(count) + " valid subdomains. </info>")
+	observeMassdnsAnswers(program_path + stage_name + ".massdns")
+	if wait_error != nil {
+		return count, wait_error
+	}
+	logger.Info("Puredns complete", "valid_subdomains", count, "duration", time.Since(start))
+	return count, nil
 }
 
-// Generates permutations of validated subdomains from puredns output
-func RunDnsgen(program_name string, date string) {
-	out := output.NewConsoleOutput(true, nil)
-	out.Writeln("\t<info>INFO - Executing dnsgen </info>")
+// function to add the answers puredns received in a resolution round, read from the massdns output it wrote, to the
+// resolver metrics. the output holds a reply's records one per line (a CNAME followed by the records of its target), with
+// replies separated by blank lines, but not which resolver sent them nor the queries which failed, so only the answers
+// are counted, under the resolver "puredns".
+func observeMassdnsAnswers(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	answers := 0
+	previous, alias := "", ""
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			previous, alias = "", ""
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if name != previous && name != alias {
+			answers += 1
+		}
+		previous, alias = name, ""
+		if strings.EqualFold(fields[1], "CNAME") {
+			alias = strings.ToLower(fields[2])
+		}
+	}
+	wrmetrics.AddResolverResults("puredns", true, answers)
+}
+
+// puredns's status output: massdns's "Processed queries: <n>", or a "<done>/<of>" progress count
+var purednsProgressRegex = regexp.MustCompile(`(?i)processed(?: queries)?:\s*(\d+)|(\d+)\s*/\s*(\d+)`)
+
+// reads puredns's stderr, passing the progress it reports on to stage and logging everything else at debug level. a
+// "<done>/<of>" count is scaled to the number of candidates, as puredns counts queries (retries included) rather than names.
+func readPurednsProgress(stderr io.Reader, total int, stage *wrprogress.Stage, logger *slog.Logger) {
+	scanner := bufio.NewScanner(stderr)
+	// the status line is redrawn with carriage returns, not newlines
+	scanner.Split(func(data []byte, at_eof bool) (int, []byte, error) {
+		for index, b := range data {
+			if b == '\r' || b == '\n' {
+				return index + 1, data[:index], nil
+			}
+		}
+		if at_eof && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		match := purednsProgressRegex.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				logger.Debug("Command output", "tool", "puredns", "line", line)
+			}
+			continue
+		}
+		processed := 0
+		if match[1] != "" {
+			processed, _ = strconv.Atoi(match[1])
+		} else {
+			done, _ := strconv.Atoi(match[2])
+			of, _ := strconv.Atoi(match[3])
+			if of == 0 || done > of {
+				continue
+			}
+			processed = int(int64(done) * int64(total) / int64(of))
+		}
+		if processed > total {
+			processed = total
+		}
+		stage.SetProcessed(processed)
+	}
+	// drain whatever is left, so puredns never blocks writing to a full pipe
+	io.Copy(io.Discard, stderr)
+}
 
+// returns the command line RunDnsgen runs
+func DnsgenCommand(program_name string, date string) string {
 	program_path := "./Programs/" + program_name + "/" + date + "/"
+	return "dnsgen " + program_path + "puredns-stage-1.out | tee -a " + program_path + "dnsgen.out"
+}
 
-	cmd := exec.Command("bash", "-c", "dnsgen "+program_path+"puredns-stage-1.out | tee -a "+program_path+"dnsgen.out")
+// returns the path of the wordlist dnsgen permutes names with. dnsgen is a python script, and its wordlist is words.txt
+// in its package, so it is looked up with the python dnsgen is run with.
+func DnsgenWordlist() (string, error) {
+	path, err := exec.LookPath("dnsgen")
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	shebang, _ := reader.ReadString('\n')
+	interpreter := strings.Fields(strings.TrimPrefix(shebang, "#!"))
+	if !strings.HasPrefix(shebang, "#!") || len(interpreter) == 0 || !strings.Contains(interpreter[len(interpreter)-1], "python") {
+		return "", errors.New(path + " is not a python script")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), versionTimeout)
+	defer cancel()
+	args := append(interpreter[1:], "-c", "import dnsgen, os; print(os.path.join(os.path.dirname(dnsgen.__file__), 'words.txt'))")
+	output, err := exec.CommandContext(ctx, interpreter[0], args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("could not find the dnsgen package - %w", err)
+	}
+	wordlist := strings.TrimSpace(string(output))
+	if _, err := os.Stat(wordlist); err != nil {
+		return "", err
+	}
+	return wordlist, nil
+}
+
+// Generates permutations of validated subdomains from puredns output. returns the number of permutations generated.
+func RunDnsgen(ctx context.Context, program_name string, date string, stage *wrprogress.Stage) (int, error) {
+	logger := wrlog.FromContext(ctx)
+	logger.Info("Executing dnsgen")
+	start := time.Now()
+
+	cmd := bashCommand(ctx, DnsgenCommand(program_name, date))
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
 	var wg2 sync.WaitGroup
@@ -249,16 +726,20 @@ func RunDnsgen(program_name string, date string) {
 	go func() {
 		for scanner.Scan() {
 			count += 1
+			stage.AddFound(1)
 			//log.Printf(strconv.Itoa(count) + " %s", scanner.Text())
 		}
 		wg2.Done()
 	}()
 
 	if err = cmd.Start(); err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
 	wg2.Wait()
-	cmd.Wait() //bug where this also prints 0
-	out.Writeln("\t<info>INFO - dnsgen Complete - Generated " + strconv.Itoa(count) + " potential subdomains. </info>")
+	if err := waitCommand(ctx, cmd); err != nil {
+		return count, err
+	}
+	logger.Info("Dnsgen complete", "potential_subdomains", count, "duration", time.Since(start))
+	return count, nil
 }
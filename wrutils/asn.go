@@ -0,0 +1,399 @@
+package wrutils
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ASN FUNCTIONS
+// addresses are tagged with their ASN, organisation and hosting provider using a local IP-to-ASN database, so no lookups leave the machine.
+// the database is stored in the iptoasn.com TSV format (range start, range end, ASN, country, description) at ./wordlists/ip2asn.tsv.
+// ./WebRecon asndb import <file> converts other datasets into it.
+
+const DefaultASNDatabase = "./wordlists/ip2asn.tsv"
+
+// a range of addresses announced by an ASN
+type asnRange struct {
+	start   netip.Addr
+	end     netip.Addr
+	asn     int
+	country string
+	org     string
+}
+
+// ASNDatabase looks up the ASN of an address.
+type ASNDatabase struct {
+	ranges []asnRange
+}
+
+// a known cloud, CDN or SaaS provider, matched by ASN or by a substring of the organisation name
+type provider struct {
+	name string
+	kind string
+	asns []int
+	orgs []string
+}
+
+// provider kinds. hosts whose addresses don't belong to a known provider are assumed to be hosted by the program itself.
+const (
+	ProviderKindCloud   = "cloud"
+	ProviderKindCDN     = "cdn"
+	ProviderKindSaaS    = "saas"
+	ProviderKindHosting = "hosting"
+)
+
+var knownProviders = []provider{
+	{"AWS", ProviderKindCloud, []int{16509, 14618, 8987, 38895}, []string{"amazon"}},
+	{"Google Cloud", ProviderKindCloud, []int{15169, 396982, 19527, 139070}, []string{"google"}},
+	{"Azure", ProviderKindCloud, []int{8075, 8068, 8069, 12076}, []string{"microsoft"}},
+	{"Oracle Cloud", ProviderKindCloud, []int{31898, 792}, []string{"oracle"}},
+	{"Alibaba Cloud", ProviderKindCloud, []int{45102, 37963}, []string{"alibaba"}},
+	{"IBM Cloud", ProviderKindCloud, []int{36351}, []string{"softlayer"}},
+	{"Cloudflare", ProviderKindCDN, []int{13335, 209242}, []string{"cloudflare"}},
+	{"Akamai", ProviderKindCDN, []int{20940, 16625, 16702, 21342, 32787, 35994}, []string{"akamai"}},
+	{"Fastly", ProviderKindCDN, []int{54113}, []string{"fastly"}},
+	{"Imperva", ProviderKindCDN, []int{19551}, []string{"incapsula", "imperva"}},
+	{"StackPath", ProviderKindCDN, []int{33438, 12989}, []string{"stackpath", "highwinds"}},
+	{"Edgecast", ProviderKindCDN, []int{15133}, []string{"edgecast"}},
+	{"GitHub", ProviderKindSaaS, []int{36459}, []string{"github"}},
+	{"Salesforce", ProviderKindSaaS, []int{14340, 22606}, []string{"salesforce"}},
+	{"Zendesk", ProviderKindSaaS, []int{}, []string{"zendesk"}},
+	{"Shopify", ProviderKindSaaS, []int{}, []string{"shopify"}},
+	{"DigitalOcean", ProviderKindHosting, []int{14061}, []string{"digitalocean"}},
+	{"Linode", ProviderKindHosting, []int{63949}, []string{"linode", "akamai connected cloud"}},
+	{"OVH", ProviderKindHosting, []int{16276}, []string{"ovh"}},
+	{"Hetzner", ProviderKindHosting, []int{24940}, []string{"hetzner"}},
+	{"Vultr", ProviderKindHosting, []int{20473}, []string{"choopa", "vultr"}},
+}
+
+// returns the provider name and kind for an ASN and organisation, or "" if it isn't a known provider
+func ClassifyProvider(asn int, org string) (string, string) {
+	for _, p := range knownProviders {
+		for _, a := range p.asns {
+			if a == asn {
+				return p.name, p.kind
+			}
+		}
+	}
+	org = strings.ToLower(org)
+	for _, p := range knownProviders {
+		for _, o := range p.orgs {
+			if org != "" && strings.Contains(org, o) {
+				return p.name, p.kind
+			}
+		}
+	}
+	return "", ""
+}
+
+// opens a file, transparently decompressing it if its name ends in .gz
+func openMaybeGzip(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, file}, nil
+}
+
+// function to load an ASN database in the iptoasn.com TSV format
+func LoadASNDatabase(path string) (*ASNDatabase, error) {
+	file, err := openMaybeGzip(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	db := &ASNDatabase{}
+	if err := db.readTSV(file); err != nil {
+		return nil, fmt.Errorf("%s %v", path, err)
+	}
+	db.sort()
+	return db, nil
+}
+
+// parses a range given as start and end addresses (dotted or uint32) and an ASN ("AS13335" or "13335")
+func parseASNRange(start string, end string, asn string) (asnRange, error) {
+	var r asnRange
+	var err error
+	if r.start, err = parseASNAddr(start); err != nil {
+		return r, err
+	}
+	if r.end, err = parseASNAddr(end); err != nil {
+		return r, err
+	}
+	r.asn, err = strconv.Atoi(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(asn)), "AS"))
+	return r, err
+}
+
+// parses an address given as text or as a uint32 (ip2asn-v4-u32.tsv)
+func parseASNAddr(value string) (netip.Addr, error) {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}), nil
+	}
+	return netip.ParseAddr(value)
+}
+
+func (db *ASNDatabase) sort() {
+	sort.Slice(db.ranges, func(a, b int) bool { return db.ranges[a].start.Less(db.ranges[b].start) })
+}
+
+// returns the number of ranges in the database
+func (db *ASNDatabase) Len() int {
+	return len(db.ranges)
+}
+
+// function to look up the ASN, organisation and provider of an address. only IP is set if the address isn't in the database.
+func (db *ASNDatabase) Lookup(ip string) IPInfo {
+	info := IPInfo{IP: ip}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return info
+	}
+	addr = addr.Unmap()
+	index := sort.Search(len(db.ranges), func(i int) bool { return addr.Less(db.ranges[i].start) }) - 1
+	if index < 0 || db.ranges[index].end.Less(addr) {
+		return info
+	}
+	r := db.ranges[index]
+	info.ASN, info.Org, info.Country = r.asn, r.org, r.country
+	info.Provider, info.Kind = ClassifyProvider(r.asn, r.org)
+	return info
+}
+
+// function to tag the addresses of every record with their ASN information
+func EnrichDNSRecords(records []HostRecord, db *ASNDatabase) {
+	cache := make(map[string]IPInfo)
+	for index := range records {
+		records[index].IPs = nil
+		for _, ip := range records[index].Addresses() {
+			info, ok := cache[ip]
+			if !ok {
+				info = db.Lookup(ip)
+				cache[ip] = info
+			}
+			records[index].IPs = append(records[index].IPs, info)
+		}
+	}
+}
+
+// function to convert an IP-to-ASN dataset into the iptoasn.com TSV format, returning the number of ranges written.
+// supported inputs (optionally .gz compressed) are iptoasn.com TSV files (ip2asn-v4.tsv, ip2asn-combined.tsv, ip2asn-v4-u32.tsv),
+// MaxMind GeoLite2 ASN CSV (GeoLite2-ASN-Blocks-IPv4.csv: network,autonomous_system_number,autonomous_system_organization),
+// and CSV files of "cidr,asn,org" or "start,end,asn,org".
+func ImportASNDatabase(source string, destination string) (int, error) {
+	file, err := openMaybeGzip(source)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	db := &ASNDatabase{}
+	reader := bufio.NewReader(file)
+	first_line, _ := reader.Peek(4096)
+	if strings.Contains(strings.SplitN(string(first_line), "\n", 2)[0], "\t") {
+		err = db.readTSV(reader)
+	} else {
+		err = db.readCSV(reader)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(db.ranges) == 0 {
+		return 0, errors.New("no ranges found in " + source)
+	}
+	db.sort()
+
+	out_file, err := os.Create(destination)
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriter(out_file)
+	for _, r := range db.ranges {
+		org := strings.NewReplacer("\t", " ", "\n", " ").Replace(r.org)
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\t%s\n", r.start, r.end, r.asn, r.country, org)
+	}
+	if err := writer.Flush(); err != nil {
+		out_file.Close()
+		return 0, err
+	}
+	return len(db.ranges), out_file.Close()
+}
+
+func (db *ASNDatabase) readTSV(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line_number := 0
+	for scanner.Scan() {
+		line_number += 1
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 3 {
+			continue
+		}
+		r, err := parseASNRange(fields[0], fields[1], fields[2])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line_number, err)
+		}
+		if len(fields) > 3 {
+			r.country = fields[3]
+		}
+		if len(fields) > 4 {
+			r.org = fields[4]
+		}
+		if r.asn != 0 {
+			db.ranges = append(db.ranges, r)
+		}
+	}
+	return scanner.Err()
+}
+
+func (db *ASNDatabase) readCSV(reader io.Reader) error {
+	records := csv.NewReader(reader)
+	records.FieldsPerRecord = -1
+	line_number := 0
+	for {
+		record, err := records.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line_number += 1
+		if len(record) < 2 {
+			continue
+		}
+		var r asnRange
+		if prefix, err := netip.ParsePrefix(strings.TrimSpace(record[0])); err == nil {
+			// cidr,asn,org (GeoLite2)
+			prefix = prefix.Masked()
+			r.start = prefix.Addr()
+			r.end = lastAddr(prefix)
+			r.asn, err = strconv.Atoi(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(record[1])), "AS"))
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line_number, err)
+			}
+			if len(record) > 2 {
+				r.org = record[2]
+			}
+		} else if len(record) >= 3 {
+			// start,end,asn,org
+			r, err = parseASNRange(record[0], record[1], record[2])
+			if err != nil {
+				if line_number == 1 {
+					// header
+					continue
+				}
+				return fmt.Errorf("line %d: %v", line_number, err)
+			}
+			if len(record) > 3 {
+				r.org = record[3]
+			}
+		} else if line_number == 1 {
+			continue
+		} else {
+			return fmt.Errorf("line %d: expected cidr,asn,org or start,end,asn,org", line_number)
+		}
+		if r.asn != 0 {
+			db.ranges = append(db.ranges, r)
+		}
+	}
+}
+
+// returns the last address of a prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 1 << (7 - bit%8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// ASNSummary is the number of hosts with an address in an ASN.
+type ASNSummary struct {
+	ASN      int    `json:"asn"`
+	Org      string `json:"org"`
+	Provider string `json:"provider,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+	Hosts    int    `json:"hosts"`
+}
+
+// host kinds used by SummarizeHostKinds, besides the provider kinds
+const (
+	HostKindSelfHosted = "self-hosted"
+	HostKindUnknown    = "unknown"
+)
+
+// function to count the hosts in each ASN, most hosts first
+func SummarizeASNs(records []HostRecord) []ASNSummary {
+	summaries := make(map[int]*ASNSummary)
+	for _, record := range records {
+		counted := make(map[int]bool)
+		for _, info := range record.IPs {
+			if info.ASN == 0 || counted[info.ASN] {
+				continue
+			}
+			counted[info.ASN] = true
+			if summaries[info.ASN] == nil {
+				summaries[info.ASN] = &ASNSummary{ASN: info.ASN, Org: info.Org, Provider: info.Provider, Kind: info.Kind}
+			}
+			summaries[info.ASN].Hosts += 1
+		}
+	}
+	var sorted []ASNSummary
+	for _, summary := range summaries {
+		sorted = append(sorted, *summary)
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		if sorted[a].Hosts != sorted[b].Hosts {
+			return sorted[a].Hosts > sorted[b].Hosts
+		}
+		return sorted[a].ASN < sorted[b].ASN
+	})
+	return sorted
+}
+
+// returns how a host is hosted: the kind of its first known provider, self-hosted if its addresses are in the database
+// but not owned by a known provider, or unknown
+func HostKind(record HostRecord) string {
+	kind := HostKindUnknown
+	for _, info := range record.IPs {
+		if info.Kind != "" {
+			return info.Kind
+		}
+		if info.ASN != 0 {
+			kind = HostKindSelfHosted
+		}
+	}
+	return kind
+}
+
+// function to count the hosts of each kind (cloud, cdn, saas, hosting, self-hosted, unknown)
+func SummarizeHostKinds(records []HostRecord) map[string]int {
+	kinds := make(map[string]int)
+	for _, record := range records {
+		kinds[HostKind(record)] += 1
+	}
+	return kinds
+}
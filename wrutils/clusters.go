@@ -0,0 +1,229 @@
+package wrutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// INFRASTRUCTURE CLUSTERING FUNCTIONS
+// groups the subdomains in dns_records.json by the infrastructure they share (IP, /24 subnet, CNAME target and ASN),
+// and lists the hosts that share their subnet with nothing else. those are often forgotten assets.
+
+// Cluster is a group of hosts sharing a piece of infrastructure.
+type Cluster struct {
+	Key   string   `json:"key"`
+	Label string   `json:"label,omitempty"`
+	Hosts []string `json:"hosts"`
+}
+
+// UniqueHost is a resolved host whose subnets aren't shared with any other host.
+type UniqueHost struct {
+	Host    string   `json:"host"`
+	Subnets []string `json:"subnets"`
+	CNAME   string   `json:"cname,omitempty"`
+	Org     string   `json:"org,omitempty"`
+}
+
+// ClusterReport is written to clusters.json in the run directory.
+type ClusterReport struct {
+	Hosts    int          `json:"hosts"`
+	Resolved int          `json:"resolved"`
+	ByIP     []Cluster    `json:"by_ip"`
+	BySubnet []Cluster    `json:"by_subnet"`
+	ByCNAME  []Cluster    `json:"by_cname"`
+	ByASN    []Cluster    `json:"by_asn"`
+	Unique   []UniqueHost `json:"unique"`
+}
+
+// returns the /24 (IPv4) or /64 (IPv6) subnet of an address, or "" if it isn't an address
+func Subnet(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := 64
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.String()
+}
+
+// clusterSet holds the clusters being built, by key. each cluster keeps a set of its hosts, so adding a host stays cheap
+// however many the cluster holds.
+type clusterSet map[string]*clusterBuild
+
+type clusterBuild struct {
+	cluster Cluster
+	hosts   map[string]bool
+}
+
+// adds host to the cluster for key, keeping the hosts unique
+func (set clusterSet) add(key string, label string, host string) {
+	if key == "" {
+		return
+	}
+	build := set[key]
+	if build == nil {
+		build = &clusterBuild{cluster: Cluster{Key: key, Label: label}, hosts: make(map[string]bool)}
+		set[key] = build
+	}
+	if !build.hosts[host] {
+		build.hosts[host] = true
+		build.cluster.Hosts = append(build.cluster.Hosts, host)
+	}
+}
+
+// returns the clusters with the most hosts first
+func (set clusterSet) sorted() []Cluster {
+	clusters := []Cluster{}
+	for _, build := range set {
+		sort.Strings(build.cluster.Hosts)
+		clusters = append(clusters, build.cluster)
+	}
+	sort.Slice(clusters, func(a, b int) bool {
+		if len(clusters[a].Hosts) != len(clusters[b].Hosts) {
+			return len(clusters[a].Hosts) > len(clusters[b].Hosts)
+		}
+		return clusters[a].Key < clusters[b].Key
+	})
+	return clusters
+}
+
+// function to cluster DNS records by IP, subnet, CNAME target and ASN
+func ClusterDNSRecords(records []HostRecord) ClusterReport {
+	by_ip, by_subnet, by_cname, by_asn := clusterSet{}, clusterSet{}, clusterSet{}, clusterSet{}
+	report := ClusterReport{Hosts: len(records), Unique: []UniqueHost{}}
+
+	orgs := make(map[string]string)
+	for _, record := range records {
+		addresses := record.Addresses()
+		if len(addresses) > 0 {
+			report.Resolved += 1
+		}
+		for _, ip := range addresses {
+			by_ip.add(ip, "", record.Host)
+			by_subnet.add(Subnet(ip), "", record.Host)
+		}
+		if len(record.CNAME) > 0 {
+			by_cname.add(record.CNAME[len(record.CNAME)-1], "", record.Host)
+		}
+		for _, info := range record.IPs {
+			if info.ASN == 0 {
+				continue
+			}
+			label := info.Org
+			if info.Provider != "" {
+				label += " [" + info.Provider + "]"
+			}
+			by_asn.add(fmt.Sprintf("AS%d", info.ASN), label, record.Host)
+			orgs[record.Host] = info.Org
+		}
+	}
+	report.ByIP = by_ip.sorted()
+	report.BySubnet = by_subnet.sorted()
+	report.ByCNAME = by_cname.sorted()
+	report.ByASN = by_asn.sorted()
+
+	// a host is on unique infrastructure if every subnet it resolves into holds no other host
+	for _, record := range records {
+		var subnets []string
+		unique := true
+		for _, ip := range record.Addresses() {
+			subnet := Subnet(ip)
+			if subnet == "" || SliceContainsString(subnets, subnet) {
+				continue
+			}
+			subnets = append(subnets, subnet)
+			if len(by_subnet[subnet].hosts) > 1 {
+				unique = false
+			}
+		}
+		if !unique || len(subnets) == 0 {
+			continue
+		}
+		host := UniqueHost{Host: record.Host, Subnets: subnets, Org: orgs[record.Host]}
+		if len(record.CNAME) > 0 {
+			host.CNAME = record.CNAME[len(record.CNAME)-1]
+		}
+		report.Unique = append(report.Unique, host)
+	}
+	return report
+}
+
+// writes one section of the human readable report
+func writeClusterSection(b *strings.Builder, title string, clusters []Cluster, min_hosts int) {
+	fmt.Fprintf(b, "== %s ==\n", title)
+	written := 0
+	for _, cluster := range clusters {
+		if len(cluster.Hosts) < min_hosts {
+			continue
+		}
+		written += 1
+		label := ""
+		if cluster.Label != "" {
+			label = " " + cluster.Label
+		}
+		fmt.Fprintf(b, "%s%s (%d hosts)\n", cluster.Key, label, len(cluster.Hosts))
+		for _, host := range cluster.Hosts {
+			fmt.Fprintf(b, "\t%s\n", host)
+		}
+	}
+	if written == 0 {
+		fmt.Fprintf(b, "(none)\n")
+	}
+	b.WriteString("\n")
+}
+
+// function to render a cluster report as text. only clusters of shared infrastructure (2 or more hosts) are listed, plus the unique hosts.
+func FormatClusterReport(report ClusterReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d subdomains, %d resolved. %d shared IPs, %d shared subnets, %d shared CNAME targets, %d ASNs.\n\n",
+		report.Hosts, report.Resolved, countShared(report.ByIP), countShared(report.BySubnet), countShared(report.ByCNAME), len(report.ByASN))
+	fmt.Fprintf(&b, "== Hosts on unique infrastructure (%d) ==\n", len(report.Unique))
+	for _, host := range report.Unique {
+		details := strings.Join(host.Subnets, ", ")
+		if host.CNAME != "" {
+			details += " via " + host.CNAME
+		}
+		if host.Org != "" {
+			details += " (" + host.Org + ")"
+		}
+		fmt.Fprintf(&b, "%s\t%s\n", host.Host, details)
+	}
+	b.WriteString("\n")
+	writeClusterSection(&b, "Shared IPs", report.ByIP, 2)
+	writeClusterSection(&b, "Shared subnets", report.BySubnet, 2)
+	writeClusterSection(&b, "Shared CNAME targets", report.ByCNAME, 2)
+	writeClusterSection(&b, "ASNs", report.ByASN, 1)
+	return b.String()
+}
+
+// returns the number of clusters with more than one host
+func countShared(clusters []Cluster) int {
+	count := 0
+	for _, cluster := range clusters {
+		if len(cluster.Hosts) > 1 {
+			count += 1
+		}
+	}
+	return count
+}
+
+// function to write a run's clusters.json and clusters.txt
+func WriteClusterReport(program_name string, date string, report ClusterReport) error {
+	data_directory := "./Programs/" + program_name + "/" + date + "/"
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(data_directory+"clusters.json", b, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(data_directory+"clusters.txt", []byte(FormatClusterReport(report)), 0644)
+}
@@ -0,0 +1,121 @@
+package wrutils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// DOMAIN INPUT FUNCTIONS
+// entries in domains.txt are normalized before they are passed to any tool:
+//
+//	https://Foo.com:8443/path  ->  foo.com
+//	*.foo.com                  ->  foo.com
+//	bücher.de                  ->  xn--bcher-kva.de
+//	# comments and blank lines are ignored, duplicates are dropped
+
+// DomainError is an invalid entry in a domains list.
+type DomainError struct {
+	Line  int
+	Entry string
+	Err   error
+}
+
+func (e DomainError) Error() string {
+	return fmt.Sprintf("line %d: %q - %v", e.Line, e.Entry, e.Err)
+}
+
+var hostLabelRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// strips a scheme, userinfo, path, query, port and trailing dot from a URL or hostname and lowercases it
+func stripHost(entry string) string {
+	host := strings.ToLower(strings.TrimSpace(entry))
+	if index := strings.Index(host, "://"); index != -1 {
+		host = host[index+3:]
+	}
+	if index := strings.IndexAny(host, "/?#"); index != -1 {
+		host = host[:index]
+	}
+	if index := strings.LastIndex(host, "@"); index != -1 {
+		host = host[index+1:]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// function to normalize a single domain: strips schemes, paths, ports and wildcard prefixes, lowercases it and converts IDNs to punycode.
+// returns an error if the result isn't a valid hostname.
+func NormalizeDomain(entry string) (string, error) {
+	host := stripHost(entry)
+	host = strings.TrimPrefix(host, "*.")
+	host = strings.TrimPrefix(host, ".")
+	if host == "" {
+		return "", errors.New("empty hostname")
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return "", errors.New("IP addresses are not domains")
+	}
+	if strings.ContainsAny(host, " \t*") {
+		return "", errors.New("hostnames can't contain spaces or wildcards")
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", err
+	}
+	if len(ascii) > 253 {
+		return "", errors.New("hostname is longer than 253 characters")
+	}
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "", errors.New("hostname needs at least two labels (e.g. foo.com)")
+	}
+	for _, label := range labels {
+		if !hostLabelRegex.MatchString(label) {
+			return "", fmt.Errorf("invalid label %q", label)
+		}
+	}
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "", errors.New("top level domain can't be numeric")
+	}
+	return ascii, nil
+}
+
+// function to normalize every line of a domains list. comments (#) and blank lines are skipped and duplicates removed.
+// the invalid entries are returned with their line numbers.
+func NormalizeDomainsList(lines []string) ([]string, []DomainError) {
+	var domains []string
+	var invalid []DomainError
+	seen := make(map[string]bool)
+	for index, line := range lines {
+		entry := line
+		if comment := strings.Index(entry, "#"); comment != -1 && !strings.Contains(entry[:comment], "://") {
+			entry = entry[:comment]
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		domain, err := NormalizeDomain(entry)
+		if err != nil {
+			invalid = append(invalid, DomainError{Line: index + 1, Entry: strings.TrimSpace(line), Err: err})
+			continue
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	return domains, invalid
+}
+
+// returns whether host is domain or a subdomain of it
+func IsSubdomainOf(host string, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
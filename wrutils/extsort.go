@@ -0,0 +1,250 @@
+package wrutils
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EXTERNAL SORTING
+// the intermediate files of a run can hold tens of millions of names (the sub-generator writes every word of its wordlist
+// for every domain), too many to dedupe in memory. SortUnique reads them in batches which fit in a memory ceiling, sorts
+// each batch and spills it to a temporary file next to the output, then merges the sorted files, dropping duplicates as
+// it goes. when everything fits in one batch nothing is spilled.
+
+// the most sorted files merged at once. more are merged in rounds, so a small ceiling doesn't open thousands of files.
+const mergeFanIn = 64
+
+// the memory a line is counted as taking besides its bytes: its string header in the batch
+const lineOverhead = 16
+
+// the longest line SortUnique reads. names are at most 253 bytes, so longer lines are garbage from a tool.
+const maxLineLength = 1024 * 1024
+
+// function to write the lines of the files inputs to output, sorted and without duplicates, holding at most about memory
+// bytes of them in memory at once. surrounding whitespace and blank lines are dropped. output may be one of the inputs, as
+// it is only replaced once everything has been read. returns the number of lines written.
+func SortUnique(inputs []string, output string, memory int64) (int, error) {
+	temp_directory, err := os.MkdirTemp(filepath.Dir(output), ".sort-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(temp_directory)
+	sorter := &externalSorter{directory: temp_directory, memory: memory}
+
+	for _, input := range inputs {
+		if err := sorter.read(input); err != nil {
+			return 0, err
+		}
+	}
+	if len(sorter.spilled) == 0 {
+		// everything fit in memory
+		path := sorter.tempPath()
+		count, err := sorter.write(path)
+		if err != nil {
+			return 0, err
+		}
+		return count, os.Rename(path, output)
+	}
+	if len(sorter.batch) > 0 {
+		if err := sorter.spill(); err != nil {
+			return 0, err
+		}
+	}
+	slog.Info("Merging sorted batches spilled to disk", "path", output, "lines", sorter.lines, "batches", len(sorter.spilled))
+
+	// merge the batches in rounds until they can all be merged at once
+	runs := sorter.spilled
+	for len(runs) > mergeFanIn {
+		var merged []string
+		for start := 0; start < len(runs); start += mergeFanIn {
+			group := runs[start:min(start+mergeFanIn, len(runs))]
+			path := sorter.tempPath()
+			if _, err := mergeSorted(group, path); err != nil {
+				return 0, err
+			}
+			for _, run := range group {
+				os.Remove(run)
+			}
+			merged = append(merged, path)
+		}
+		runs = merged
+	}
+	path := sorter.tempPath()
+	count, err := mergeSorted(runs, path)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(path, output); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// externalSorter holds the batch being read and the batches already spilled to disk
+type externalSorter struct {
+	directory string
+	memory    int64
+	batch     []string
+	size      int64
+	spilled   []string
+	lines     int
+	files     int
+}
+
+// function to read the lines of a file into the batch, spilling the batch to disk whenever it reaches the memory ceiling
+func (s *externalSorter) read(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		s.batch = append(s.batch, line)
+		s.size += int64(len(line) + lineOverhead)
+		s.lines += 1
+		if s.size >= s.memory {
+			if err := s.spill(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read %s - %w", path, err)
+	}
+	return nil
+}
+
+// function to sort the batch and write it to a new temporary file, emptying it
+func (s *externalSorter) spill() error {
+	path := s.tempPath()
+	if _, err := s.write(path); err != nil {
+		return err
+	}
+	s.spilled = append(s.spilled, path)
+	s.batch, s.size = nil, 0
+	return nil
+}
+
+// function to sort the batch and write it to path without duplicates. returns the number of lines written.
+func (s *externalSorter) write(path string) (int, error) {
+	sort.Strings(s.batch)
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriter(file)
+	count := 0
+	for index, line := range s.batch {
+		if index > 0 && line == s.batch[index-1] {
+			continue
+		}
+		writer.WriteString(line + "\n")
+		count += 1
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return 0, err
+	}
+	return count, file.Close()
+}
+
+// returns the path of a new file in the sorter's temporary directory
+func (s *externalSorter) tempPath() string {
+	s.files += 1
+	return filepath.Join(s.directory, fmt.Sprintf("batch-%d", s.files))
+}
+
+// mergeSource is a sorted file being merged, and its next line
+type mergeSource struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	line    string
+}
+
+// mergeHeap orders the sources being merged by their next line
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int            { return len(h) }
+func (h mergeHeap) Less(i, j int) bool  { return h[i].line < h[j].line }
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	source := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return source
+}
+
+// function to merge sorted files into output, dropping duplicates. returns the number of lines written.
+func mergeSorted(paths []string, output string) (int, error) {
+	sources := make([]*mergeSource, 0, len(paths))
+	defer func() {
+		for _, source := range sources {
+			source.file.Close()
+		}
+	}()
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		source := &mergeSource{file: file, scanner: bufio.NewScanner(file)}
+		source.scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+		sources = append(sources, source)
+		if source.scanner.Scan() {
+			source.line = source.scanner.Text()
+		} else if err := source.scanner.Err(); err != nil {
+			return 0, err
+		}
+	}
+	// sources which were empty are left out of the heap, but stay in sources to be closed
+	merging := make(mergeHeap, 0, len(sources))
+	for _, source := range sources {
+		if source.line != "" {
+			merging = append(merging, source)
+		}
+	}
+	heap.Init(&merging)
+
+	file, err := os.Create(output)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	count := 0
+	last := ""
+	for merging.Len() > 0 {
+		next := merging[0]
+		if count == 0 || next.line != last {
+			writer.WriteString(next.line + "\n")
+			last = next.line
+			count += 1
+		}
+		if next.scanner.Scan() {
+			next.line = next.scanner.Text()
+			heap.Fix(&merging, 0)
+			continue
+		}
+		if err := next.scanner.Err(); err != nil {
+			return 0, err
+		}
+		heap.Pop(&merging)
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, err
+	}
+	return count, file.Close()
+}
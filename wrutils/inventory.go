@@ -0,0 +1,491 @@
+package wrutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ASSET INVENTORY FUNCTIONS
+// the asset inventory (./Programs/inventory.db) holds every host any run of a program found, with when it was first and
+// last seen, which sources found it and its records from the latest run that found it. it is a key value store (see
+// kvstore.go) keyed by program and host, so a run's update only writes the hosts it found and a query only reads the
+// assets of the programs and domains it asks for. a program's inventory is updated at the end of each of its runs, in
+// one batch which is written whole or not at all, and historical runs can be imported into it.
+
+// InventoryFile is the name of the inventory store, in ./Programs
+const InventoryFile = "inventory.db"
+
+// the name of the file a program's inventory was kept in, in its program directory, before the inventory store. it is
+// moved into the store the first time the store is opened, and written back when a program is archived.
+const legacyInventoryFile = "inventory.json"
+
+// Asset is a host in a program's inventory.
+type Asset struct {
+	Host      string     `json:"host"`
+	Domain    string     `json:"domain,omitempty"`
+	FirstSeen time.Time  `json:"first_seen"`
+	LastSeen  time.Time  `json:"last_seen"`
+	FirstRun  string     `json:"first_run"`
+	LastRun   string     `json:"last_run"`
+	Runs      int        `json:"runs"`
+	Sources   []string   `json:"sources,omitempty"`
+	Record    HostRecord `json:"record"`
+}
+
+// Inventory holds every host found by a program's runs, and the runs it was built from.
+type Inventory struct {
+	Program string            `json:"program"`
+	Updated time.Time         `json:"updated"`
+	Runs    []InventoryRun    `json:"runs"`
+	Assets  map[string]*Asset `json:"assets"`
+}
+
+// InventoryRun is a run which has been added to an inventory.
+type InventoryRun struct {
+	Date     string    `json:"date"`
+	Finished time.Time `json:"finished"`
+	Hosts    int       `json:"hosts"`
+}
+
+// the name of the lockfile the inventory store is written under, in ./Programs. a run holds the program's lockfile while
+// it updates the inventory, so the store has its own, which keeps an import from another process (or the API server)
+// from writing the store at the same time as the run. reading the store needs no lock.
+const inventoryLockFile = ".inventory.lock"
+
+// how long updating the inventory waits for another process to finish updating it
+var inventoryLockTimeout = 5 * time.Minute
+
+// function to take the inventory lock, waiting while another process or goroutine holds it. returns a function
+// releasing it.
+func lockInventory() (func(), error) {
+	deadline := time.Now().Add(inventoryLockTimeout)
+	for {
+		unlock, lock, err := acquireLock(ProgramsDirectory + inventoryLockFile)
+		if !errors.Is(err, os.ErrExist) {
+			return unlock, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the inventory is being updated by process %d since %s", lock.PID, lock.Started.Format(time.RFC3339))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// returns the path of the inventory store
+func InventoryPath() string {
+	return ProgramsDirectory + InventoryFile
+}
+
+// returns the labels of a host in reverse order (api.example.com is com.example.api), so the hosts of a domain are keyed
+// next to each other
+func reverseHost(host string) string {
+	labels := strings.Split(host, ".")
+	for left, right := 0, len(labels)-1; left < right; left, right = left+1, right-1 {
+		labels[left], labels[right] = labels[right], labels[left]
+	}
+	return strings.Join(labels, ".")
+}
+
+// the keys of the store: a program's last update, its runs by date and its assets by reversed host
+func inventoryProgramKey(program_name string) string {
+	return "program/" + program_name
+}
+
+func inventoryRunKey(program_name string, date string) string {
+	return "run/" + program_name + "/" + date
+}
+
+func inventoryAssetKey(program_name string, host string) string {
+	return "asset/" + program_name + "/" + reverseHost(host)
+}
+
+// inventoryStore is the open inventory store.
+type inventoryStore struct {
+	kv *kvStore
+}
+
+// function to open the inventory store. a store opened for writing must be opened under the inventory lock. the
+// inventories programs had in their directories are moved into the store first.
+func openInventory(writable bool) (*inventoryStore, error) {
+	var legacy []string
+	for _, program_name := range ListPrograms() {
+		if _, err := os.Stat(ProgramsDirectory + program_name + "/" + legacyInventoryFile); err == nil {
+			legacy = append(legacy, program_name)
+		}
+	}
+	if len(legacy) > 0 && !writable {
+		unlock, err := lockInventory()
+		if err != nil {
+			return nil, err
+		}
+		store, err := openInventory(true)
+		if err == nil {
+			err = store.Close()
+		}
+		unlock()
+		if err != nil {
+			return nil, err
+		}
+		legacy = nil
+	}
+	kv, err := openKVStore(InventoryPath(), writable)
+	if err != nil {
+		return nil, err
+	}
+	store := &inventoryStore{kv: kv}
+	for _, program_name := range legacy {
+		if err := store.importLegacy(program_name); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("could not move the inventory of %s into %s - %w", program_name, InventoryPath(), err)
+		}
+	}
+	return store, nil
+}
+
+// function to close the inventory store
+func (store *inventoryStore) Close() error {
+	return store.kv.Close()
+}
+
+// function to read a JSON value from the store into v. returns false if the store doesn't have key.
+func (store *inventoryStore) get(key string, v interface{}) (bool, error) {
+	b, ok, err := store.kv.Get(key)
+	if !ok || err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(b, v)
+}
+
+// function to add a JSON value to a batch
+func putJSON(batch *kvBatch, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	batch.Put(key, b)
+	return nil
+}
+
+// function to read the runs in a program's inventory, oldest first
+func (store *inventoryStore) runs(program_name string) ([]InventoryRun, error) {
+	runs := []InventoryRun{}
+	for _, key := range store.kv.Keys(inventoryRunKey(program_name, "")) {
+		var run InventoryRun
+		if _, err := store.get(key, &run); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(a, b int) bool { return runs[a].Finished.Before(runs[b].Finished) })
+	return runs, nil
+}
+
+// function to read the assets of a program's inventory which are domains or their subdomains, or all of them if domains
+// is empty. returns them sorted by host.
+func (store *inventoryStore) assets(program_name string, domains []string) ([]*Asset, error) {
+	var keys []string
+	if len(domains) == 0 {
+		keys = store.kv.Keys(inventoryAssetKey(program_name, ""))
+	}
+	listed := make(map[string]bool)
+	for _, domain := range domains {
+		key := inventoryAssetKey(program_name, strings.ToLower(domain))
+		for _, match := range append(store.kv.Keys(key+"."), key) {
+			if store.kv.Has(match) && !listed[match] {
+				listed[match] = true
+				keys = append(keys, match)
+			}
+		}
+	}
+	assets := make([]*Asset, 0, len(keys))
+	for _, key := range keys {
+		asset := &Asset{}
+		if _, err := store.get(key, asset); err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	sort.Slice(assets, func(a, b int) bool { return assets[a].Host < assets[b].Host })
+	return assets, nil
+}
+
+// function to add a batch deleting a program's inventory to batch
+func (store *inventoryStore) deleteProgram(batch *kvBatch, program_name string) {
+	for _, prefix := range []string{inventoryAssetKey(program_name, ""), inventoryRunKey(program_name, "")} {
+		for _, key := range store.kv.Keys(prefix) {
+			batch.Delete(key)
+		}
+	}
+	if store.kv.Has(inventoryProgramKey(program_name)) {
+		batch.Delete(inventoryProgramKey(program_name))
+	}
+}
+
+// function to replace a program's inventory in the store with the one in its program directory, which is then removed
+func (store *inventoryStore) importLegacy(program_name string) error {
+	path := ProgramsDirectory + program_name + "/" + legacyInventoryFile
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	inventory := &Inventory{}
+	if err := json.Unmarshal(b, inventory); err != nil {
+		return err
+	}
+	batch := &kvBatch{}
+	store.deleteProgram(batch, program_name)
+	for _, run := range inventory.Runs {
+		if err := putJSON(batch, inventoryRunKey(program_name, run.Date), run); err != nil {
+			return err
+		}
+	}
+	for _, asset := range inventory.Assets {
+		if err := putJSON(batch, inventoryAssetKey(program_name, asset.Host), asset); err != nil {
+			return err
+		}
+	}
+	if err := putJSON(batch, inventoryProgramKey(program_name), inventory.Updated); err != nil {
+		return err
+	}
+	if err := store.kv.Write(batch); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// function to read a program's inventory from the store
+func (store *inventoryStore) inventory(program_name string) (*Inventory, error) {
+	inventory := &Inventory{Program: program_name, Assets: make(map[string]*Asset)}
+	if _, err := store.get(inventoryProgramKey(program_name), &inventory.Updated); err != nil {
+		return nil, err
+	}
+	var err error
+	if inventory.Runs, err = store.runs(program_name); err != nil {
+		return nil, err
+	}
+	assets, err := store.assets(program_name, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, asset := range assets {
+		inventory.Assets[asset.Host] = asset
+	}
+	return inventory, nil
+}
+
+// function to read a program's inventory. returns an empty inventory if the program has none yet.
+func LoadInventory(program_name string) (*Inventory, error) {
+	store, err := openInventory(false)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return store.inventory(program_name)
+}
+
+// returns whether a run has been added to the inventory
+func (inventory *Inventory) HasRun(date string) bool {
+	for _, run := range inventory.Runs {
+		if run.Date == date {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the date of the latest run in the inventory, or "" if it is empty
+func (inventory *Inventory) LatestRun() string {
+	latest := InventoryRun{}
+	for _, run := range inventory.Runs {
+		if run.Finished.After(latest.Finished) || latest.Date == "" {
+			latest = run
+		}
+	}
+	return latest.Date
+}
+
+// returns the inventory's assets sorted by host
+func (inventory *Inventory) SortedAssets() []*Asset {
+	assets := make([]*Asset, 0, len(inventory.Assets))
+	for _, asset := range inventory.Assets {
+		assets = append(assets, asset)
+	}
+	sort.Slice(assets, func(a, b int) bool { return assets[a].Host < assets[b].Host })
+	return assets
+}
+
+// returns the assets which weren't found by the latest run in the inventory, most recently seen first
+func (inventory *Inventory) Disappeared() []*Asset {
+	latest := inventory.LatestRun()
+	var disappeared []*Asset
+	for _, asset := range inventory.SortedAssets() {
+		if asset.LastRun != latest {
+			disappeared = append(disappeared, asset)
+		}
+	}
+	sort.SliceStable(disappeared, func(a, b int) bool { return disappeared[a].LastSeen.After(disappeared[b].LastSeen) })
+	return disappeared
+}
+
+// returns the domain in domains that host belongs to, or "" if none
+func rootDomain(host string, domains []string) string {
+	root := ""
+	for _, domain := range domains {
+		if (host == domain || IsSubdomainOf(host, domain)) && len(domain) > len(root) {
+			root = domain
+		}
+	}
+	return root
+}
+
+// function to add the hosts found by a run to a program's inventory, in one batch. runs can be added in any order; an
+// asset's record and sources are those of the latest run which found it. adding a run again (a program run twice on the
+// same day shares a run directory) refreshes it. returns the hosts the inventory didn't have before.
+func (store *inventoryStore) addRun(program_name string, date string) ([]string, error) {
+	rerun, err := store.get(inventoryRunKey(program_name, date), &InventoryRun{})
+	if err != nil {
+		return nil, err
+	}
+	// runs without a manifest are dated by their directory, since the times of their files may not be when they ran
+	seen, _ := time.ParseInLocation(RunDateFormat, date, time.Local)
+	if _, err := os.Stat(RunDirectory(program_name, date) + "manifest.json"); err == nil {
+		if run := ReadRunInfo(program_name, date); !run.Finished.IsZero() {
+			seen = run.Finished
+		}
+	}
+	domains := WordlistToArray(RunDirectory(program_name, date) + "domains.txt")
+	if len(domains) == 0 {
+		domains = WordlistToArray(ProgramsDirectory + program_name + "/recon-data/domains.txt")
+	}
+	sources := HostSources(program_name, date)
+	records := ReadRunHosts(program_name, date)
+
+	added := []string{}
+	assets := make(map[string]*Asset)
+	for _, record := range records {
+		asset, ok := assets[record.Host]
+		if !ok {
+			asset = &Asset{}
+			if ok, err = store.get(inventoryAssetKey(program_name, record.Host), asset); err != nil {
+				return nil, err
+			}
+		}
+		if !ok {
+			asset = &Asset{Host: record.Host, FirstSeen: seen, FirstRun: date}
+			added = append(added, record.Host)
+		}
+		assets[record.Host] = asset
+		if !rerun || asset.LastRun != date {
+			asset.Runs += 1
+		}
+		if seen.Before(asset.FirstSeen) {
+			asset.FirstSeen, asset.FirstRun = seen, date
+		}
+		if !seen.Before(asset.LastSeen) {
+			asset.LastSeen, asset.LastRun = seen, date
+			asset.Record = record
+			asset.Domain = rootDomain(record.Host, domains)
+		}
+		for _, source := range sources[record.Host] {
+			if !SliceContainsString(asset.Sources, source) {
+				asset.Sources = append(asset.Sources, source)
+			}
+		}
+	}
+	batch := &kvBatch{}
+	for host, asset := range assets {
+		if err := putJSON(batch, inventoryAssetKey(program_name, host), asset); err != nil {
+			return nil, err
+		}
+	}
+	if err := putJSON(batch, inventoryRunKey(program_name, date), InventoryRun{Date: date, Finished: seen, Hosts: len(records)}); err != nil {
+		return nil, err
+	}
+	if err := putJSON(batch, inventoryProgramKey(program_name), time.Now()); err != nil {
+		return nil, err
+	}
+	sort.Strings(added)
+	return added, store.kv.Write(batch)
+}
+
+// function to open the inventory store for writing under the inventory lock, and call update with it
+func updateInventory(update func(store *inventoryStore) error) error {
+	unlock, err := lockInventory()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	store, err := openInventory(true)
+	if err != nil {
+		return err
+	}
+	if err := update(store); err != nil {
+		store.Close()
+		return err
+	}
+	return store.Close()
+}
+
+// function to add a finished run to its program's inventory. returns the hosts which had never been seen before.
+func UpdateInventory(program_name string, date string) ([]string, error) {
+	var added []string
+	err := updateInventory(func(store *inventoryStore) (err error) {
+		added, err = store.addRun(program_name, date)
+		return err
+	})
+	return added, err
+}
+
+// function to import every complete run of a program which isn't in its inventory yet. returns the dates imported.
+func ImportRuns(program_name string) ([]string, error) {
+	imported := []string{}
+	err := updateInventory(func(store *inventoryStore) error {
+		for _, run := range ListRuns(program_name) {
+			if run.Status != RunStatusComplete {
+				continue
+			}
+			if ok, err := store.get(inventoryRunKey(program_name, run.Date), &InventoryRun{}); ok || err != nil {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := store.addRun(program_name, run.Date); err != nil {
+				return err
+			}
+			imported = append(imported, run.Date)
+		}
+		return nil
+	})
+	return imported, err
+}
+
+// function to take a program's inventory out of the store. if keep is set, it is first written to its program directory,
+// from where it is moved back into the store if the program directory is ever restored.
+func removeInventory(program_name string, keep bool) error {
+	return updateInventory(func(store *inventoryStore) error {
+		if keep {
+			inventory, err := store.inventory(program_name)
+			if err != nil {
+				return err
+			}
+			if len(inventory.Runs) > 0 {
+				b, err := json.Marshal(inventory)
+				if err != nil {
+					return err
+				}
+				if err := os.WriteFile(ProgramsDirectory+program_name+"/"+legacyInventoryFile, b, 0644); err != nil {
+					return err
+				}
+			}
+		}
+		batch := &kvBatch{}
+		store.deleteProgram(batch, program_name)
+		return store.kv.Write(batch)
+	})
+}
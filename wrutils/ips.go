@@ -0,0 +1,85 @@
+package wrutils
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// IP RANGE INPUT FUNCTIONS
+// ./Programs/<program>/recon-data/ips.txt is an optional list of IP addresses and CIDR ranges owned by the program, one per line.
+// the ranges are swept with reverse DNS (PTR) lookups to find hostnames that passive sources don't know about.
+
+// function to parse IP addresses and CIDR ranges. comments (#) and blank lines are skipped, single addresses become /32 (or /128) ranges.
+// the invalid entries are returned with their line numbers.
+func ParseIPRanges(lines []string) ([]netip.Prefix, []DomainError) {
+	var prefixes []netip.Prefix
+	var invalid []DomainError
+	seen := make(map[netip.Prefix]bool)
+	for index, line := range lines {
+		entry := line
+		if comment := strings.Index(entry, "#"); comment != -1 {
+			entry = entry[:comment]
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var prefix netip.Prefix
+		var err error
+		if strings.Contains(entry, "/") {
+			prefix, err = netip.ParsePrefix(entry)
+		} else {
+			var addr netip.Addr
+			addr, err = netip.ParseAddr(entry)
+			if err == nil {
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+		}
+		if err != nil {
+			invalid = append(invalid, DomainError{Line: index + 1, Entry: strings.TrimSpace(line), Err: errors.New("not an IP address or CIDR range")})
+			continue
+		}
+		prefix = prefix.Masked()
+		if !seen[prefix] {
+			seen[prefix] = true
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes, invalid
+}
+
+// function to read ./Programs/<program>/recon-data/ips.txt. returns no ranges if the file doesn't exist.
+func ReadIPRanges(program_name string) ([]netip.Prefix, error) {
+	path := "./Programs/" + program_name + "/recon-data/ips.txt"
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil
+	}
+	prefixes, invalid := ParseIPRanges(WordlistToArray(path))
+	if len(invalid) > 0 {
+		var messages []string
+		for _, e := range invalid {
+			messages = append(messages, e.Error())
+		}
+		return nil, fmt.Errorf("invalid entries in %s:\n\t%s", path, strings.Join(messages, "\n\t"))
+	}
+	return prefixes, nil
+}
+
+// returns the number of addresses in the ranges, capped at limit+1 so huge IPv6 ranges don't overflow
+func CountAddresses(prefixes []netip.Prefix, limit int) int {
+	total := 0
+	for _, prefix := range prefixes {
+		host_bits := prefix.Addr().BitLen() - prefix.Bits()
+		if host_bits >= 31 {
+			return limit + 1
+		}
+		total += 1 << host_bits
+		if total > limit {
+			return limit + 1
+		}
+	}
+	return total
+}
@@ -0,0 +1,369 @@
+package wrutils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// KEY VALUE STORE FUNCTIONS
+// a small embedded key value store, kept in one file: a log which batches of puts and deletes are appended to, each batch
+// ending in a commit record holding the checksum of the batch, and an index (<path>.idx) of where the latest value of
+// every key is in the log. opening the store reads the index and only the batches committed after it was written, and
+// a value is read straight from its place in the log. a batch cut short by a crash has no commit record and is ignored,
+// so a batch is written whole or not at all. the log is rewritten without its overwritten values once they are most of it.
+// one process writes at a time, under a lock of the caller's; readers need no lock, as the log is only ever appended to.
+
+const kvMagic = "WRKV1\n"
+
+// the kinds of record in the log. every record is the kind, the key and value lengths as big endian uint32s, the key
+// and the value. the value of a commit is the CRC32 of the records of its batch.
+const (
+	kvPut    byte = 1
+	kvDelete byte = 2
+	kvCommit byte = 3
+)
+
+const kvRecordHeader = 9
+
+// kvValue is where a value is in the log.
+type kvValue struct {
+	Offset int64
+	Length int
+}
+
+// kvIndex is the contents of an index file: the value of every key in the log up to Size.
+type kvIndex struct {
+	Generation int64
+	Size       int64
+	Values     map[string]kvValue
+}
+
+// kvStore is an open key value store.
+type kvStore struct {
+	path     string
+	file     *os.File
+	writable bool
+	// the log is given a new generation whenever it is rewritten, so an index is only used with the log it was written for
+	generation int64
+	// the end of the last committed batch, which the next one is written at
+	size int64
+	// the end of the log covered by the index file
+	indexed int64
+	values  map[string]kvValue
+	// the bytes of the latest values of the keys, against which the log is compacted
+	live int64
+}
+
+// kvBatch is a set of puts and deletes written to a store at once.
+type kvBatch struct {
+	buffer bytes.Buffer
+}
+
+// function to add a record to the batch
+func (batch *kvBatch) add(kind byte, key string, value []byte) {
+	var header [kvRecordHeader]byte
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:5], uint32(len(key)))
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(value)))
+	batch.buffer.Write(header[:])
+	batch.buffer.WriteString(key)
+	batch.buffer.Write(value)
+}
+
+// function to set key to value when the batch is written
+func (batch *kvBatch) Put(key string, value []byte) {
+	batch.add(kvPut, key, value)
+}
+
+// function to delete key when the batch is written
+func (batch *kvBatch) Delete(key string) {
+	batch.add(kvDelete, key, nil)
+}
+
+// returns whether nothing has been added to the batch
+func (batch *kvBatch) Empty() bool {
+	return batch.buffer.Len() == 0
+}
+
+// function to open the store at path. a store opened for writing is created if it doesn't exist, and the caller must
+// hold a lock keeping other processes from writing it. a store which doesn't exist opens empty for reading.
+func openKVStore(path string, writable bool) (*kvStore, error) {
+	store := &kvStore{path: path, writable: writable, values: make(map[string]kvValue)}
+	var err error
+	if writable {
+		store.file, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	} else {
+		store.file, err = os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return store, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := store.load(); err != nil {
+		store.file.Close()
+		return nil, fmt.Errorf("could not read %s - %w", path, err)
+	}
+	return store, nil
+}
+
+// function to read the header of the log, writing it if the log is empty, the index and the batches committed after it
+func (store *kvStore) load() error {
+	header := make([]byte, len(kvMagic)+8)
+	if _, err := store.file.ReadAt(header, 0); errors.Is(err, io.EOF) && store.writable {
+		// the store is new, or its creation was cut short
+		if err := store.file.Truncate(0); err != nil {
+			return err
+		}
+		return store.create(store.file)
+	} else if errors.Is(err, io.EOF) {
+		// the store is being created by its writer
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if string(header[:len(kvMagic)]) != kvMagic {
+		return errors.New("not a key value store")
+	}
+	store.generation = int64(binary.BigEndian.Uint64(header[len(kvMagic):]))
+	store.size = int64(len(header))
+
+	var index kvIndex
+	if file, err := os.Open(store.path + ".idx"); err == nil {
+		err = gob.NewDecoder(bufio.NewReader(file)).Decode(&index)
+		file.Close()
+		if err == nil && index.Generation == store.generation && index.Size >= store.size {
+			store.values, store.size, store.indexed = index.Values, index.Size, index.Size
+			for _, value := range store.values {
+				store.live += int64(value.Length)
+			}
+		}
+	}
+	if err := store.replay(); err != nil {
+		return err
+	}
+	// cut off a batch left incomplete by a crash, so the next one is written straight after the last committed one
+	if store.writable {
+		return store.file.Truncate(store.size)
+	}
+	return nil
+}
+
+// function to write the header of a new log to file, starting a new generation
+func (store *kvStore) create(file *os.File) error {
+	store.generation = time.Now().UnixNano()
+	header := make([]byte, len(kvMagic)+8)
+	copy(header, kvMagic)
+	binary.BigEndian.PutUint64(header[len(kvMagic):], uint64(store.generation))
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	store.size = int64(len(header))
+	return file.Sync()
+}
+
+// function to apply the batches committed after the end of the index to the values. stops at the first batch which is
+// incomplete or doesn't match its checksum.
+func (store *kvStore) replay() error {
+	info, err := store.file.Stat()
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(io.NewSectionReader(store.file, store.size, info.Size()-store.size))
+	type record struct {
+		kind   byte
+		key    string
+		offset int64
+		length int
+	}
+	var pending []record
+	checksum := crc32.NewIEEE()
+	offset := store.size
+	header := make([]byte, kvRecordHeader)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return nil
+		}
+		kind := header[0]
+		key_length, value_length := int(binary.BigEndian.Uint32(header[1:5])), int(binary.BigEndian.Uint32(header[5:9]))
+		// the lengths of a record cut short may be anything
+		if offset+kvRecordHeader+int64(key_length+value_length) > info.Size() {
+			return nil
+		}
+		record_data := make([]byte, key_length+value_length)
+		if _, err := io.ReadFull(reader, record_data); err != nil {
+			return nil
+		}
+		switch kind {
+		case kvPut, kvDelete:
+			checksum.Write(header)
+			checksum.Write(record_data)
+			pending = append(pending, record{kind, string(record_data[:key_length]), offset + kvRecordHeader + int64(key_length), value_length})
+		case kvCommit:
+			if value_length != 4 || binary.BigEndian.Uint32(record_data[key_length:]) != checksum.Sum32() {
+				return nil
+			}
+			for _, record := range pending {
+				store.apply(record.kind, record.key, kvValue{Offset: record.offset, Length: record.length})
+			}
+			pending = pending[:0]
+			checksum.Reset()
+			store.size = offset + kvRecordHeader + int64(len(record_data))
+		default:
+			return nil
+		}
+		offset += kvRecordHeader + int64(len(record_data))
+	}
+}
+
+// function to apply a put or delete of key to the values
+func (store *kvStore) apply(kind byte, key string, value kvValue) {
+	if previous, ok := store.values[key]; ok {
+		store.live -= int64(previous.Length)
+		delete(store.values, key)
+	}
+	if kind == kvPut {
+		store.values[key] = value
+		store.live += int64(value.Length)
+	}
+}
+
+// function to read the value of key. returns false if the store doesn't have it.
+func (store *kvStore) Get(key string) ([]byte, bool, error) {
+	value, ok := store.values[key]
+	if !ok {
+		return nil, false, nil
+	}
+	b := make([]byte, value.Length)
+	if _, err := store.file.ReadAt(b, value.Offset); err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// returns whether the store has key
+func (store *kvStore) Has(key string) bool {
+	_, ok := store.values[key]
+	return ok
+}
+
+// returns the keys starting with prefix, sorted
+func (store *kvStore) Keys(prefix string) []string {
+	var keys []string
+	for key := range store.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// function to append a batch and its commit record to the log, syncing it before the values are updated
+func (store *kvStore) Write(batch *kvBatch) error {
+	if !store.writable {
+		return errors.New("the store is open for reading")
+	}
+	if batch.Empty() {
+		return nil
+	}
+	records := batch.buffer.Bytes()
+	var commit kvBatch
+	commit.add(kvCommit, "", binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(records)))
+	if _, err := store.file.WriteAt(append(records, commit.buffer.Bytes()...), store.size); err != nil {
+		store.file.Truncate(store.size)
+		return err
+	}
+	if err := store.file.Sync(); err != nil {
+		return err
+	}
+	offset := store.size
+	for offset-store.size < int64(len(records)) {
+		header := records[offset-store.size:]
+		key_length, value_length := int(binary.BigEndian.Uint32(header[1:5])), int(binary.BigEndian.Uint32(header[5:9]))
+		key := string(header[kvRecordHeader : kvRecordHeader+key_length])
+		store.apply(header[0], key, kvValue{Offset: offset + kvRecordHeader + int64(key_length), Length: value_length})
+		offset += kvRecordHeader + int64(key_length+value_length)
+	}
+	store.size += int64(len(records) + commit.buffer.Len())
+	return nil
+}
+
+// function to close the store. a store open for writing first compacts the log if most of it is overwritten values, and
+// writes the index, so the batches written since are read from it the next time the store is opened.
+func (store *kvStore) Close() error {
+	if store.file == nil {
+		return nil
+	}
+	defer store.file.Close()
+	if !store.writable {
+		return nil
+	}
+	if store.size > 2*store.live+1<<20 {
+		return store.compact()
+	}
+	if store.size == store.indexed {
+		return nil
+	}
+	return store.writeIndex(store.path + ".idx")
+}
+
+// function to write the index of the store to path, replacing the previous one only once it is fully written
+func (store *kvStore) writeIndex(path string) error {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(kvIndex{Generation: store.generation, Size: store.size, Values: store.values}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// function to rewrite the log with only the latest value of every key, in a new generation. readers which opened the
+// old log keep reading it, and the old index isn't used with the new log.
+func (store *kvStore) compact() error {
+	file, err := os.OpenFile(store.path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	compacted := &kvStore{path: store.path, file: file, writable: true, values: make(map[string]kvValue)}
+	err = compacted.create(file)
+	batch := &kvBatch{}
+	for _, key := range store.Keys("") {
+		if err != nil {
+			break
+		}
+		var value []byte
+		if value, _, err = store.Get(key); err == nil {
+			batch.Put(key, value)
+		}
+	}
+	if err == nil {
+		err = compacted.Write(batch)
+	}
+	if err == nil {
+		err = compacted.writeIndex(store.path + ".idx.new")
+	}
+	file.Close()
+	if err == nil {
+		err = os.Rename(store.path+".tmp", store.path)
+	}
+	if err != nil {
+		os.Remove(store.path + ".tmp")
+		os.Remove(store.path + ".idx.new")
+		return fmt.Errorf("could not compact %s - %w", store.path, err)
+	}
+	return os.Rename(store.path+".idx.new", store.path+".idx")
+}
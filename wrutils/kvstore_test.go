@@ -0,0 +1,140 @@
+package wrutils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// function to open the store at path, failing the test if it can't be
+func openTestStore(t *testing.T, path string, writable bool) *kvStore {
+	t.Helper()
+	store, err := openKVStore(path, writable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// function to fail the test unless the store has value for key
+func expectValue(t *testing.T, store *kvStore, key string, value string) {
+	t.Helper()
+	b, ok, err := store.Get(key)
+	if err != nil || !ok || string(b) != value {
+		t.Errorf("expected %s to be %q, got %q (found %v, %v)", key, value, b, ok, err)
+	}
+}
+
+func TestKVStorePutGetDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	store := openTestStore(t, path, true)
+	batch := &kvBatch{}
+	batch.Put("asset/a/com.example", []byte("apex"))
+	batch.Put("asset/a/com.example.api", []byte("api"))
+	batch.Put("asset/b/com.example", []byte("other program"))
+	if err := store.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	batch = &kvBatch{}
+	batch.Put("asset/a/com.example", []byte("apex again"))
+	batch.Delete("asset/b/com.example")
+	if err := store.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, store, "asset/a/com.example", "apex again")
+	if store.Has("asset/b/com.example") {
+		t.Error("expected the deleted key to be gone")
+	}
+	if keys := store.Keys("asset/a/"); strings.Join(keys, ",") != "asset/a/com.example,asset/a/com.example.api" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the values are read back through the index, and without it from the log
+	for _, remove_index := range []bool{false, true} {
+		if remove_index {
+			os.Remove(path + ".idx")
+		}
+		reader := openTestStore(t, path, false)
+		expectValue(t, reader, "asset/a/com.example", "apex again")
+		expectValue(t, reader, "asset/a/com.example.api", "api")
+		if reader.Has("asset/b/com.example") {
+			t.Error("expected the deleted key to stay gone")
+		}
+		reader.Close()
+	}
+}
+
+func TestKVStoreIgnoresIncompleteBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	store := openTestStore(t, path, true)
+	batch := &kvBatch{}
+	batch.Put("kept", []byte("1"))
+	if err := store.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	committed, _ := os.Stat(path)
+
+	// a batch without its commit record, as a crash mid-write leaves it
+	batch = &kvBatch{}
+	batch.Put("kept", []byte("2"))
+	batch.Put("lost", []byte("3"))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(batch.buffer.Bytes())
+	file.Close()
+
+	reader := openTestStore(t, path, false)
+	expectValue(t, reader, "kept", "1")
+	if reader.Has("lost") {
+		t.Error("expected the incomplete batch to be ignored")
+	}
+	reader.Close()
+
+	store = openTestStore(t, path, true)
+	if info, _ := os.Stat(path); info.Size() != committed.Size() {
+		t.Errorf("expected the incomplete batch to be cut off, the log is %d bytes instead of %d", info.Size(), committed.Size())
+	}
+	batch = &kvBatch{}
+	batch.Put("after", []byte("4"))
+	if err := store.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	os.Remove(path + ".idx")
+	reader = openTestStore(t, path, false)
+	expectValue(t, reader, "kept", "1")
+	expectValue(t, reader, "after", "4")
+	reader.Close()
+}
+
+func TestKVStoreCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	value := []byte(strings.Repeat("x", 64<<10))
+	for round := 0; round < 40; round++ {
+		store := openTestStore(t, path, true)
+		batch := &kvBatch{}
+		batch.Put("overwritten", value)
+		batch.Put("round", []byte{byte(round)})
+		if err := store.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 40 values of 64KiB were written, so the log was compacted at least once along the way
+	if info, _ := os.Stat(path); info.Size() > 2<<20 {
+		t.Errorf("expected the log to be compacted, it is %d bytes", info.Size())
+	}
+	reader := openTestStore(t, path, false)
+	expectValue(t, reader, "overwritten", string(value))
+	expectValue(t, reader, "round", string([]byte{39}))
+	reader.Close()
+}
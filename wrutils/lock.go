@@ -0,0 +1,131 @@
+package wrutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// PROGRAM LOCK FUNCTIONS
+// a run holds its program's lockfile (./Programs/<name>/.lock) until it ends, so two runs of a program (from the shell, the daemon
+// or the API) never write the same run directory at once. the lockfile is kept open and flocked for the whole run, so the lock goes
+// with the process holding it; a lockfile left behind by a process which no longer exists is stale and simply taken over.
+
+// LockFile is the name of a program's lockfile, in its program directory
+const LockFile = ".lock"
+
+// ProgramLock is the contents of a lockfile.
+type ProgramLock struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+// ErrProgramLocked is returned when a program is already being run.
+var ErrProgramLocked = errors.New("program is locked")
+
+// returns the path of a program's lockfile
+func LockPath(program_name string) string {
+	return ProgramsDirectory + program_name + "/" + LockFile
+}
+
+// function to read a program's lockfile. returns false if the program isn't locked.
+func ReadProgramLock(program_name string) (ProgramLock, bool) {
+	return readLock(LockPath(program_name))
+}
+
+// function to read the lockfile at path. returns false if there is none.
+func readLock(path string) (ProgramLock, bool) {
+	var lock ProgramLock
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return lock, false
+	}
+	// a lockfile which can't be parsed is still a lock; it may be mid-write
+	json.Unmarshal(b, &lock)
+	return lock, true
+}
+
+// returns whether a program is locked by a live process, and the lock
+func ProgramLocked(program_name string) (ProgramLock, bool) {
+	return lockHeld(LockPath(program_name))
+}
+
+// returns whether the lockfile at path is held by a running process, and the lock
+func lockHeld(path string) (ProgramLock, bool) {
+	lock, ok := readLock(path)
+	if !ok {
+		return lock, false
+	}
+	return lock, fileLocked(path, lock)
+}
+
+// function to lock a program for a run. returns a function releasing the lock, or an error wrapping ErrProgramLocked if
+// another live process holds it.
+func LockProgram(program_name string) (func(), error) {
+	path := LockPath(program_name)
+	unlock, lock, err := acquireLock(path)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("%w: %s is being run by process %d since %s", ErrProgramLocked, program_name, lock.PID, lock.Started.Format(time.RFC3339))
+	}
+	return unlock, err
+}
+
+// errLockHeld is returned by lockFile when another open lockfile holds the lock
+var errLockHeld = errors.New("lock is held")
+
+// function to take the lockfile at path for this process. the lockfile stays open and locked until the returned function is
+// called, which removes it if it is still ours. returns an error wrapping os.ErrExist and the lock if another process holds it.
+func acquireLock(path string) (func(), ProgramLock, error) {
+	lock := ProgramLock{PID: os.Getpid(), Started: time.Now()}
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return nil, ProgramLock{}, err
+	}
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, ProgramLock{}, err
+		}
+		if err := lockFile(file); err != nil {
+			file.Close()
+			if errors.Is(err, errLockHeld) {
+				holder, _ := readLock(path)
+				return nil, holder, fmt.Errorf("%s is held by process %d: %w", path, holder.PID, os.ErrExist)
+			}
+			return nil, ProgramLock{}, err
+		}
+		// the holder removes the lockfile as it unlocks, so the file locked here may already be gone from path. a lock on
+		// it locks nothing, so open path again.
+		if !sameFile(file, path) {
+			file.Close()
+			continue
+		}
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return nil, ProgramLock{}, err
+		}
+		if _, err := file.WriteAt(b, 0); err != nil {
+			file.Close()
+			return nil, ProgramLock{}, err
+		}
+		return func() {
+			// the lockfile is removed while it is still locked, and only if it is still the one this process wrote
+			if current, ok := readLock(path); ok && current.PID == lock.PID && sameFile(file, path) {
+				os.Remove(path)
+			}
+			file.Close()
+		}, ProgramLock{}, nil
+	}
+}
+
+// returns whether the open file is the one at path
+func sameFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, current)
+}
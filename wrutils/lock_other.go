@@ -0,0 +1,39 @@
+//go:build !unix
+
+package wrutils
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// returns whether a process exists. outside unix FindProcess fails for processes which don't exist.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
+
+// function to lock an open lockfile. outside unix there is no flock, so a lockfile is held while the process it names is
+// alive, and two processes taking over a stale lockfile at once can both get it.
+func lockFile(file *os.File) error {
+	var lock ProgramLock
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	json.Unmarshal(b, &lock)
+	if lock.PID != 0 && lock.PID != os.Getpid() && processAlive(lock.PID) {
+		return errLockHeld
+	}
+	return nil
+}
+
+// returns whether the lockfile at path is held, which outside unix is whether the process it names is alive
+func fileLocked(path string, lock ProgramLock) bool {
+	return lock.PID != 0 && processAlive(lock.PID)
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package wrutils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// returns whether a process exists. signal 0 checks the process without signalling it; EPERM means it exists but isn't ours.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// function to flock an open lockfile without waiting. returns errLockHeld if another open lockfile, in this process or
+// another, holds the lock. the kernel releases the lock when the file is closed or the process dies.
+func lockFile(file *os.File) error {
+	for attempt := 0; ; attempt++ {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return err
+		}
+		// fileLocked holds a shared lock for a moment to test the lock, so try again shortly before giving up
+		if attempt == 2 {
+			return errLockHeld
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// returns whether the lockfile at path is flocked. a shared lock is taken and dropped straight away to test it.
+func fileLocked(path string, lock ProgramLock) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return errors.Is(syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB), syscall.EWOULDBLOCK)
+}
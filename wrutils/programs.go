@@ -0,0 +1,419 @@
+package wrutils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PROGRAM & RUN FUNCTIONS
+// a program is a directory in ./Programs (./Programs/<program>/recon-data/domains.txt), and each run of WebRecon against it
+// is a date directory next to recon-data (./Programs/<program>/<date>/). archived programs are moved to ./Programs/.archive.
+
+const ProgramsDirectory = "./Programs/"
+const ArchiveDirectory = ProgramsDirectory + ".archive/"
+
+// the format of the run directory names
+const RunDateFormat = "01-02-2006"
+
+// RunInfo summarises a single run. it is stored as manifest.json in the run directory.
+type RunInfo struct {
+	Program string `json:"program"`
+	Date    string `json:"date"`
+	Status  string `json:"status"`
+	// what the run was started with, so it can be reproduced and compared with other runs: the WebRecon version, the
+	// effective options (wrrun.Options), the files it read and the tools it ran
+	Version         string          `json:"webrecon_version,omitempty"`
+	Options         json.RawMessage `json:"options,omitempty"`
+	Inputs          []InputFile     `json:"inputs,omitempty"`
+	Tools           []ToolInfo      `json:"tools,omitempty"`
+	Started         time.Time       `json:"started"`
+	Finished        time.Time       `json:"finished"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	Subdomains      int             `json:"subdomains"`
+	Stages          []Stage         `json:"stages,omitempty"`
+	Queries         *QueryUsage     `json:"queries,omitempty"`
+	Error           string          `json:"error,omitempty"`
+}
+
+// QueryUsage is the DNS queries a run used of its budget (0 for none), and the stages it truncated when it ran out.
+type QueryUsage struct {
+	Budget    int      `json:"budget,omitempty"`
+	Used      int      `json:"used"`
+	Truncated []string `json:"truncated,omitempty"`
+}
+
+// Stage is the output count and run time of a single stage of a run (a tool, a resolution round, ...).
+type Stage struct {
+	Name            string    `json:"name"`
+	Count           int       `json:"count"`
+	Started         time.Time `json:"started"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Error           string    `json:"error,omitempty"`
+	// why the stage was stopped before it finished (it timed out or stalled), in which case its count is of partial results
+	Stopped string `json:"stopped,omitempty"`
+}
+
+// InputFile is a file a run read, e.g. its domains or the resolvers list.
+type InputFile struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Lines  int    `json:"lines"`
+}
+
+// ToolInfo is a tool a run ran, where it was found and the version it reported.
+type ToolInfo struct {
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// guards RunInfo.Stages, as the phase 1 stages finish concurrently
+var stageMutex sync.Mutex
+
+// run statuses
+const (
+	RunStatusRunning    = "running"
+	RunStatusComplete   = "complete"
+	RunStatusIncomplete = "incomplete"
+	RunStatusFailed     = "failed"
+	RunStatusCancelled  = "cancelled"
+)
+
+// returns the run duration, rounded to the second
+func (r RunInfo) Duration() time.Duration {
+	return (time.Duration(r.DurationSeconds) * time.Second).Round(time.Second)
+}
+
+// function to run a stage, recording its count, run time and error in the run's stages. stage returns its output count.
+func (r *RunInfo) TimeStage(name string, stage func() (int, error)) (int, error) {
+	start := time.Now()
+	count, err := stage()
+	result := Stage{Name: name, Count: count, Started: start, DurationSeconds: time.Since(start).Seconds()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	stageMutex.Lock()
+	r.Stages = append(r.Stages, result)
+	stageMutex.Unlock()
+	return count, err
+}
+
+// returns the stage with the given name, and whether the run has it
+func (r RunInfo) Stage(name string) (Stage, bool) {
+	for _, stage := range r.Stages {
+		if stage.Name == name {
+			return stage, true
+		}
+	}
+	return Stage{}, false
+}
+
+// function to record why a stage was stopped before it finished
+func (r *RunInfo) StopStage(name string, reason string) {
+	stageMutex.Lock()
+	defer stageMutex.Unlock()
+	for index := len(r.Stages) - 1; index >= 0; index-- {
+		if r.Stages[index].Name == name {
+			r.Stages[index].Stopped = reason
+			return
+		}
+	}
+}
+
+// returns the stage duration, rounded to the millisecond
+func (s Stage) Duration() time.Duration {
+	return time.Duration(s.DurationSeconds * float64(time.Second)).Round(time.Millisecond)
+}
+
+// returns the path of a run directory
+func RunDirectory(program_name string, date string) string {
+	return ProgramsDirectory + program_name + "/" + date + "/"
+}
+
+// function to write a run's manifest.json
+func WriteRunInfo(info RunInfo) error {
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(RunDirectory(info.Program, info.Date)+"manifest.json", b, 0644)
+}
+
+// returns whether a run whose manifest says it is running was left behind by a process which exited (or was killed) before
+// recording how it ended: its program isn't locked by a live process, or the lock was taken after the run started, by a
+// later run.
+func runAbandoned(info RunInfo) bool {
+	lock, locked := ProgramLocked(info.Program)
+	return !locked || lock.Started.Sub(info.Started) > time.Minute
+}
+
+// function to read a run's manifest.json. runs made before manifests were written are summarised from the files in the run directory.
+// a run the manifest says is running, but which was abandoned, is read as failed.
+func ReadRunInfo(program_name string, date string) RunInfo {
+	run_directory := RunDirectory(program_name, date)
+	info := RunInfo{Program: program_name, Date: date}
+	if b, err := os.ReadFile(run_directory + "manifest.json"); err == nil {
+		if json.Unmarshal(b, &info) == nil {
+			if info.Status == RunStatusRunning && runAbandoned(info) {
+				info.Status = RunStatusFailed
+				if info.Error == "" {
+					info.Error = "the run's process exited without recording how the run ended"
+				}
+			}
+			return info
+		}
+	}
+
+	// no manifest; estimate the run time from the oldest and newest files in the run directory
+	entries, _ := os.ReadDir(run_directory)
+	for _, entry := range entries {
+		file_info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.Started.IsZero() || file_info.ModTime().Before(info.Started) {
+			info.Started = file_info.ModTime()
+		}
+		if file_info.ModTime().After(info.Finished) {
+			info.Finished = file_info.ModTime()
+		}
+	}
+	info.DurationSeconds = info.Finished.Sub(info.Started).Seconds()
+	info.Status = RunStatusIncomplete
+	if _, err := os.Stat(run_directory + "final_list_unique.out"); err == nil {
+		info.Status = RunStatusComplete
+		info.Subdomains = CountLines(run_directory + "final_list_unique.out")
+	}
+	return info
+}
+
+// function to count the lines in a file. returns 0 if the file can't be read.
+func CountLines(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	count := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		count += 1
+	}
+	return count
+}
+
+// function to describe a file a run reads, as name. returns an error if it can't be read.
+func ReadInputFile(name string, path string) (InputFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return InputFile{}, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	lines := 0
+	reader := bufio.NewReader(io.TeeReader(file, hash))
+	last := byte('\n')
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(chunk) > 0 {
+			last = chunk[len(chunk)-1]
+			if last == '\n' {
+				lines += 1
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil && err != bufio.ErrBufferFull {
+			return InputFile{}, err
+		}
+	}
+	// a last line without a newline is still a line
+	if last != '\n' {
+		lines += 1
+	}
+	return InputFile{Name: name, Path: path, SHA256: hex.EncodeToString(hash.Sum(nil)), Lines: lines}, nil
+}
+
+var programNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// returns whether a program name is safe to use as a directory name in ./Programs
+func ValidProgramName(program_name string) bool {
+	return programNameRegex.MatchString(program_name)
+}
+
+// returns whether a program exists (has a directory in ./Programs)
+func ProgramExists(program_name string) bool {
+	if !ValidProgramName(program_name) {
+		return false
+	}
+	info, err := os.Stat(ProgramsDirectory + program_name)
+	return err == nil && info.IsDir()
+}
+
+// function to list the names of all programs in ./Programs, sorted by name
+func ListPrograms() []string {
+	var programs []string
+	entries, _ := os.ReadDir(ProgramsDirectory)
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name()[0] != '.' {
+			programs = append(programs, entry.Name())
+		}
+	}
+	return programs
+}
+
+// function to list the runs of a program, oldest first
+func ListRuns(program_name string) []RunInfo {
+	var dates []time.Time
+	entries, _ := os.ReadDir(ProgramsDirectory + program_name)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		date, err := time.Parse(RunDateFormat, entry.Name())
+		if err != nil {
+			continue
+		}
+		dates = append(dates, date)
+	}
+	sort.Slice(dates, func(a, b int) bool { return dates[a].Before(dates[b]) })
+
+	runs := []RunInfo{}
+	for _, date := range dates {
+		runs = append(runs, ReadRunInfo(program_name, date.Format(RunDateFormat)))
+	}
+	return runs
+}
+
+// function to find the latest complete run of a program before the given run. returns false if there isn't one.
+func PreviousRun(program_name string, date string) (RunInfo, bool) {
+	current, err := time.Parse(RunDateFormat, date)
+	if err != nil {
+		return RunInfo{}, false
+	}
+	runs := ListRuns(program_name)
+	for index := len(runs) - 1; index >= 0; index-- {
+		run_date, _ := time.Parse(RunDateFormat, runs[index].Date)
+		if run_date.Before(current) && runs[index].Status == RunStatusComplete {
+			return runs[index], true
+		}
+	}
+	return RunInfo{}, false
+}
+
+// function to compare the subdomains found by two runs. returns the subdomains only found by the current run, and those only found by the previous one.
+func DiffRuns(program_name string, previous_date string, date string) ([]string, []string) {
+	previous := WordlistToArray(RunDirectory(program_name, previous_date) + "final_list_unique.out")
+	current := WordlistToArray(RunDirectory(program_name, date) + "final_list_unique.out")
+	return DiffHostLists(previous, current)
+}
+
+// returns the hosts in current which aren't in previous (added), and those in previous which aren't in current (removed), sorted
+func DiffHostLists(previous []string, current []string) ([]string, []string) {
+	previous_set := make(map[string]bool)
+	for _, host := range previous {
+		previous_set[host] = true
+	}
+	current_set := make(map[string]bool)
+	added := []string{}
+	for _, host := range current {
+		current_set[host] = true
+		if !previous_set[host] {
+			added = append(added, host)
+		}
+	}
+	removed := []string{}
+	for _, host := range previous {
+		if !current_set[host] {
+			removed = append(removed, host)
+		}
+	}
+	added, removed = removeDuplicateString(added), removeDuplicateString(removed)
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// returns the path of a program's recon-data directory, where its domains.txt, scope.txt and ips.txt are kept
+func ReconDataDirectory(program_name string) string {
+	return ProgramsDirectory + program_name + "/recon-data/"
+}
+
+// function to create the directory structure for a program. creating a program which exists does nothing.
+func CreateProgram(program_name string) error {
+	if !ValidProgramName(program_name) {
+		return errors.New("invalid program name " + program_name + ". Use letters, numbers, '.', '_' and '-'")
+	}
+	return os.MkdirAll(ReconDataDirectory(program_name), os.ModePerm)
+}
+
+// function to replace a program's domains.txt with domains, one per line
+func WriteProgramDomains(program_name string, domains []string) error {
+	content := ""
+	if len(domains) > 0 {
+		content = strings.Join(domains, "\n") + "\n"
+	}
+	return os.WriteFile(ReconDataDirectory(program_name)+"domains.txt", []byte(content), 0644)
+}
+
+// function to move a program into ./Programs/.archive, where it is hidden from listing and can't be run. returns an error
+// wrapping ErrProgramLocked if the program is being run.
+func ArchiveProgram(program_name string) error {
+	if !ProgramExists(program_name) {
+		return errors.New("program " + program_name + " does not exist")
+	}
+	// the program is locked while it is moved, so no run starts in it meanwhile
+	unlock, err := LockProgram(program_name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.MkdirAll(ArchiveDirectory, os.ModePerm); err != nil {
+		return err
+	}
+	// the program's inventory goes along with it
+	if err := removeInventory(program_name, true); err != nil {
+		return fmt.Errorf("could not take the inventory of %s out of %s - %w", program_name, InventoryPath(), err)
+	}
+	destination := ArchiveDirectory + program_name
+	if _, err := os.Stat(destination); err == nil {
+		destination += "-" + time.Now().Format("2006-01-02T15-04-05")
+	}
+	if err := os.Rename(filepath.Clean(ProgramsDirectory+program_name), filepath.Clean(destination)); err != nil {
+		return err
+	}
+	// the lockfile went along with the program
+	os.Remove(filepath.Join(destination, LockFile))
+	return nil
+}
+
+// function to delete a program and all of its runs. returns an error wrapping ErrProgramLocked if the program is being run.
+func DeleteProgram(program_name string) error {
+	if !ProgramExists(program_name) {
+		return errors.New("program " + program_name + " does not exist")
+	}
+	unlock, err := LockProgram(program_name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := removeInventory(program_name, false); err != nil {
+		return fmt.Errorf("could not remove the inventory of %s from %s - %w", program_name, InventoryPath(), err)
+	}
+	return os.RemoveAll(ProgramsDirectory + program_name)
+}
@@ -0,0 +1,185 @@
+package wrutils
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// INVENTORY QUERY FUNCTIONS
+// an AssetQuery selects the assets of program inventories. every filter that is set must match; the records an asset is
+// matched against are those of the latest run that found it.
+
+// AssetQuery is a set of filters over inventory assets. Zero values don't filter.
+type AssetQuery struct {
+	Domains     []string
+	Sources     []string
+	FirstAfter  time.Time
+	FirstBefore time.Time
+	Addresses   []netip.Prefix
+	CNAMEs      []string
+	Providers   []string
+	Statuses    []string
+	Alive       bool
+	Dead        bool
+}
+
+// ProgramAsset is an asset and the program whose inventory it is in.
+type ProgramAsset struct {
+	Program string `json:"program"`
+	*Asset
+}
+
+// returns whether an asset answered the HTTP probe in the latest run that found it
+func (a *Asset) IsAlive() bool {
+	return a.Record.HTTP != nil && a.Record.HTTP.Status != 0
+}
+
+// function to parse a date filter. accepts YYYY-MM-DD, the run directory format (MM-DD-YYYY) or an age such as 7d, 12h or 30m.
+func ParseQueryTime(value string) (time.Time, error) {
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+	if age, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-age), nil
+	}
+	for _, layout := range []string{"2006-01-02", RunDateFormat, time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %s, expected YYYY-MM-DD, MM-DD-YYYY or an age such as 7d", value)
+}
+
+// function to parse an address filter, either a single IP or a CIDR range
+func ParseQueryAddress(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// matches a pattern against a value, as a glob if it contains wildcards and as a case insensitive substring otherwise
+func matchPattern(pattern string, value string) bool {
+	pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := path.Match(pattern, value)
+		return matched
+	}
+	return strings.Contains(value, pattern)
+}
+
+// matches an HTTP status against a status filter such as 200 or 3xx
+func matchStatus(filter string, status int) bool {
+	code := strconv.Itoa(status)
+	filter = strings.ToLower(filter)
+	if len(filter) != len(code) {
+		return false
+	}
+	for index := range filter {
+		if filter[index] != 'x' && filter[index] != code[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// returns whether any of values matches
+func anyMatch(values []string, match func(string) bool) bool {
+	for _, value := range values {
+		if match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns whether an asset matches every filter of the query
+func (q AssetQuery) Match(asset *Asset) bool {
+	if len(q.Domains) > 0 && !anyMatch(q.Domains, func(domain string) bool {
+		return asset.Domain == domain || asset.Host == domain || IsSubdomainOf(asset.Host, domain)
+	}) {
+		return false
+	}
+	if len(q.Sources) > 0 && !anyMatch(q.Sources, func(source string) bool { return SliceContainsString(asset.Sources, source) }) {
+		return false
+	}
+	if !q.FirstAfter.IsZero() && asset.FirstSeen.Before(q.FirstAfter) {
+		return false
+	}
+	if !q.FirstBefore.IsZero() && !asset.FirstSeen.Before(q.FirstBefore) {
+		return false
+	}
+	if len(q.Addresses) > 0 && !anyMatch(asset.Record.Addresses(), func(address string) bool {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return false
+		}
+		for _, prefix := range q.Addresses {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}) {
+		return false
+	}
+	if len(q.CNAMEs) > 0 && !anyMatch(q.CNAMEs, func(pattern string) bool {
+		return anyMatch(asset.Record.CNAME, func(cname string) bool { return matchPattern(pattern, cname) })
+	}) {
+		return false
+	}
+	if len(q.Providers) > 0 && !anyMatch(q.Providers, func(pattern string) bool {
+		for _, info := range asset.Record.IPs {
+			if info.Provider != "" && matchPattern(pattern, info.Provider) || info.Org != "" && matchPattern(pattern, info.Org) {
+				return true
+			}
+		}
+		return false
+	}) {
+		return false
+	}
+	if len(q.Statuses) > 0 && (!asset.IsAlive() || !anyMatch(q.Statuses, func(status string) bool { return matchStatus(status, asset.Record.HTTP.Status) })) {
+		return false
+	}
+	if q.Alive && !asset.IsAlive() {
+		return false
+	}
+	if q.Dead && asset.IsAlive() {
+		return false
+	}
+	return true
+}
+
+// function to query the inventories of programs. returns the matching assets sorted by program and host. only the assets
+// of the programs, and the domains if the query has any, are read from the inventory store.
+func QueryInventories(programs []string, query AssetQuery) ([]ProgramAsset, error) {
+	store, err := openInventory(false)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the inventory - %v", err)
+	}
+	defer store.Close()
+	results := []ProgramAsset{}
+	for _, program_name := range programs {
+		assets, err := store.assets(program_name, query.Domains)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the inventory of %s - %v", program_name, err)
+		}
+		for _, asset := range assets {
+			if query.Match(asset) {
+				results = append(results, ProgramAsset{Program: program_name, Asset: asset})
+			}
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,223 @@
+package wrutils
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DNS RECORD FUNCTIONS
+// puredns keeps the answers it receives in massdns "simple" format (one "<name>. <type> <value>" answer per line).
+// after a run those answers are collected for every subdomain in final_list_unique.out and stored as dns_records.json.
+
+// HostRecord holds the DNS records (and enrichment) of a single subdomain. A and AAAA include addresses reached through CNAMEs.
+type HostRecord struct {
+	Host  string    `json:"host"`
+	A     []string  `json:"a,omitempty"`
+	AAAA  []string  `json:"aaaa,omitempty"`
+	CNAME []string  `json:"cname,omitempty"`
+	IPs   []IPInfo  `json:"ips,omitempty"`
+	HTTP  *HTTPInfo `json:"http,omitempty"`
+}
+
+// HTTPInfo is the response of a host to an HTTP probe. Error is set if neither https:// nor http:// answered.
+type HTTPInfo struct {
+	URL      string `json:"url,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Server   string `json:"server,omitempty"`
+	Location string `json:"location,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// IPInfo is the ASN, organisation and hosting provider of an address.
+type IPInfo struct {
+	IP       string `json:"ip"`
+	ASN      int    `json:"asn,omitempty"`
+	Org      string `json:"org,omitempty"`
+	Country  string `json:"country,omitempty"`
+	Provider string `json:"provider,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+}
+
+// returns every address of a host
+func (r HostRecord) Addresses() []string {
+	return append(append([]string{}, r.A...), r.AAAA...)
+}
+
+// answers read from massdns files, keyed by name
+type massdnsAnswers map[string]map[string][]string
+
+// function to read answers from massdns simple output files. missing files are skipped.
+func readMassdnsAnswers(paths []string) massdnsAnswers {
+	answers := make(massdnsAnswers)
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 3 {
+				continue
+			}
+			name := strings.TrimSuffix(strings.ToLower(fields[0]), ".")
+			record_type := strings.ToUpper(fields[1])
+			value := strings.TrimSuffix(strings.ToLower(fields[len(fields)-1]), ".")
+			if answers[name] == nil {
+				answers[name] = make(map[string][]string)
+			}
+			if !SliceContainsString(answers[name][record_type], value) {
+				answers[name][record_type] = append(answers[name][record_type], value)
+			}
+		}
+		file.Close()
+	}
+	return answers
+}
+
+// builds the record of a host, following CNAMEs to the addresses they point to
+func (answers massdnsAnswers) record(host string) HostRecord {
+	record := HostRecord{Host: host}
+	name := host
+	for depth := 0; depth < 10; depth++ {
+		record.A = append(record.A, answers[name]["A"]...)
+		record.AAAA = append(record.AAAA, answers[name]["AAAA"]...)
+		targets := answers[name]["CNAME"]
+		if len(targets) == 0 {
+			break
+		}
+		record.CNAME = append(record.CNAME, targets...)
+		name = targets[0]
+	}
+	record.A = removeDuplicateString(record.A)
+	record.AAAA = removeDuplicateString(record.AAAA)
+	return record
+}
+
+// function to build the DNS records of every subdomain in final_list_unique.out from the massdns files written by puredns
+func BuildDNSRecords(program_name string, date string) []HostRecord {
+	data_directory := "./Programs/" + program_name + "/" + date + "/"
+	answers := readMassdnsAnswers([]string{data_directory + "puredns-stage-1.massdns", data_directory + "dnsgen-puredns.massdns"})
+
+	var records []HostRecord
+	for _, host := range WordlistToArray(data_directory + "final_list_unique.out") {
+		host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+		if host == "" {
+			continue
+		}
+		records = append(records, answers.record(host))
+	}
+	sort.Slice(records, func(a, b int) bool { return records[a].Host < records[b].Host })
+	return records
+}
+
+// function to read the wildcard roots puredns detected during a run (only written when wildcard filtering is on)
+func ReadWildcardZones(program_name string, date string) []string {
+	data_directory := "./Programs/" + program_name + "/" + date + "/"
+	var zones []string
+	for _, path := range []string{data_directory + "puredns-stage-1.wildcards", data_directory + "dnsgen-puredns.wildcards"} {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		for _, zone := range WordlistToArray(path) {
+			zone = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(zone)), ".")
+			if zone != "" {
+				zones = append(zones, zone)
+			}
+		}
+	}
+	zones = removeDuplicateString(zones)
+	sort.Strings(zones)
+	return zones
+}
+
+// the files each enumeration source writes its subdomains to, in the order sources are listed
+var sourceFiles = []struct {
+	source string
+	file   string
+}{
+	{"subfinder", "subfinder.out"},
+	{"amass", "amass.out"},
+	{"sub-generator", "sub-generator.out"},
+	{"ptr-sweep", "ptr-sweep.out"},
+	{"dnsgen", "dnsgen-puredns.out"},
+}
+
+// function to find which sources discovered each subdomain of a run, from the output files in its directory.
+// "dnsgen" is only listed for subdomains none of the other sources found. the source files hold every candidate, most of
+// which never resolved, so they are streamed and only the subdomains in final_list_unique.out are kept.
+func HostSources(program_name string, date string) map[string][]string {
+	data_directory := "./Programs/" + program_name + "/" + date + "/"
+	sources := make(map[string][]string)
+	for _, host := range WordlistToArray(data_directory + "final_list_unique.out") {
+		if host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), "."); host != "" {
+			sources[host] = nil
+		}
+	}
+	for _, source := range sourceFiles {
+		file, err := os.Open(data_directory + source.file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+		for scanner.Scan() {
+			// amass may write "<name> (FQDN) --> ..." lines, the name is always the first field
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 {
+				continue
+			}
+			host := strings.TrimSuffix(strings.ToLower(fields[0]), ".")
+			found, resolved := sources[host]
+			if !resolved || (source.source == "dnsgen" && len(found) > 0) {
+				continue
+			}
+			if !SliceContainsString(found, source.source) {
+				sources[host] = append(found, source.source)
+			}
+		}
+		file.Close()
+	}
+	return sources
+}
+
+// function to write a run's dns_records.json
+func WriteDNSRecords(program_name string, date string, records []HostRecord) error {
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile("./Programs/"+program_name+"/"+date+"/dns_records.json", b, 0644)
+}
+
+// function to read a run's dns_records.json
+func ReadDNSRecords(program_name string, date string) ([]HostRecord, error) {
+	var records []HostRecord
+	b, err := os.ReadFile("./Programs/" + program_name + "/" + date + "/dns_records.json")
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &records)
+	return records, err
+}
+
+// function to read the hosts found by a run. runs made before dns_records.json existed only have their final list, so their hosts have no records.
+func ReadRunHosts(program_name string, date string) []HostRecord {
+	records, err := ReadDNSRecords(program_name, date)
+	if err == nil {
+		return records
+	}
+	records = []HostRecord{}
+	for _, host := range WordlistToArray("./Programs/" + program_name + "/" + date + "/final_list_unique.out") {
+		host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+		if host != "" {
+			records = append(records, HostRecord{Host: host})
+		}
+	}
+	return records
+}
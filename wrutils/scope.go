@@ -0,0 +1,247 @@
+package wrutils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/sammooredev/WebRecon/wrlog"
+)
+
+// SCOPE FUNCTIONS
+// scope rules are read from ./Programs/<program>/recon-data/scope.txt. one rule per line:
+//
+//	# comment                  ignored, as are blank lines
+//	example.com                the host example.com only
+//	*.example.com              any subdomain of example.com (not the apex)
+//	/^api[0-9]+\.example\.com$/  any host matching the regular expression
+//	!legacy.example.com        excludes a host. "!" can prefix any of the rule types above
+//
+// when scope.txt has no include rules, every domain in domains.txt (and its subdomains) is in scope.
+// exclusions always win over includes. amass is only told about hostname exclusions (-blf), as it has no way to take a regular
+// expression, so it is run with -passive when there are regex exclusions, keeping it from resolving or brute forcing names
+// they exclude. its output is filtered by the whole scope afterwards either way.
+
+// a single scope rule. exactly one of host or regex is set.
+type scopeRule struct {
+	host     string
+	wildcard bool
+	regex    *regexp.Regexp
+}
+
+// Scope holds the include and exclude rules used to decide if a hostname may be touched.
+type Scope struct {
+	includes []scopeRule
+	excludes []scopeRule
+}
+
+// returns whether the rule matches the (lowercased, dot trimmed) hostname
+func (r scopeRule) matches(host string) bool {
+	if r.regex != nil {
+		return r.regex.MatchString(host)
+	}
+	if r.wildcard {
+		return host != r.host && IsSubdomainOf(host, r.host)
+	}
+	return host == r.host
+}
+
+// returns the rule in the same syntax it is written in scope.txt
+func (r scopeRule) String() string {
+	if r.regex != nil {
+		return "/" + r.regex.String() + "/"
+	}
+	if r.wildcard {
+		return "*." + r.host
+	}
+	return r.host
+}
+
+// parses a single scope rule (without the "!" prefix)
+func parseScopeRule(rule string) (scopeRule, error) {
+	if len(rule) > 1 && strings.HasPrefix(rule, "/") && strings.HasSuffix(rule, "/") {
+		regex, err := regexp.Compile(rule[1 : len(rule)-1])
+		if err != nil {
+			return scopeRule{}, err
+		}
+		return scopeRule{regex: regex}, nil
+	}
+	wildcard := strings.HasPrefix(rule, "*.")
+	host, err := NormalizeDomain(strings.TrimPrefix(rule, "*."))
+	if err != nil || strings.Contains(rule, "/") {
+		return scopeRule{}, errors.New("invalid scope rule " + rule)
+	}
+	return scopeRule{host: host, wildcard: wildcard}, nil
+}
+
+// parses scope rules from lines in the scope.txt syntax. domains are used as the includes when no include rules are given.
+func ParseScopeRules(lines []string, domains []string) (*Scope, error) {
+	scope := &Scope{}
+	for index, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exclude := strings.HasPrefix(line, "!")
+		rule, err := parseScopeRule(strings.TrimSpace(strings.TrimPrefix(line, "!")))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", index+1, err)
+		}
+		if exclude {
+			scope.excludes = append(scope.excludes, rule)
+		} else {
+			scope.includes = append(scope.includes, rule)
+		}
+	}
+	if len(scope.includes) == 0 {
+		for _, domain := range domains {
+			domain, err := NormalizeDomain(domain)
+			if err != nil {
+				continue
+			}
+			scope.includes = append(scope.includes, scopeRule{host: domain}, scopeRule{host: domain, wildcard: true})
+		}
+	}
+	return scope, nil
+}
+
+// function to read the scope for a program. if ./Programs/<program>/recon-data/scope.txt doesn't exist, the scope is built from domains.
+func ReadScope(program_name string, domains []string) (*Scope, error) {
+	scope_file := "./Programs/" + program_name + "/recon-data/scope.txt"
+	var lines []string
+	if _, err := os.Stat(scope_file); err == nil {
+		lines = WordlistToArray(scope_file)
+	}
+	scope, err := ParseScopeRules(lines, domains)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scope rule in %s - %v", scope_file, err)
+	}
+	return scope, nil
+}
+
+// function to load the scope for a program, exiting if scope.txt has an invalid rule
+func LoadScope(program_name string, domains []string) *Scope {
+	out := wrlog.NewConsoleOutput()
+	scope, err := ReadScope(program_name, domains)
+	if err != nil {
+		out.Writeln("\n<error>ERROR! - " + err.Error() + "</error>")
+		os.Exit(1)
+	}
+	return scope
+}
+
+// returns whether a hostname is in scope; it must match an include rule and no exclude rule.
+func (s *Scope) InScope(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	if host == "" {
+		return false
+	}
+	for _, rule := range s.excludes {
+		if rule.matches(host) {
+			return false
+		}
+	}
+	for _, rule := range s.includes {
+		if rule.matches(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns whether any exclusion is a regular expression, which ExcludedDomains leaves out
+func (s *Scope) HasRegexExclusions() bool {
+	for _, rule := range s.excludes {
+		if rule.regex != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the hostname exclusions (not regexes) as plain domains, for tools that accept a blacklist such as amass -blf.
+func (s *Scope) ExcludedDomains() []string {
+	var domains []string
+	for _, rule := range s.excludes {
+		if rule.regex == nil {
+			domains = append(domains, rule.host)
+		}
+	}
+	return domains
+}
+
+// returns the include and exclude rules in scope.txt syntax
+func (s *Scope) Rules() []string {
+	var rules []string
+	for _, rule := range s.includes {
+		rules = append(rules, rule.String())
+	}
+	for _, rule := range s.excludes {
+		rules = append(rules, "!"+rule.String())
+	}
+	return rules
+}
+
+// function to remove every out of scope line from a file, rewriting it in place. returns the number of lines removed.
+func FilterFileByScope(path string, scope *Scope) (int, error) {
+	in_file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in_file.Close()
+
+	tmp_path := path + ".scope.tmp"
+	out_file, err := os.Create(tmp_path)
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriter(out_file)
+
+	removed := 0
+	scanner := bufio.NewScanner(in_file)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !scope.InScope(line) {
+			removed += 1
+			continue
+		}
+		writer.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		out_file.Close()
+		return 0, err
+	}
+	if err := writer.Flush(); err != nil {
+		out_file.Close()
+		return 0, err
+	}
+	out_file.Close()
+
+	if err := os.Rename(tmp_path, path); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// returns the path WriteScopeBlacklist writes the exclusions of a run to
+func ScopeBlacklistPath(program_name string, date string) string {
+	return "./Programs/" + program_name + "/" + date + "/scope-blacklist.txt"
+}
+
+// function to write the hostname exclusions to a file so they can be passed to amass. returns "" when there are no exclusions.
+func WriteScopeBlacklist(program_name string, date string, scope *Scope) (string, error) {
+	excluded := scope.ExcludedDomains()
+	if len(excluded) == 0 {
+		return "", nil
+	}
+	path := ScopeBlacklistPath(program_name, date)
+	err := os.WriteFile(path, []byte(strings.Join(excluded, "\n")+"\n"), 0644)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,111 @@
+package wrutils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []string
+		domains  []string
+		in       []string
+		out      []string
+		excluded []string
+	}{
+		{
+			name:    "no rules falls back to the domains and their subdomains",
+			domains: []string{"example.com", "Example.org."},
+			in:      []string{"example.com", "api.example.com", "a.b.example.com", "example.org", "API.EXAMPLE.ORG."},
+			out:     []string{"example.net", "notexample.com", "", "com"},
+		},
+		{
+			name:     "exclusions alone still fall back to the domains",
+			rules:    []string{"# only exclusions", "", "!legacy.example.com"},
+			domains:  []string{"example.com"},
+			in:       []string{"example.com", "api.example.com", "api.legacy.example.com"},
+			out:      []string{"legacy.example.com", "other.com"},
+			excluded: []string{"legacy.example.com"},
+		},
+		{
+			name:    "include rules replace the domains",
+			rules:   []string{"shop.example.org"},
+			domains: []string{"example.com"},
+			in:      []string{"shop.example.org"},
+			out:     []string{"example.com", "api.example.com", "example.org", "www.shop.example.org"},
+		},
+		{
+			name:  "a wildcard doesn't cover its apex",
+			rules: []string{"*.example.com"},
+			in:    []string{"api.example.com", "a.b.example.com"},
+			out:   []string{"example.com", "example.com.evil.net"},
+		},
+		{
+			name:  "a wildcard and its apex",
+			rules: []string{"example.com", "*.example.com"},
+			in:    []string{"example.com", "api.example.com"},
+			out:   []string{"otherexample.com"},
+		},
+		{
+			name:  "regex rules match the whole lowercased host",
+			rules: []string{`/^api[0-9]+\.example\.com$/`},
+			in:    []string{"api1.example.com", "API22.example.com."},
+			out:   []string{"api.example.com", "api1.example.com.evil.net", "xapi1.example.com"},
+		},
+		{
+			name:     "exclusions win over includes",
+			rules:    []string{"*.example.com", "example.com", "!legacy.example.com", "!*.internal.example.com", `!/^dev-/`},
+			in:       []string{"example.com", "api.example.com", "internal.example.com", "api.legacy.example.com"},
+			out:      []string{"legacy.example.com", "vpn.internal.example.com", "dev-api.example.com"},
+			excluded: []string{"legacy.example.com", "internal.example.com"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scope, err := ParseScopeRules(test.rules, test.domains)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, host := range test.in {
+				if !scope.InScope(host) {
+					t.Errorf("expected %q to be in scope", host)
+				}
+			}
+			for _, host := range test.out {
+				if scope.InScope(host) {
+					t.Errorf("expected %q to be out of scope", host)
+				}
+			}
+			if got := strings.Join(scope.ExcludedDomains(), ","); got != strings.Join(test.excluded, ",") {
+				t.Errorf("expected the excluded domains %v, got %v", test.excluded, scope.ExcludedDomains())
+			}
+		})
+	}
+}
+
+func TestScopeRegexExclusions(t *testing.T) {
+	scope, _ := ParseScopeRules([]string{"*.example.com", "!legacy.example.com"}, nil)
+	if scope.HasRegexExclusions() {
+		t.Error("expected no regex exclusions")
+	}
+	scope, _ = ParseScopeRules([]string{"*.example.com", `!/^dev-/`}, nil)
+	if !scope.HasRegexExclusions() {
+		t.Error("expected a regex exclusion")
+	}
+	// a regex exclusion can't be passed to amass as a blacklist
+	if len(scope.ExcludedDomains()) != 0 {
+		t.Errorf("expected no excluded domains, got %v", scope.ExcludedDomains())
+	}
+}
+
+func TestParseScopeRulesErrors(t *testing.T) {
+	for _, rules := range [][]string{
+		{"example.com", "/[unclosed/"},
+		{"example.com", "!https://example.com/path"},
+	} {
+		if _, err := ParseScopeRules(rules, nil); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("expected %v to fail on line 2, got %v", rules, err)
+		}
+	}
+}
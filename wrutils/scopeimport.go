@@ -0,0 +1,249 @@
+package wrutils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// SCOPE IMPORT FUNCTIONS
+// converts the scope tables exported by bug bounty platforms (HackerOne CSV, HackerOne/Bugcrowd JSON) into domains.txt and scope.txt
+
+// ScopeAsset is a single asset read from a platform scope export.
+type ScopeAsset struct {
+	Identifier string
+	Type       string
+	InScope    bool
+}
+
+// column / key names used by the platforms for the asset identifier, asset type and whether the asset is in scope
+var scopeIdentifierKeys = []string{"identifier", "asset_identifier", "assetidentifier", "target", "name", "uri", "asset", "domain", "endpoint"}
+var scopeTypeKeys = []string{"asset_type", "assettype", "type", "category", "target_type"}
+var scopeInScopeKeys = []string{"eligible_for_submission", "eligibleforsubmission", "in_scope", "inscope", "in scope"}
+
+// asset types which can never produce hostnames
+var scopeSkippedTypes = []string{"cidr", "ip", "app_id", "apple", "android", "ios", "google_play", "mobile", "source_code", "executable", "hardware", "other", "network", "smart_contract", "windows"}
+
+// function to read the assets from a scope export file. the format is detected from the file contents.
+func ReadScopeExport(path string) ([]ScopeAsset, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := strings.TrimSpace(string(b))
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		return parseScopeJSON([]byte(trimmed))
+	}
+	return parseScopeCSV(strings.NewReader(trimmed))
+}
+
+// returns the index of the first header matching one of the keys, or -1
+func findColumn(header []string, keys []string) int {
+	for _, key := range keys {
+		for index, column := range header {
+			if strings.EqualFold(strings.TrimSpace(column), key) {
+				return index
+			}
+		}
+	}
+	return -1
+}
+
+// parses boolean-ish values used by the platforms ("true", "yes", "in scope", "1")
+func parseScopeBool(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "y", "1", "in scope", "in-scope", "in_scope":
+		return true
+	}
+	return false
+}
+
+// parses a CSV export, e.g. the HackerOne "Download CSV" scope table
+func parseScopeCSV(r io.Reader) ([]ScopeAsset, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, errors.New("scope export has no assets")
+	}
+	header := records[0]
+	identifier_column := findColumn(header, scopeIdentifierKeys)
+	if identifier_column == -1 {
+		return nil, errors.New("scope export has no identifier column (expected one of " + strings.Join(scopeIdentifierKeys, ", ") + ")")
+	}
+	type_column := findColumn(header, scopeTypeKeys)
+	in_scope_column := findColumn(header, scopeInScopeKeys)
+
+	var assets []ScopeAsset
+	for _, record := range records[1:] {
+		if identifier_column >= len(record) {
+			continue
+		}
+		asset := ScopeAsset{Identifier: strings.TrimSpace(record[identifier_column]), InScope: true}
+		if type_column != -1 && type_column < len(record) {
+			asset.Type = record[type_column]
+		}
+		if in_scope_column != -1 && in_scope_column < len(record) {
+			asset.InScope = parseScopeBool(record[in_scope_column])
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+// parses a JSON export. the document is walked recursively and every object with an identifier key is treated as an asset.
+// "in scope" flags on enclosing objects (e.g. Bugcrowd target groups) are inherited by the assets inside them.
+func parseScopeJSON(b []byte) ([]ScopeAsset, error) {
+	var document interface{}
+	if err := json.Unmarshal(b, &document); err != nil {
+		return nil, err
+	}
+	var assets []ScopeAsset
+	walkScopeJSON(document, true, &assets)
+	if len(assets) == 0 {
+		return nil, errors.New("scope export has no assets")
+	}
+	return assets, nil
+}
+
+// returns the value for the first matching key (case insensitive) in a JSON object
+func lookupJSONKey(object map[string]interface{}, keys []string) (interface{}, bool) {
+	for _, key := range keys {
+		for k, v := range object {
+			if strings.EqualFold(k, key) {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func walkScopeJSON(node interface{}, in_scope bool, assets *[]ScopeAsset) {
+	switch value := node.(type) {
+	case []interface{}:
+		for _, child := range value {
+			walkScopeJSON(child, in_scope, assets)
+		}
+	case map[string]interface{}:
+		if flag, ok := lookupJSONKey(value, scopeInScopeKeys); ok {
+			switch f := flag.(type) {
+			case bool:
+				in_scope = f
+			case string:
+				in_scope = parseScopeBool(f)
+			}
+		}
+		// HackerOne API objects keep the asset in "attributes"
+		if attributes, ok := value["attributes"].(map[string]interface{}); ok {
+			walkScopeJSON(attributes, in_scope, assets)
+			return
+		}
+		// groups of assets (e.g. Bugcrowd target groups) have a "name" too, so only leaf objects are assets
+		if identifier, ok := lookupJSONKey(value, scopeIdentifierKeys); ok && !hasNestedObjects(value) {
+			if s, ok := identifier.(string); ok && s != "" {
+				asset := ScopeAsset{Identifier: strings.TrimSpace(s), InScope: in_scope}
+				if asset_type, ok := lookupJSONKey(value, scopeTypeKeys); ok {
+					asset.Type, _ = asset_type.(string)
+				}
+				*assets = append(*assets, asset)
+				return
+			}
+		}
+		for _, child := range value {
+			walkScopeJSON(child, in_scope, assets)
+		}
+	}
+}
+
+// returns whether a JSON object contains an array of objects
+func hasNestedObjects(object map[string]interface{}) bool {
+	for _, child := range object {
+		if list, ok := child.([]interface{}); ok {
+			for _, item := range list {
+				if _, ok := item.(map[string]interface{}); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// the characters of an asset's hostname, with wildcards. like NormalizeDomain, it doesn't allow "_", so every rule written
+// to scope.txt can be read back by ReadScope.
+var assetHostRegex = regexp.MustCompile(`^[a-z0-9*]([a-z0-9*-]*[a-z0-9*])?(\.[a-z0-9*]([a-z0-9*-]*[a-z0-9*])?)+$`)
+
+// function to convert scope assets into the seed domains for domains.txt and the rules for scope.txt.
+// returns the identifiers that could not be converted in skipped.
+func ScopeAssetsToRules(assets []ScopeAsset) (domains []string, rules []string, skipped []string) {
+	var wildcard_roots []string
+	var hosts []string
+	for _, asset := range assets {
+		asset_type := strings.ToLower(asset.Type)
+		skip := false
+		for _, t := range scopeSkippedTypes {
+			if strings.Contains(asset_type, t) {
+				skip = true
+			}
+		}
+		host := stripHost(asset.Identifier)
+		if !skip && !strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			// hosts and *.<domain> wildcards are read back with NormalizeDomain, so they must pass it
+			_, err := NormalizeDomain(host)
+			skip = err != nil
+		}
+		if skip || !assetHostRegex.MatchString(host) {
+			skipped = append(skipped, asset.Identifier)
+			continue
+		}
+
+		prefix := ""
+		if !asset.InScope {
+			prefix = "!"
+		}
+		switch {
+		case strings.HasPrefix(host, "*.") && !strings.Contains(host[2:], "*"):
+			// *.example.com is only the subdomains, so the root is a seed domain but not a rule. the apex is only in scope
+			// if the platform lists it too.
+			rules = append(rules, prefix+host)
+			if asset.InScope {
+				wildcard_roots = append(wildcard_roots, host[2:])
+			}
+		case strings.Contains(host, "*"):
+			// e.g. api-*.example.com or *.example.*, converted into a regex
+			pattern := strings.ReplaceAll(regexp.QuoteMeta(host), `\*`, `[a-z0-9.-]*`)
+			rules = append(rules, prefix+"/^"+pattern+"$/")
+			root := strings.TrimLeft(host[strings.LastIndex(host, "*")+1:], ".-")
+			if asset.InScope && strings.Contains(root, ".") {
+				wildcard_roots = append(wildcard_roots, root)
+			}
+		default:
+			rules = append(rules, prefix+host)
+			if asset.InScope {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	// seed domains are the wildcard roots plus the hosts which aren't already covered by a wildcard
+	domains = append(domains, wildcard_roots...)
+	for _, host := range hosts {
+		covered := false
+		for _, root := range wildcard_roots {
+			if IsSubdomainOf(host, root) {
+				covered = true
+			}
+		}
+		if !covered {
+			domains = append(domains, host)
+		}
+	}
+	return removeDuplicateString(domains), removeDuplicateString(rules), skipped
+}
@@ -0,0 +1,123 @@
+package wrutils
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SUBDOMAIN TAKEOVER FUNCTIONS
+// a subdomain is a takeover candidate when its CNAME points at a service that lets anyone claim the name it points to.
+// candidates are rated by how likely the claim is to be dangling:
+//
+//	high    the CNAME target doesn't resolve to any address
+//	medium  the service answered the HTTP probe with a 404
+//	low     the CNAME points at the service, but it looks claimed
+
+// TakeoverCandidate is a subdomain which may be vulnerable to a subdomain takeover.
+type TakeoverCandidate struct {
+	Host       string `json:"host"`
+	CNAME      string `json:"cname"`
+	Service    string `json:"service"`
+	Confidence string `json:"confidence"`
+	Reason     string `json:"reason"`
+}
+
+// takeover confidence levels
+const (
+	TakeoverHigh   = "high"
+	TakeoverMedium = "medium"
+	TakeoverLow    = "low"
+)
+
+// services which can be claimed by anyone, matched on the suffix of the CNAME target
+var takeoverServices = []struct {
+	service  string
+	suffixes []string
+}{
+	{"AWS S3", []string{".s3.amazonaws.com", ".s3-website.amazonaws.com", "s3-website-", ".s3.dualstack."}},
+	{"AWS Elastic Beanstalk", []string{".elasticbeanstalk.com"}},
+	{"AWS CloudFront", []string{".cloudfront.net"}},
+	{"Azure", []string{".azurewebsites.net", ".cloudapp.net", ".cloudapp.azure.com", ".trafficmanager.net", ".blob.core.windows.net", ".azureedge.net", ".azure-api.net", ".azurefd.net"}},
+	{"GitHub Pages", []string{".github.io"}},
+	{"Heroku", []string{".herokuapp.com", ".herokudns.com"}},
+	{"Shopify", []string{".myshopify.com"}},
+	{"Fastly", []string{".fastly.net"}},
+	{"Pantheon", []string{".pantheonsite.io"}},
+	{"Zendesk", []string{".zendesk.com"}},
+	{"Ghost", []string{".ghost.io"}},
+	{"Surge", []string{".surge.sh"}},
+	{"Bitbucket", []string{".bitbucket.io"}},
+	{"Netlify", []string{".netlify.app", ".netlify.com"}},
+	{"Vercel", []string{".vercel.app", ".now.sh"}},
+	{"Fly.io", []string{".fly.dev"}},
+	{"Webflow", []string{".proxy-ssl.webflow.com"}},
+	{"Wordpress", []string{".wordpress.com"}},
+	{"Tumblr", []string{"domains.tumblr.com"}},
+	{"Readme.io", []string{".readme.io"}},
+	{"Help Scout", []string{".helpscoutdocs.com"}},
+	{"Unbounce", []string{"unbouncepages.com"}},
+	{"Cargo", []string{".cargocollective.com"}},
+	{"Strikingly", []string{".s.strikinglydns.com"}},
+	{"Agile CRM", []string{".agilecrm.com"}},
+}
+
+// returns the service a CNAME target belongs to, or "" if it isn't a service known to allow takeovers
+func TakeoverService(cname string) string {
+	cname = strings.TrimSuffix(strings.ToLower(cname), ".")
+	for _, service := range takeoverServices {
+		for _, suffix := range service.suffixes {
+			if strings.HasSuffix(cname, suffix) || (strings.HasSuffix(suffix, "-") && strings.Contains(cname, suffix)) {
+				return service.service
+			}
+		}
+	}
+	return ""
+}
+
+// function to find the takeover candidates in a run's DNS records, most likely first
+func FindTakeoverCandidates(records []HostRecord) []TakeoverCandidate {
+	candidates := []TakeoverCandidate{}
+	for _, record := range records {
+		for _, cname := range record.CNAME {
+			service := TakeoverService(cname)
+			if service == "" {
+				continue
+			}
+			candidate := TakeoverCandidate{Host: record.Host, CNAME: cname, Service: service, Confidence: TakeoverLow, Reason: "CNAME points at " + service}
+			if len(record.Addresses()) == 0 {
+				candidate.Confidence = TakeoverHigh
+				candidate.Reason = "CNAME target doesn't resolve"
+			} else if record.HTTP != nil && record.HTTP.Status == 404 {
+				candidate.Confidence = TakeoverMedium
+				candidate.Reason = service + " answers 404"
+			}
+			candidates = append(candidates, candidate)
+			break
+		}
+	}
+	rank := map[string]int{TakeoverHigh: 0, TakeoverMedium: 1, TakeoverLow: 2}
+	sort.SliceStable(candidates, func(a, b int) bool { return rank[candidates[a].Confidence] < rank[candidates[b].Confidence] })
+	return candidates
+}
+
+// function to write a run's takeover_candidates.json
+func WriteTakeoverCandidates(program_name string, date string, candidates []TakeoverCandidate) error {
+	b, err := json.MarshalIndent(candidates, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile("./Programs/"+program_name+"/"+date+"/takeover_candidates.json", b, 0644)
+}
+
+// function to read a run's takeover_candidates.json. returns no candidates if the file doesn't exist.
+func ReadTakeoverCandidates(program_name string, date string) []TakeoverCandidate {
+	var candidates []TakeoverCandidate
+	b, err := os.ReadFile("./Programs/" + program_name + "/" + date + "/takeover_candidates.json")
+	if err != nil {
+		return nil
+	}
+	json.Unmarshal(b, &candidates)
+	return candidates
+}
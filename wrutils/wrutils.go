@@ -3,27 +3,59 @@ package wrutils
 import (
 	"bufio"
 	"bytes"
-	"log"
+	"io"
+	"log/slog"
 	"math"
 	"os"
 	"os/exec"
 	"regexp"
+	"runtime/debug"
 	"sort"
-	"strings"
 
-	"github.com/DrSmithFr/go-console/pkg/input"
-	"github.com/DrSmithFr/go-console/pkg/output"
-	"github.com/DrSmithFr/go-console/pkg/style"
 	"github.com/jpillora/go-tld"
 )
 
 // GENERALLY USEFUL FUNCTIONS
+
+// the version of WebRecon, recorded in each run's manifest. releases set it when they are built, with
+// -ldflags "-X github.com/sammooredev/WebRecon/wrutils.Version=<version>"
+var Version = "2.0.0-dev"
+
+// returns Version, followed by the commit WebRecon was built from when the build recorded one, e.g. 2.0.0-dev+1a2b3c4d5e6f
+func BuildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return Version
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return Version
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return Version + "+" + revision
+}
+
+// function to check the tools WebRecon runs are in the PATH, exiting if one isn't
 func VerifyDependencies() {
 	commands := []string{"amass", "subfinder", "puredns", "dnsgen"}
 	for _, command := range commands {
 		_, err := exec.LookPath(command)
 		if err != nil {
-			log.Fatal("Error: dependency '" + command + "' could not be found in your PATH.")
+			slog.Error("Dependency could not be found in your PATH", "dependency", command)
+			os.Exit(1)
 		}
 	}
 }
@@ -85,24 +117,28 @@ func ConditionallyDequeueSubdomains(all_unique_subdomains *[]string, regex *rege
 	return subdomains_sorted_by_tld
 }
 
-// convert domains string array into slice, order domains in slice by length (longest to smallest), catches edge cases where top level domains includes "google.com" "foo.google.com" so that it can match "foo.google.com" entries first. length (longest to smallest), catches edge cases where top level domains includes "google.com" "foo.google.com" so that it can match "foo.google.com" entries first.
+// convert domains string array into slice, order domains in slice by length (smallest to longest) and drop every domain that is a subdomain of another entry. catches edge cases where top level domains includes "google.com" "foo.google.com" so that only "google.com" is kept. matching is done on whole labels, so "ample.com" doesn't catch "example.com".
 func CatchRedundanciesInDomains(domains []string) []string {
-	sortedDomains := domains[:] // length sorting code modified from https://code-maven.com/slides/golang/sort-strings-by-length
-	sort.Slice(sortedDomains, func(a, b int) bool {
+	sortedDomains := make([]string, len(domains)) // length sorting code modified from https://code-maven.com/slides/golang/sort-strings-by-length
+	copy(sortedDomains, domains)
+	sort.SliceStable(sortedDomains, func(a, b int) bool {
 		return len(sortedDomains[a]) < len(sortedDomains[b])
 	})
 
-	i, checkLen := 0, len(sortedDomains)
-	for i < checkLen {
-		for index, domain := range sortedDomains[i+1:] {
-			if strings.Contains(domain, sortedDomains[i]) {
-				sortedDomains = append(sortedDomains[:index+i+1], sortedDomains[index+i+2:]...)
-				checkLen -= 1
+	var uniqueDomains []string
+	for _, domain := range sortedDomains {
+		redundant := false
+		for _, kept := range uniqueDomains {
+			if IsSubdomainOf(domain, kept) {
+				redundant = true
+				break
 			}
 		}
-		i += 1
+		if !redundant {
+			uniqueDomains = append(uniqueDomains, domain)
+		}
 	}
-	return sortedDomains
+	return uniqueDomains
 }
 
 // splits the string array "wordlist_lines" into mutliple smaller string arrays and places into 2d string array "wordlist_2d_array"
@@ -120,117 +156,92 @@ func Wordlist2DArrayGenerator(wordlist_array []string, chunks int) [][]string {
 
 // STORAGE & DIRECTORY FUNCTIONS
 // function to build a new directory for a recon scan
-func BuildNewProgramDirectory(program_name string, date string, domains []string) {
+func BuildNewProgramDirectory(program_name string, date string, domains []string) error {
 	// this should work on every OS, not just linux.
-	out := output.NewConsoleOutput(true, nil)
 	path := "./Programs/" + program_name + "/" + date + "/top-level-domains"
 
 	err := os.MkdirAll(path, os.ModePerm)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	out.Writeln("<info>INFO - Created an output folder for: " + "<u>" + program_name + "</u>" + " -- (./Programs/" + program_name + "/" + date + ")</info>")
+	slog.Info("Created an output folder", "program", program_name, "path", "./Programs/"+program_name+"/"+date)
 	// original implementation:
 	//cmd := "mkdir -p ./Programs/" + program_name + "/" + date
 	//exec.Command("bash", "-c", cmd).Output()
+	return nil
 }
 
-// function to combine files in the scan folder
-func CombineFiles(tools []string, program_name string, date string) {
+// function to write the normalized domains list into the run directory (./Programs/<program>/<date>/domains.txt). this is the list passed to the enumeration tools.
+func WriteDomainsList(program_name string, date string, domains []string) (string, error) {
+	path := "./Programs/" + program_name + "/" + date + "/domains.txt"
+	var buf bytes.Buffer
+	for _, domain := range domains {
+		buf.WriteString(domain + "\n")
+	}
+	err := os.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
 
-	// open output file (file of all subdomains combined)
+// function to combine the outputs of the tools in the scan folder into all_enumerated_subdomains_combined.txt, sorted and without
+// duplicates, holding at most about memory bytes of them in memory
+func CombineFiles(tools []string, program_name string, date string, memory int64) error {
 	data_directory := "./Programs/" + program_name + "/" + date + "/"
 	files := []string{}
 	for _, v := range tools {
 		files = append(files, data_directory+v+".out")
 	}
-	var buf bytes.Buffer
-	for _, file := range files {
-		b, err := os.ReadFile(file)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		buf.Write(b)
-	}
-
-	err := os.WriteFile(data_directory+"all_enumerated_subdomains_combined.txt", buf.Bytes(), 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
-	// remove duplicates and re-write
-	/* wordlist_lines := WordlistToArray(data_directory + "all_enumerated_subdomains_combined.txt")
-	unique_wordlist := removeDuplicateString(wordlist_lines)
-
-	//create output file
-	output_file, err := os.OpenFile(data_directory+"all_enumerated_subdomains_combined_unique.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	count, err := SortUnique(files, data_directory+"all_enumerated_subdomains_combined.txt", memory)
 	if err != nil {
-		out.Writeln("<error>ERROR! \"</error>")
-		os.Exit(1)
+		return err
 	}
-
-	for _, line := range unique_wordlist {
-		output_file.WriteString(line + "\n")
-	} */
+	slog.Info("Combined the enumerated subdomains", "program", program_name, "path", data_directory+"all_enumerated_subdomains_combined.txt", "subdomains", count)
+	return nil
 }
 
-// function to combine all valid enumerated subdomains into one file "all_valid_subdomains_discovered.txt"
-func CreateFileOfAllValidSubdomainsCombined(program_name string, date string) {
-	out := output.NewConsoleOutput(true, nil)
-	// create string array of "<domain>-dnsgen-puredns.out" and "<domain>-puredns.out" file paths for each domain that was tested
-	var files []string
-
-	data_directory1 := "./Programs/" + program_name + "/" + date + "/puredns-stage-1.out"
-	data_directory2 := "./Programs/" + program_name + "/" + date + "/dnsgen-puredns.out"
-	files = append(files, data_directory1)
-	files = append(files, data_directory2)
+// function to combine all valid enumerated subdomains into "final_list.out", and the same sorted and without duplicates into
+// "final_list_unique.out", holding at most about memory bytes of them in memory
+func CreateFileOfAllValidSubdomainsCombined(program_name string, date string, memory int64) error {
+	data_directory := "./Programs/" + program_name + "/" + date + "/"
+	files := []string{data_directory + "puredns-stage-1.out", data_directory + "dnsgen-puredns.out"}
 
-	// create arbitrary sized buffer for data from files. then for each file, read its contents, write to buffer
-	var buf bytes.Buffer
-	for _, file := range files {
-		b, err := os.ReadFile(file)
+	// copy each file into the non-unique "final_list.out" in turn
+	output_file, err := os.Create(data_directory + "final_list.out")
+	if err != nil {
+		return err
+	}
+	defer output_file.Close()
+	for _, path := range files {
+		file, err := os.Open(path)
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		_, err = io.Copy(output_file, file)
+		file.Close()
+		if err != nil {
+			return err
 		}
-
-		buf.Write(b)
-	}
-	//create output file for non-unique "final_list.out"
-	data_directory := "./Programs/" + program_name + "/" + date + "/"
-	//output_file, err := os.OpenFile(data_directory + "final_list.out", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	//if err != nil {
-	//	log.Fatal(err)
-	//}
-
-	// write buffer to it.
-	err2 := os.WriteFile(data_directory+"final_list.out", buf.Bytes(), 0644)
-	if err2 != nil {
-		log.Fatal(err2)
 	}
-	// remove duplicates and re-write
-	wordlist_lines := WordlistToArray(data_directory + "final_list.out")
-	unique_wordlist := removeDuplicateString(wordlist_lines)
-
-	//create output file for "final_output_unique.out"
-	output_file2, err3 := os.OpenFile(data_directory+"final_list_unique.out", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err3 != nil {
-		log.Fatal(err3)
+	if err := output_file.Close(); err != nil {
+		return err
 	}
-	// write all unique lines from final_list.out to final_output_unqiue.out.
-	for _, line := range unique_wordlist {
-		output_file2.WriteString(line + "\n")
+
+	// "final_list_unique.out" is replaced, as a program run twice in a day shares a run directory
+	count, err := SortUnique(files, data_directory+"final_list_unique.out", memory)
+	if err != nil {
+		return err
 	}
-	out.Writeln("\t<info>INFO - Created unique final list of subdomains for " + program_name + ". (" + data_directory + "final_list_unique.out)</info>")
+	slog.Info("Created unique final list of subdomains", "program", program_name, "path", data_directory+"final_list_unique.out", "subdomains", count)
+	return nil
 }
 
 // function to separate the all_enumerated_subdomains_combined_unique.txt into separate files based on the top level domain, and place them into their respective folders in /top-level-domains. This is needed so that puredns can be run on each root-domain, for the wildcard filtering.
 func SeparateAllSubdomainsIntoSeparateFolders(program_name string, date string, domains []string) []string {
-	in := input.NewArgvInput(nil)
-	out := output.NewConsoleOutput(true, nil)
-	io := style.NewGoStyler(in, out)
 	// read all_enumerated_subdomains_combined_unique.txt into string array
-	io.Section("Beginning subdomain separation (separating enumerated subdomains into separate folders by domain.)")
+	slog.Info("Beginning subdomain separation (separating enumerated subdomains into separate folders by domain.)")
 	all_unique_subdomains := WordlistToArray("./Programs/" + program_name + "/" + date + "/all_enumerated_subdomains_combined_unique.txt")
 
 	sortedDomains := CatchRedundanciesInDomains(domains)
@@ -240,9 +251,10 @@ func SeparateAllSubdomainsIntoSeparateFolders(program_name string, date string,
 		path := "./Programs/" + program_name + "/" + date + "/top-level-domains/" + domain
 		err := os.Mkdir(path, os.ModePerm)
 		if err != nil {
-			log.Fatal(err)
+			slog.Error("Could not create a directory", "path", path, "error", err)
+			os.Exit(1)
 		}
-		out.Writeln("\t<info>INFO - Created a new directory for " + domain + ". (./Programs/" + program_name + "/" + date + "/top-level-domains/" + domain + ")</info>")
+		slog.Info("Created a new directory", "domain", domain, "path", path)
 	}
 
 	// for value in top level domains string array:
@@ -251,7 +263,8 @@ func SeparateAllSubdomainsIntoSeparateFolders(program_name string, date string,
 	for _, top_level_domain := range sortedDomains {
 		u, err := tld.Parse("https://" + top_level_domain + "/")
 		if err != nil {
-			log.Fatal(err)
+			slog.Error("Could not parse a domain", "domain", top_level_domain, "error", err)
+			os.Exit(1)
 		}
 		//fmt.Printf("%50s = [ %s ] [ %s ] [ %s]\n",
 		//	u, u.Subdomain, u.Domain, u.TLD)
@@ -270,10 +283,10 @@ func SeparateAllSubdomainsIntoSeparateFolders(program_name string, date string,
 		data_directory := "./Programs/" + program_name + "/" + date + "/"
 		output_file, err := os.OpenFile(data_directory+"top-level-domains/"+top_level_domain+"/"+top_level_domain+"-subdomains.out", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
-			log.Fatal(err)
+			slog.Error("Could not create a file", "error", err)
+			os.Exit(1)
 		}
-		s := regex.String()
-		out.Writeln("\t<info>INFO - Extracted values from all_enumerated_subdomains_combined_unique.txt matching regex " + s + " - Created file (" + data_directory + "top-level-domains/" + top_level_domain + "/" + top_level_domain + "-subdomains.out)</info>")
+		slog.Info("Extracted values from all_enumerated_subdomains_combined_unique.txt", "regex", regex.String(), "path", data_directory+"top-level-domains/"+top_level_domain+"/"+top_level_domain+"-subdomains.out")
 
 		for _, line := range subdomains_sorted_by_tld {
 			output_file.WriteString(line + "\n")